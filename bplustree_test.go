@@ -12,17 +12,15 @@ func TestInsertLeaf(t *testing.T) {
 	tr.Insert(1, 1)
 	tr.Insert(3, 3)
 
-	t.Logf("%+v", tr.root.keys)
-	t.Logf("%+v", tr.root.pointers...)
-
-	wkeys := []int{1, 2, 3}
-	if !reflect.DeepEqual(tr.root.keys, wkeys) {
-		t.Fatalf("expect keys: %+v but got: %+v", wkeys, tr.root.keys)
+	t.Logf("%s", tr.String())
+	wtree := "  (1,2,3)  \n"
+	if tr.String() != wtree {
+		t.Fatalf("expect tree %q but got: %q", wtree, tr.String())
 	}
 
-	wpointers := []interface{}{1, 2, 3, nil}
-	if !reflect.DeepEqual(tr.root.pointers, wpointers) {
-		t.Fatalf("expect pointers: %+v but got: %+v", wpointers, tr.root.pointers)
+	wentries := []kv{{1, 1}, {2, 2}, {3, 3}}
+	if !reflect.DeepEqual(entries(tr), wentries) {
+		t.Fatalf("expect entries: %+v but got: %+v", wentries, entries(tr))
 	}
 }
 
@@ -34,42 +32,23 @@ func TestSplitLeafRoot(t *testing.T) {
 	tr.Insert(5, 5)
 	tr.Insert(3, 3)
 
-	t.Logf("keys: %+v", tr.root.keys)
-	t.Logf("pointers: %+v", tr.root.pointers)
+	t.Logf("tree:\n%s", tr.String())
 
 	tr.Insert(6, 6)
-	if len(tr.root.pointers) != 2 {
-		t.Fatalf("expect root with 2 pointer but got %d", len(tr.root.pointers))
-	}
-
-	if len(tr.root.keys) != 1 {
-		t.Fatalf("expect root with 1 key but got %d", len(tr.root.keys))
-	}
-
-	if tr.root.keys[0] != 4 {
-		t.Fatalf("expect first key in root node to be 4 but got %d", tr.root.keys[0])
-	}
-
-	c1 := tr.root.pointers[0].(*tnode)
-	c2 := tr.root.pointers[1].(*tnode)
-	wkeys := []int{1, 2, 3}
-	if !reflect.DeepEqual(wkeys, c1.keys) {
-		t.Fatalf("expect keys %+v but got %+v", wkeys, c1.keys)
+	wtree := "" +
+		"         (4)         \n" +
+		"  (1,2,3)    (4,5,6)  \n"
+	if tr.String() != wtree {
+		t.Fatalf("expect tree:\n%s\nbut got:\n%s", wtree, tr.String())
 	}
 
-	wpointers := []interface{}{1, 2, 3, c2}
-	if !reflect.DeepEqual(wpointers, c1.pointers) {
-		t.Fatalf("expect pointers %+v but got %+v", wpointers, c1.pointers)
+	wentries := []kv{{1, 1}, {2, 2}, {3, 3}, {4, 4}, {5, 5}, {6, 6}}
+	if !reflect.DeepEqual(entries(tr), wentries) {
+		t.Fatalf("expect entries: %+v but got: %+v", wentries, entries(tr))
 	}
 
-	wkeys = []int{4, 5, 6}
-	if !reflect.DeepEqual(wkeys, c2.keys) {
-		t.Fatalf("expect keys %+v but got %+v", wkeys, c2.keys)
-	}
-
-	wpointers = []interface{}{4, 5, 6, nil}
-	if !reflect.DeepEqual(wpointers, c2.pointers) {
-		t.Fatalf("expect pointers %+v but got %+v", wpointers, c2.pointers)
+	if err := tr.check(); err != nil {
+		t.Fatalf("broken tree: %+v", err)
 	}
 }
 
@@ -84,20 +63,18 @@ func TestSplitInternalNode(t *testing.T) {
 		t.Logf("tree after insert key: %d\n%s\n", key, tr.String())
 	}
 
-	c2 := tr.root.pointers[1].(*tnode)
-	if c2.isLeaf {
-		t.Fatalf("expect internal node but got leaf: %+v", c2)
-	}
-
-	if len(c2.pointers) != 2 {
-		t.Fatalf("expect 2 children but got %d", len(c2.pointers))
+	// the right internal node (7) has 2 children, parent pointers of
+	// which are verified by check
+	wtree := "" +
+		"               (5)               \n" +
+		"       (3)             (7)      \n" +
+		"  (1,2)    (3,4)    (5,6)    (7)  \n"
+	if tr.String() != wtree {
+		t.Fatalf("expect tree:\n%s\nbut got:\n%s", wtree, tr.String())
 	}
 
-	for _, p := range c2.pointers {
-		c := p.(*tnode)
-		if c.parent != c2 {
-			t.Fatalf("expect parent %+v but got %+v", c2, c.parent)
-		}
+	if err := tr.check(); err != nil {
+		t.Fatalf("broken tree: %+v", err)
 	}
 }
 
@@ -268,3 +245,41 @@ func TestDeleteInternalNodeBorrowRight(t *testing.T) {
 	}
 	t.Logf("b tree after deleting key 19:\n%s\n", tr.String())
 }
+
+func TestMigrate(t *testing.T) {
+	tr := newTree(t, 4, 20, 3)
+	data, err := tr.MarshalBinary()
+	if err != nil {
+		t.Fatalf("error marshaling tree: %+v", err)
+	}
+
+	// trees persisted by v1 are migrated after unmarshaling
+	old := &BPlusTree{}
+	if err := old.UnmarshalBinary(data); err != nil {
+		t.Fatalf("error unmarshaling tree: %+v", err)
+	}
+
+	want := entries(old)
+	nt := Migrate(old)
+	if err := nt.Check(); err != nil {
+		t.Fatalf("broken tree after migration: %+v", err)
+	}
+
+	got := []kv{}
+	nt.Range(-1<<63, 1<<63-1, func(key int64, value interface{}) bool {
+		got = append(got, kv{int(key), value})
+		return true
+	})
+	if !reflect.DeepEqual(got, want) || nt.Len() != 20 {
+		t.Fatalf("expect entries %+v but got %+v", want, got)
+	}
+
+	// the migrated tree is aliased, not copied
+	if err := old.Insert(2, 2); err != nil {
+		t.Fatalf("error inserting key 2: %+v", err)
+	}
+
+	if v, err := nt.Find(2); err != nil || v != 2 {
+		t.Fatalf("expect key 2 in migrated tree but got %v, %+v", v, err)
+	}
+}
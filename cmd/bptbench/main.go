@@ -0,0 +1,159 @@
+// Command bptbench runs the benchmark workloads of both trees, a map and
+// a sorted slice and prints ns/op of them as a table for every size, so
+// that fanouts can be compared with data.
+//
+//	$ bptbench -sizes 1000,100000 -fanouts 16,64,256 -benchtime 200ms
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"text/tabwriter"
+
+	"github.com/kikimo/BPlusTree/internal/bench"
+)
+
+type config struct {
+	kinds     []bench.Kind
+	workloads []bench.Workload
+	fanouts   []int
+	sizes     []int
+}
+
+func parseInts(s string) ([]int, error) {
+	var ns []int
+	for _, f := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil {
+			return nil, fmt.Errorf("illegal number %q: %w", f, err)
+		}
+		ns = append(ns, n)
+	}
+
+	return ns, nil
+}
+
+func joinInts(ns []int) string {
+	fs := make([]string, len(ns))
+	for i, n := range ns {
+		fs[i] = strconv.Itoa(n)
+	}
+
+	return strings.Join(fs, ",")
+}
+
+func parseConfig(kinds, workloads, fanouts, sizes string) (*config, error) {
+	c := &config{}
+	for _, name := range strings.Split(kinds, ",") {
+		k, ok := bench.FindKind(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown kind %q", name)
+		}
+		c.kinds = append(c.kinds, k)
+	}
+
+	if workloads == "all" {
+		c.workloads = bench.Workloads
+	} else {
+		for _, name := range strings.Split(workloads, ",") {
+			w, ok := bench.FindWorkload(name)
+			if !ok {
+				return nil, fmt.Errorf("unknown workload %q", name)
+			}
+			c.workloads = append(c.workloads, w)
+		}
+	}
+
+	var err error
+	if c.fanouts, err = parseInts(fanouts); err != nil {
+		return nil, err
+	}
+
+	for _, f := range c.fanouts {
+		if f < 3 {
+			return nil, fmt.Errorf("fanout should be at least 3: %d", f)
+		}
+	}
+
+	if c.sizes, err = parseInts(sizes); err != nil {
+		return nil, err
+	}
+
+	for _, n := range c.sizes {
+		if n < 1 {
+			return nil, fmt.Errorf("size should be positive: %d", n)
+		}
+	}
+
+	return c, nil
+}
+
+// measure returns ns/op of workload w on index of kind k
+type measure func(k bench.Kind, w bench.Workload, fanout, n int) int64
+
+// table prints a table of ns/op for every size in c, kinds independent
+// of fanout take a single row
+func table(out io.Writer, c *config, m measure) {
+	for _, n := range c.sizes {
+		fmt.Fprintf(out, "n=%d (ns/op)\n", n)
+		tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprint(tw, "kind\tfanout\t")
+		for _, w := range c.workloads {
+			fmt.Fprintf(tw, "%s\t", w.Name)
+		}
+		fmt.Fprintln(tw)
+
+		for _, k := range c.kinds {
+			fanouts := c.fanouts
+			if !k.Fanout {
+				fanouts = []int{0}
+			}
+
+			for _, f := range fanouts {
+				fanout := "-"
+				if k.Fanout {
+					fanout = strconv.Itoa(f)
+				}
+
+				fmt.Fprintf(tw, "%s\t%s\t", k.Name, fanout)
+				for _, w := range c.workloads {
+					fmt.Fprintf(tw, "%d\t", m(k, w, f, n))
+				}
+				fmt.Fprintln(tw)
+			}
+		}
+
+		tw.Flush()
+		fmt.Fprintln(out)
+	}
+}
+
+func main() {
+	testing.Init()
+	kinds := flag.String("kinds", "v1,v2,map,slice", "comma separated kinds of index: v1, v2, map, slice")
+	workloads := flag.String("workloads", "all", "comma separated workloads, or all")
+	fanouts := flag.String("fanouts", joinInts(bench.Fanouts), "comma separated fanouts of trees")
+	sizes := flag.String("sizes", "1000,100000", "comma separated numbers of keys")
+	benchtime := flag.String("benchtime", "1s", "run time of each benchmark, or Nx for N operations")
+	flag.Parse()
+
+	c, err := parseConfig(*kinds, *workloads, *fanouts, *sizes)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if err := flag.Set("test.benchtime", *benchtime); err != nil {
+		fmt.Fprintf(os.Stderr, "illegal benchtime %q: %v\n", *benchtime, err)
+		os.Exit(2)
+	}
+
+	table(os.Stdout, c, func(k bench.Kind, w bench.Workload, fanout, n int) int64 {
+		return bench.Measure(k, w, fanout, n).NsPerOp()
+	})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"strings"
+	"testing"
+
+	"github.com/kikimo/BPlusTree/internal/bench"
+)
+
+func TestTable(t *testing.T) {
+	c, err := parseConfig("v2,map", "find-hit,delete", "4,16", "10")
+	if err != nil {
+		t.Fatalf("error parsing config: %+v", err)
+	}
+
+	out := bytes.NewBuffer(nil)
+	table(out, c, func(k bench.Kind, w bench.Workload, fanout, n int) int64 {
+		return int64(fanout)
+	})
+
+	want := []string{
+		"n=10 (ns/op)",
+		"  kind  fanout  find-hit  delete",
+		"    v2       4         4       4",
+		"    v2      16        16      16",
+		"   map       -         0       0",
+		"",
+		"",
+	}
+
+	if got := out.String(); got != strings.Join(want, "\n") {
+		t.Fatalf("expect table:\n%s\nbut got:\n%s", strings.Join(want, "\n"), got)
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	cases := []struct {
+		kinds, workloads, fanouts, sizes string
+		want                             string
+	}{
+		{"v3", "all", "4", "10", `unknown kind "v3"`},
+		{"v2", "bogus", "4", "10", `unknown workload "bogus"`},
+		{"v2", "all", "2", "10", "fanout should be at least 3: 2"},
+		{"v2", "all", "4", "x", `illegal number "x"`},
+		{"v2", "all", "4", "0", "size should be positive: 0"},
+	}
+
+	for _, c := range cases {
+		_, err := parseConfig(c.kinds, c.workloads, c.fanouts, c.sizes)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Fatalf("expect error %q but got %+v", c.want, err)
+		}
+	}
+}
+
+func TestMeasure(t *testing.T) {
+	benchtime := flag.Lookup("test.benchtime").Value.String()
+	defer flag.Set("test.benchtime", benchtime)
+	if err := flag.Set("test.benchtime", "10x"); err != nil {
+		t.Fatalf("error setting benchtime: %+v", err)
+	}
+
+	for _, k := range bench.Kinds {
+		for _, w := range bench.Workloads {
+			if r := bench.Measure(k, w, 4, 20); r.N != 10 {
+				t.Fatalf("%s %s: expect 10 ops but got %d", k.Name, w.Name, r.N)
+			}
+		}
+	}
+}
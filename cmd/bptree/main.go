@@ -0,0 +1,248 @@
+// Command bptree is an interactive shell for exploring a B+ tree. It
+// prints the tree after every mutation, which makes split, merge and
+// borrow behavior easy to follow and bugs easy to reproduce.
+//
+//	$ bptree -fanout 4
+//	> insert 1 foo
+//	> range 1 10
+//	> dot
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	v2 "github.com/kikimo/BPlusTree/v2"
+)
+
+const usage = `commands:
+  insert <key> [value]  insert key with value, value defaults to key
+  delete <key>          delete key
+  find <key>            print value of key
+  range <lo> <hi>       print entries with key in [lo, hi]
+  print                 print tree
+  dot                   print tree in Graphviz DOT format
+  stats                 print tree statistics
+  check                 verify tree invariants
+  save <path>           save snapshot of tree into path
+  load <path>           load tree from snapshot or page file at path
+  help                  print this help
+  quit                  exit
+`
+
+type repl struct {
+	tr    *v2.BPlusTree
+	out   io.Writer
+	quiet bool
+}
+
+func parseKey(s string) (int64, error) {
+	key, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("illegal key %q: %w", s, err)
+	}
+
+	return key, nil
+}
+
+func (r *repl) printTree() {
+	if !r.quiet {
+		fmt.Fprint(r.out, r.tr.ToString())
+	}
+}
+
+// exec runs a single command line, errors are reported to user but
+// never stop the shell
+func (r *repl) exec(line string) (quit bool, err error) {
+	args := strings.Fields(line)
+	if len(args) == 0 || strings.HasPrefix(args[0], "#") {
+		return false, nil
+	}
+
+	cmd, args := args[0], args[1:]
+	nargs := map[string]int{
+		"insert": 1, "delete": 1, "find": 1, "range": 2, "save": 1, "load": 1,
+	}
+	if n, ok := nargs[cmd]; ok && len(args) < n {
+		return false, fmt.Errorf("%s expects %d arguments but got %d", cmd, n, len(args))
+	}
+
+	switch cmd {
+	case "insert":
+		key, err := parseKey(args[0])
+		if err != nil {
+			return false, err
+		}
+
+		value := args[0]
+		if len(args) > 1 {
+			value = strings.Join(args[1:], " ")
+		}
+
+		if err := r.tr.Insert(v2.NewEntry(key, value)); err != nil {
+			return false, err
+		}
+		r.printTree()
+
+	case "delete":
+		key, err := parseKey(args[0])
+		if err != nil {
+			return false, err
+		}
+
+		if err := r.tr.Delete(key); err != nil {
+			return false, err
+		}
+		r.printTree()
+
+	case "find":
+		key, err := parseKey(args[0])
+		if err != nil {
+			return false, err
+		}
+
+		v, err := r.tr.Find(key)
+		if err != nil {
+			return false, err
+		}
+		fmt.Fprintf(r.out, "%v\n", v)
+
+	case "range":
+		lo, err := parseKey(args[0])
+		if err != nil {
+			return false, err
+		}
+
+		hi, err := parseKey(args[1])
+		if err != nil {
+			return false, err
+		}
+
+		r.tr.Range(lo, hi, func(key int64, value interface{}) bool {
+			fmt.Fprintf(r.out, "%d: %v\n", key, value)
+			return true
+		})
+
+	case "print":
+		fmt.Fprint(r.out, r.tr.ToString())
+
+	case "dot":
+		return false, r.tr.WriteDOT(r.out, &v2.DOTOptions{ParentEdges: true})
+
+	case "stats":
+		st := r.tr.Stats()
+		fmt.Fprintf(r.out, "len: %d\nheight: %d\nleaf capacity: %d\ninternal capacity: %d\nleaf nodes: %d\ninternal nodes: %d\nfill factor: %.2f\n",
+			st.Len, st.Height, st.LeafCapacity, st.InternalCapacity, st.LeafNodes, st.InternalNodes, st.FillFactor)
+
+	case "check":
+		if err := r.tr.Check(); err != nil {
+			return false, err
+		}
+		fmt.Fprintln(r.out, "ok")
+
+	case "save":
+		f, err := os.Create(args[0])
+		if err != nil {
+			return false, err
+		}
+		defer f.Close()
+
+		if _, err := r.tr.WriteTo(f); err != nil {
+			return false, err
+		}
+		return false, f.Close()
+
+	case "load":
+		tr, err := loadTree(args[0])
+		if err != nil {
+			return false, err
+		}
+		r.tr = tr
+		r.printTree()
+
+	case "help":
+		fmt.Fprint(r.out, usage)
+
+	case "quit", "exit":
+		return true, nil
+
+	default:
+		return false, fmt.Errorf("unknown command %q, try help", cmd)
+	}
+
+	return false, nil
+}
+
+// loadTree loads tree from a snapshot written by WriteTo or a page
+// file written by SaveFile
+func loadTree(path string) (*v2.BPlusTree, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := &v2.BPlusTree{}
+	if _, err := tr.ReadFrom(bytes.NewReader(data)); err == nil {
+		return tr, nil
+	} else if bytes.HasPrefix(data, []byte("BPTSNAP")) {
+		return nil, err
+	}
+
+	return v2.OpenFile(path, &v2.FileOptions{VerifyOnOpen: true})
+}
+
+func (r *repl) run(in io.Reader, prompt bool) {
+	s := bufio.NewScanner(in)
+	for {
+		if prompt {
+			fmt.Fprint(r.out, "> ")
+		}
+
+		if !s.Scan() {
+			return
+		}
+
+		quit, err := r.exec(s.Text())
+		if err != nil {
+			fmt.Fprintf(r.out, "error: %v\n", err)
+		}
+
+		if quit {
+			return
+		}
+	}
+}
+
+func main() {
+	fanout := flag.Int("fanout", 4, "max number of pointers in a node")
+	load := flag.String("load", "", "load tree from snapshot or page file")
+	quiet := flag.Bool("quiet", false, "do not print tree after mutations")
+	flag.Parse()
+
+	var tr *v2.BPlusTree
+	var err error
+	if *load != "" {
+		tr, err = loadTree(*load)
+	} else {
+		tr, err = v2.NewTree(v2.WithFanout(*fanout))
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	r := &repl{tr: tr, out: os.Stdout, quiet: *quiet}
+	fi, _ := os.Stdin.Stat()
+	prompt := fi != nil && fi.Mode()&os.ModeCharDevice != 0
+	if prompt {
+		fmt.Fprint(r.out, usage)
+	}
+	r.run(os.Stdin, prompt)
+}
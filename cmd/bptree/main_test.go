@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v2 "github.com/kikimo/BPlusTree/v2"
+)
+
+func TestREPL(t *testing.T) {
+	tr, _ := v2.NewTree(v2.WithFanout(3))
+	out := bytes.NewBuffer(nil)
+	r := &repl{tr: tr, out: out}
+	snapshot := filepath.Join(t.TempDir(), "tree.snap")
+
+	script := []string{
+		"insert 3", "insert 1 foo bar", "insert 2", "insert 4", "insert 1",
+		"find 1", "find 9",
+		"range 2 3",
+		"delete 4", "check", "stats",
+		"save " + snapshot,
+		"delete 1", "delete 2", "delete 3",
+		"load " + snapshot, "find 1",
+		"bogus",
+		"quit",
+		"insert 10",
+	}
+	r.run(strings.NewReader(strings.Join(script, "\n")), false)
+	t.Logf("output:\n%s", out.String())
+
+	want := []string{
+		"(1,2)    (3,4)",
+		"error: duplicate key",
+		"foo bar\n",
+		"error: key not found",
+		"2: 2\n3: 3\n",
+		"ok\n",
+		"len: 3\nheight: 2\n",
+		`error: unknown command "bogus"`,
+	}
+
+	for _, w := range want {
+		if !strings.Contains(out.String(), w) {
+			t.Fatalf("expect %q in output", w)
+		}
+	}
+
+	if strings.Count(out.String(), "foo bar\n") != 2 {
+		t.Fatalf("expect key 1 to be restored from snapshot")
+	}
+
+	if _, err := r.tr.Find(10); err == nil {
+		t.Fatalf("expect commands after quit to be ignored")
+	}
+}
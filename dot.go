@@ -0,0 +1,37 @@
+package bplustree
+
+import (
+	"io"
+
+	v2 "github.com/kikimo/BPlusTree/v2"
+)
+
+// DOTOptions controls Graphviz rendering of WriteDOT.
+type DOTOptions struct {
+	// ParentEdges draws dotted edges from every node to its parent
+	ParentEdges bool
+	// NoSiblingEdges omits dashed edges between sibling leaves
+	NoSiblingEdges bool
+	// SearchPath highlights nodes and edges visited when searching
+	// for any of these keys
+	SearchPath []int
+}
+
+// WriteDOT writes structure of t into w in Graphviz DOT format, see
+// v2.BPlusTree.WriteDOT.
+func (t *BPlusTree) WriteDOT(w io.Writer, opts *DOTOptions) error {
+	if opts == nil {
+		return t.tr.WriteDOT(w, nil)
+	}
+
+	path := make([]int64, len(opts.SearchPath))
+	for i, k := range opts.SearchPath {
+		path[i] = int64(k)
+	}
+
+	return t.tr.WriteDOT(w, &v2.DOTOptions{
+		ParentEdges:    opts.ParentEdges,
+		NoSiblingEdges: opts.NoSiblingEdges,
+		SearchPath:     path,
+	})
+}
@@ -0,0 +1,35 @@
+package bplustree
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteDOT(t *testing.T) {
+	tr := newTree(t, 3, 4, 1)
+	buf := bytes.NewBuffer(nil)
+	if err := tr.WriteDOT(buf, &DOTOptions{SearchPath: []int{1}}); err != nil {
+		t.Fatalf("error writing dot: %+v", err)
+	}
+
+	// tree:
+	//      (3)
+	// (1,2)   (3,4)
+	dot := buf.String()
+	t.Logf("dot:\n%s", dot)
+	lines := []string{
+		`n0 [label="<p0>|3|<p1>", style=filled, fillcolor="#ffe08a"];`,
+		`n1 [label="1|2|<next>", style=filled, fillcolor="#ffe08a"];`,
+		`n2 [label="3|4|<next>"];`,
+		`n0:p0 -> n1 [color=red, penwidth=2];`,
+		`n0:p1 -> n2;`,
+		`n1:next -> n2 [style=dashed, constraint=false];`,
+	}
+
+	for _, l := range lines {
+		if !strings.Contains(dot, "\t"+l+"\n") {
+			t.Fatalf("expect line %q in dot output", l)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package bplustree
+
+import (
+	"errors"
+	"sort"
+	"testing"
+)
+
+const (
+	fuzzInsert = iota
+	fuzzDelete
+	fuzzFind
+	fuzzNumOps
+)
+
+// model is the reference the adapter is compared with
+type model struct {
+	values map[int]int
+	keys   []int // sorted
+}
+
+func (m *model) insert(key, value int) bool {
+	if _, ok := m.values[key]; ok {
+		return false
+	}
+
+	m.values[key] = value
+	pos := sort.SearchInts(m.keys, key)
+	m.keys = append(m.keys, 0)
+	copy(m.keys[pos+1:], m.keys[pos:])
+	m.keys[pos] = key
+	return true
+}
+
+func (m *model) delete(key int) bool {
+	if _, ok := m.values[key]; !ok {
+		return false
+	}
+
+	delete(m.values, key)
+	pos := sort.SearchInts(m.keys, key)
+	m.keys = append(m.keys[:pos], m.keys[pos+1:]...)
+	return true
+}
+
+// FuzzTrees decodes data into a fanout followed by a sequence of
+// operations, each of which takes two bytes: op and key. Fanouts below
+// 3 must be refused by NewTree. Every op is applied to the tree and the
+// model, results and content of the tree are compared with the model
+// after every step. The v2 package fuzzes the tree itself under all of
+// its options, this one covers the int keyed adapter.
+func FuzzTrees(f *testing.F) {
+	f.Add([]byte{3, 0, 0, 1, 0, 2, 0, 3, 1, 1, 1, 2})
+	f.Add([]byte{4, 0, 5, 0, 4, 0, 3, 0, 2, 0, 1, 1, 5, 1, 4})
+	f.Add([]byte{2, 0, 1})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) == 0 {
+			return
+		}
+
+		fanout := int(data[0] % 17)
+		tr, err := NewTree(fanout)
+		if fanout < 3 {
+			if err == nil {
+				t.Fatalf("expect error creating tree of fanout %d", fanout)
+			}
+
+			return
+		}
+
+		if err != nil {
+			t.Fatalf("error creating tree of fanout %d: %+v", fanout, err)
+		}
+
+		m := &model{values: map[int]int{}}
+		for step := 0; 2*step+2 < len(data); step++ {
+			op := data[2*step+1] % fuzzNumOps
+			key := int(int8(data[2*step+2]))
+			switch op {
+			case fuzzInsert:
+				ok := m.insert(key, step)
+				if err := tr.Insert(key, step); (err == nil) != ok {
+					t.Fatalf("step %d, insert key %d, expect success %t but got %+v", step, key, ok, err)
+				}
+			case fuzzDelete:
+				ok := m.delete(key)
+				if err := tr.Delete(key); (err == nil) != ok {
+					t.Fatalf("step %d, delete key %d, expect success %t but got %+v", step, key, ok, err)
+				}
+			case fuzzFind:
+				wv, ok := m.values[key]
+				if v, err := tr.Find(key); (ok && (err != nil || v != wv)) || (!ok && !errors.Is(err, ErrKeyNotFound)) {
+					t.Fatalf("step %d, find key %d, expect (%d, %t) but got (%+v, %+v)", step, key, wv, ok, v, err)
+				}
+			}
+
+			if err := tr.check(); err != nil {
+				t.Fatalf("step %d, broken tree:\n%s\nerror: %+v", step, tr.String(), err)
+			}
+
+			compareContent(t, step, m, tr)
+		}
+	})
+}
+
+func compareContent(t *testing.T, step int, m *model, tr *BPlusTree) {
+	i := 0
+	tr.walk(func(key int, p interface{}) {
+		if i >= len(m.keys) || key != m.keys[i] || p != m.values[key] {
+			t.Fatalf("step %d, entry %d, expect keys %+v but got (%d, %+v)", step, i, m.keys, key, p)
+		}
+		i++
+	})
+	if i != len(m.keys) {
+		t.Fatalf("step %d, expect %d keys but got %d", step, len(m.keys), i)
+	}
+}
@@ -0,0 +1,350 @@
+// Package bench holds workloads comparing both trees against a map and
+// a sorted slice. They are run by the benchmarks of this package and by
+// cmd/bptbench, which prints them as a table.
+package bench
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	bplustree "github.com/kikimo/BPlusTree"
+	v2 "github.com/kikimo/BPlusTree/v2"
+)
+
+// Fanouts and Sizes are the tree fanouts and numbers of keys
+// benchmarked by default
+var (
+	Fanouts = []int{4, 8, 16, 32, 64, 128, 256}
+	Sizes   = []int{1e3, 1e4, 1e5, 1e6, 1e7}
+)
+
+// Index is an int64 keyed set of values under benchmark
+type Index interface {
+	// Insert inserts key, it is a no-op if key exists
+	Insert(key int64, value interface{})
+	Find(key int64) (interface{}, bool)
+	// Delete deletes key and tells whether it exists
+	Delete(key int64) bool
+}
+
+// Kind is a kind of index, Fanout tells whether indexes created by New
+// depend on fanout
+type Kind struct {
+	Name   string
+	Fanout bool
+	New    func(fanout int) Index
+}
+
+var Kinds = []Kind{
+	{Name: "v1", Fanout: true, New: newV1},
+	{Name: "v2", Fanout: true, New: newV2},
+	{Name: "map", New: newMap},
+	{Name: "slice", New: newSlice},
+}
+
+// FindKind returns kind of name
+func FindKind(name string) (Kind, bool) {
+	for _, k := range Kinds {
+		if k.Name == name {
+			return k, true
+		}
+	}
+
+	return Kind{}, false
+}
+
+// v1Index goes through the adapter of the root package, so that its cost
+// over v2 is measured
+type v1Index struct {
+	tr *bplustree.BPlusTree
+}
+
+func newV1(fanout int) Index {
+	tr, err := bplustree.NewTree(fanout)
+	if err != nil {
+		panic(err)
+	}
+
+	return v1Index{tr: tr}
+}
+
+func (x v1Index) Insert(key int64, value interface{}) {
+	x.tr.Insert(int(key), value)
+}
+
+func (x v1Index) Find(key int64) (interface{}, bool) {
+	v, err := x.tr.Find(int(key))
+	return v, err == nil
+}
+
+func (x v1Index) Delete(key int64) bool {
+	return x.tr.Delete(int(key)) == nil
+}
+
+type v2Index struct {
+	tr *v2.BPlusTree
+}
+
+func newV2(fanout int) Index {
+	tr, err := v2.NewTree(v2.WithFanout(fanout))
+	if err != nil {
+		panic(err)
+	}
+
+	return v2Index{tr: tr}
+}
+
+func (x v2Index) Insert(key int64, value interface{}) {
+	x.tr.Insert(v2.NewEntry(key, value))
+}
+
+func (x v2Index) Find(key int64) (interface{}, bool) {
+	v, err := x.tr.Find(key)
+	return v, err == nil
+}
+
+func (x v2Index) Delete(key int64) bool {
+	return x.tr.Delete(key) == nil
+}
+
+type mapIndex map[int64]interface{}
+
+func newMap(int) Index {
+	return mapIndex{}
+}
+
+func (x mapIndex) Insert(key int64, value interface{}) {
+	if _, ok := x[key]; !ok {
+		x[key] = value
+	}
+}
+
+func (x mapIndex) Find(key int64) (interface{}, bool) {
+	v, ok := x[key]
+	return v, ok
+}
+
+func (x mapIndex) Delete(key int64) bool {
+	_, ok := x[key]
+	delete(x, key)
+	return ok
+}
+
+// sliceIndex keeps keys sorted, values are in the same order
+type sliceIndex struct {
+	keys   []int64
+	values []interface{}
+}
+
+func newSlice(int) Index {
+	return &sliceIndex{}
+}
+
+func (x *sliceIndex) search(key int64) (int, bool) {
+	i := sort.Search(len(x.keys), func(i int) bool { return x.keys[i] >= key })
+	return i, i < len(x.keys) && x.keys[i] == key
+}
+
+func (x *sliceIndex) Insert(key int64, value interface{}) {
+	i, ok := x.search(key)
+	if ok {
+		return
+	}
+
+	x.keys = append(x.keys, 0)
+	copy(x.keys[i+1:], x.keys[i:])
+	x.keys[i] = key
+	x.values = append(x.values, nil)
+	copy(x.values[i+1:], x.values[i:])
+	x.values[i] = value
+}
+
+func (x *sliceIndex) Find(key int64) (interface{}, bool) {
+	i, ok := x.search(key)
+	if !ok {
+		return nil, false
+	}
+
+	return x.values[i], true
+}
+
+func (x *sliceIndex) Delete(key int64) bool {
+	i, ok := x.search(key)
+	if !ok {
+		return false
+	}
+
+	x.keys = append(x.keys[:i], x.keys[i+1:]...)
+	x.values = append(x.values[:i], x.values[i+1:]...)
+	return true
+}
+
+// Workload runs b.N operations on indexes created by newIndex, n is the
+// number of keys the indexes hold
+type Workload struct {
+	Name string
+	Run  func(b *testing.B, newIndex func() Index, n int)
+}
+
+var Workloads = []Workload{
+	{Name: "insert-seq", Run: insert(sequentialKeys)},
+	{Name: "insert-rand", Run: insert(randomKeys)},
+	{Name: "insert-zipf", Run: insert(zipfKeys)},
+	{Name: "find-hit", Run: find(true)},
+	{Name: "find-miss", Run: find(false)},
+	{Name: "delete", Run: deleteKeys},
+	{Name: "mixed-50", Run: mixed(50)},
+	{Name: "mixed-90", Run: mixed(90)},
+	{Name: "mixed-99", Run: mixed(99)},
+}
+
+// FindWorkload returns workload of name
+func FindWorkload(name string) (Workload, bool) {
+	for _, w := range Workloads {
+		if w.Name == name {
+			return w, true
+		}
+	}
+
+	return Workload{}, false
+}
+
+// Measure runs workload w on indexes of kind k holding n keys
+func Measure(k Kind, w Workload, fanout, n int) testing.BenchmarkResult {
+	return testing.Benchmark(func(b *testing.B) {
+		b.ReportAllocs()
+		w.Run(b, func() Index { return k.New(fanout) }, n)
+	})
+}
+
+// value is the value of all keys, so that boxing it is not measured
+var value interface{} = "value"
+
+// keys are even numbers less than 2n, leaving odd numbers for misses
+type keyGen func(r *rand.Rand, n int) []int64
+
+func sequentialKeys(r *rand.Rand, n int) []int64 {
+	keys := make([]int64, n)
+	for i := range keys {
+		keys[i] = int64(2 * i)
+	}
+
+	return keys
+}
+
+func randomKeys(r *rand.Rand, n int) []int64 {
+	keys := make([]int64, n)
+	for i, k := range r.Perm(n) {
+		keys[i] = int64(2 * k)
+	}
+
+	return keys
+}
+
+// zipfKeys repeats some keys a lot, inserting them again is a no-op
+func zipfKeys(r *rand.Rand, n int) []int64 {
+	z := rand.NewZipf(r, 1.1, 1, uint64(n-1))
+	keys := make([]int64, n)
+	for i := range keys {
+		keys[i] = int64(2 * z.Uint64())
+	}
+
+	return keys
+}
+
+func newRand() *rand.Rand {
+	return rand.New(rand.NewSource(1))
+}
+
+// prefill returns index holding n random keys and the keys
+func prefill(newIndex func() Index, n int) (Index, []int64) {
+	keys := randomKeys(newRand(), n)
+	x := newIndex()
+	for _, k := range keys {
+		x.Insert(k, value)
+	}
+
+	return x, keys
+}
+
+// insert inserts keys of gen into an index, which is replaced by an
+// empty one after n inserts
+func insert(gen keyGen) func(b *testing.B, newIndex func() Index, n int) {
+	return func(b *testing.B, newIndex func() Index, n int) {
+		keys := gen(newRand(), n)
+		var x Index
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if i%n == 0 {
+				b.StopTimer()
+				x = newIndex()
+				b.StartTimer()
+			}
+			x.Insert(keys[i%n], value)
+		}
+	}
+}
+
+func find(hit bool) func(b *testing.B, newIndex func() Index, n int) {
+	return func(b *testing.B, newIndex func() Index, n int) {
+		x, keys := prefill(newIndex, n)
+		if !hit {
+			for i := range keys {
+				keys[i]++
+			}
+		}
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, ok := x.Find(keys[i%n]); ok != hit {
+				b.Fatalf("expect key %d found %t", keys[i%n], hit)
+			}
+		}
+	}
+}
+
+// deleteKeys deletes keys of an index in random order, which is filled
+// up again once empty
+func deleteKeys(b *testing.B, newIndex func() Index, n int) {
+	x, keys := prefill(newIndex, n)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i > 0 && i%n == 0 {
+			b.StopTimer()
+			x, keys = prefill(newIndex, n)
+			b.StartTimer()
+		}
+
+		if !x.Delete(keys[i%n]) {
+			b.Fatalf("expect key %d deleted", keys[i%n])
+		}
+	}
+}
+
+// mixed finds random keys in readPct percent of operations, the rest
+// delete random keys or insert them if missing, keeping about n keys
+func mixed(readPct int) func(b *testing.B, newIndex func() Index, n int) {
+	return func(b *testing.B, newIndex func() Index, n int) {
+		x, _ := prefill(newIndex, n)
+		r := newRand()
+		ops := make([]int64, 1<<16)
+		for i := range ops {
+			ops[i] = r.Int63n(int64(2 * n))
+			if r.Intn(100) >= readPct {
+				// negative for writes
+				ops[i] = -ops[i] - 1
+			}
+		}
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			op := ops[i%len(ops)]
+			if op >= 0 {
+				x.Find(op)
+			} else if key := -op - 1; !x.Delete(key) {
+				x.Insert(key, value)
+			}
+		}
+	}
+}
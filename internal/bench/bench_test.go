@@ -0,0 +1,82 @@
+package bench
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestIndexes(t *testing.T) {
+	for _, k := range Kinds {
+		x := k.New(4)
+		model := map[int64]interface{}{}
+		r := rand.New(rand.NewSource(1))
+		for i := 0; i < 5000; i++ {
+			key := r.Int63n(500)
+			_, found := model[key]
+			switch r.Intn(3) {
+			case 0:
+				if ok := x.Delete(key); ok != found {
+					t.Fatalf("%s: expect key %d deleted %t but got %t", k.Name, key, found, ok)
+				}
+				delete(model, key)
+			case 1:
+				x.Insert(key, i)
+				if !found {
+					model[key] = i
+				}
+			}
+
+			want, found := model[key]
+			if v, ok := x.Find(key); ok != found || v != want {
+				t.Fatalf("%s: expect value %v of key %d but got %v", k.Name, want, key, v)
+			}
+		}
+	}
+}
+
+// benchKind runs all workloads on kind, sizes larger than 1e5 are
+// skipped in short mode
+func benchKind(b *testing.B, name string) {
+	k, _ := FindKind(name)
+	fanouts := Fanouts
+	if !k.Fanout {
+		fanouts = []int{0}
+	}
+
+	for _, w := range Workloads {
+		for _, n := range Sizes {
+			if testing.Short() && n > 1e5 {
+				continue
+			}
+
+			for _, f := range fanouts {
+				sub := fmt.Sprintf("%s/n=%d", w.Name, n)
+				if k.Fanout {
+					sub += fmt.Sprintf("/fanout=%d", f)
+				}
+
+				b.Run(sub, func(b *testing.B) {
+					b.ReportAllocs()
+					w.Run(b, func() Index { return k.New(f) }, n)
+				})
+			}
+		}
+	}
+}
+
+func BenchmarkV1(b *testing.B) {
+	benchKind(b, "v1")
+}
+
+func BenchmarkV2(b *testing.B) {
+	benchKind(b, "v2")
+}
+
+func BenchmarkMap(b *testing.B) {
+	benchKind(b, "map")
+}
+
+func BenchmarkSlice(b *testing.B) {
+	benchKind(b, "slice")
+}
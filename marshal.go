@@ -0,0 +1,149 @@
+package bplustree
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// snapshot is the gob encoded form of BPlusTree, concrete value types
+// other than the gob builtin ones must be registered with gob.Register.
+type snapshot struct {
+	N      int
+	Keys   []int
+	Values []interface{}
+}
+
+// walk calls fn for every entry in ascending key order
+func (t *BPlusTree) walk(fn func(key int, p interface{})) {
+	if t.tr == nil {
+		return
+	}
+
+	t.tr.Range(math.MinInt64, math.MaxInt64, func(key int64, p interface{}) bool {
+		fn(int(key), p)
+		return true
+	})
+}
+
+// load replaces content of t with entries sorted by key
+func (t *BPlusTree) load(n int, keys []int, values []interface{}) error {
+	if len(keys) != len(values) {
+		return fmt.Errorf("%d keys mismatch %d values", len(keys), len(values))
+	}
+
+	nt, err := NewTree(n)
+	if err != nil {
+		return err
+	}
+
+	for i, k := range keys {
+		if err := nt.Insert(k, values[i]); err != nil {
+			return err
+		}
+	}
+
+	*t = *nt
+	return nil
+}
+
+func (t *BPlusTree) MarshalBinary() ([]byte, error) {
+	s := snapshot{N: t.n}
+	t.walk(func(key int, p interface{}) {
+		s.Keys = append(s.Keys, key)
+		s.Values = append(s.Values, p)
+	})
+
+	buf := bytes.NewBuffer(nil)
+	if err := gob.NewEncoder(buf).Encode(&s); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (t *BPlusTree) UnmarshalBinary(data []byte) error {
+	s := snapshot{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&s); err != nil {
+		return err
+	}
+
+	return t.load(s.N, s.Keys, s.Values)
+}
+
+func (t *BPlusTree) GobEncode() ([]byte, error) {
+	return t.MarshalBinary()
+}
+
+func (t *BPlusTree) GobDecode(data []byte) error {
+	return t.UnmarshalBinary(data)
+}
+
+type jsonEntry struct {
+	Key   int             `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+type jsonTree struct {
+	MaxSize int         `json:"maxSize"`
+	Entries []jsonEntry `json:"entries"`
+}
+
+// MarshalJSON encodes t as an object holding n of the tree and its
+// entries sorted by key, e.g.:
+//
+//	{"maxSize":4,"entries":[{"key":1,"value":"foo"},{"key":2,"value":"bar"}]}
+func (t *BPlusTree) MarshalJSON() ([]byte, error) {
+	jt := jsonTree{MaxSize: t.n, Entries: []jsonEntry{}}
+	var err error
+	t.walk(func(key int, p interface{}) {
+		if err != nil {
+			return
+		}
+
+		var v []byte
+		if v, err = json.Marshal(p); err != nil {
+			err = fmt.Errorf("error encoding value of key %d: %w", key, err)
+			return
+		}
+
+		jt.Entries = append(jt.Entries, jsonEntry{Key: key, Value: v})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(&jt)
+}
+
+// UnmarshalJSON replaces content of t with entries encoded by
+// MarshalJSON, values are decoded into the generic json types.
+func (t *BPlusTree) UnmarshalJSON(data []byte) error {
+	jt := jsonTree{}
+	if err := json.Unmarshal(data, &jt); err != nil {
+		return err
+	}
+
+	sort.SliceStable(jt.Entries, func(i, j int) bool {
+		return jt.Entries[i].Key < jt.Entries[j].Key
+	})
+
+	keys := make([]int, len(jt.Entries))
+	values := make([]interface{}, len(jt.Entries))
+	for i, e := range jt.Entries {
+		keys[i] = e.Key
+		if len(e.Value) == 0 {
+			continue
+		}
+
+		if err := json.Unmarshal(e.Value, &values[i]); err != nil {
+			return fmt.Errorf("error decoding value of key %d: %w", e.Key, err)
+		}
+	}
+
+	return t.load(jt.MaxSize, keys, values)
+}
@@ -0,0 +1,134 @@
+package v2
+
+import (
+	"fmt"
+	"math"
+)
+
+var ErrNoMonoid error = fmt.Errorf("no monoid to aggregate with")
+
+// Monoid summarizes entries of a tree for Aggregate. Combine should be
+// associative with Identity as its identity element, Lift maps an entry
+// to its summary and defaults to the value of entry if nil.
+type Monoid struct {
+	Identity interface{}
+	Combine  func(a, b interface{}) interface{}
+	Lift     func(key int64, value interface{}) interface{}
+}
+
+// Monoids of common aggregates, Sum, Min and Max expect float64 values
+var (
+	Count = Monoid{
+		Identity: 0,
+		Combine:  func(a, b interface{}) interface{} { return a.(int) + b.(int) },
+		Lift:     func(int64, interface{}) interface{} { return 1 },
+	}
+	Sum = Monoid{
+		Identity: 0.0,
+		Combine:  func(a, b interface{}) interface{} { return a.(float64) + b.(float64) },
+	}
+	Min = Monoid{
+		Identity: math.Inf(1),
+		Combine:  func(a, b interface{}) interface{} { return math.Min(a.(float64), b.(float64)) },
+	}
+	Max = Monoid{
+		Identity: math.Inf(-1),
+		Combine:  func(a, b interface{}) interface{} { return math.Max(a.(float64), b.(float64)) },
+	}
+)
+
+func (m *Monoid) lift(key int64, value interface{}) interface{} {
+	if m.Lift == nil {
+		return value
+	}
+
+	return m.Lift(key, value)
+}
+
+// unsummarize drops cached summaries of tn and its ancestors after tn
+// changes. A summarized node only has summarized descendants, so it
+// stops at the first node that is not summarized.
+func (tn *tNode) unsummarize() {
+	for p := tn; p != nil && p.summarized; p = p.parent {
+		p.summary, p.summarized = nil, false
+	}
+}
+
+// summarize returns aggregate of all entries of subtree tn, summaries
+// are cached in nodes until they change
+func (tn *tNode) summarize(m *Monoid) interface{} {
+	if tn.summarized {
+		return tn.summary
+	}
+
+	acc := m.Identity
+	if tn.isLeaf {
+		// the last entry of leaf points to sibling
+		for i, k := range tn.keys[:len(tn.keys)-1] {
+			acc = m.Combine(acc, m.lift(k, tn.ptrs[i]))
+		}
+	} else {
+		for i := range tn.ptrs {
+			acc = m.Combine(acc, tn.child(i).summarize(m))
+		}
+	}
+
+	tn.summary, tn.summarized = acc, true
+	return acc
+}
+
+// Aggregate combines entries with keys in [lo, hi] in ascending order by
+// the monoid set by WithAggregate. Subtrees entirely in range take their
+// cached summaries, so it visits O(log n) nodes once they are cached.
+// Summaries are cached on read, so Aggregate must not run concurrently
+// with other reads.
+func (tr *BPlusTree) Aggregate(lo, hi int64) (interface{}, error) {
+	m := tr.cfg.monoid
+	if m == nil {
+		return nil, ErrNoMonoid
+	}
+
+	return tr.aggregate(tr.root, m, lo, hi, false, false), nil
+}
+
+// aggregate combines entries of subtree tn with keys in [lo, hi], all
+// keys of tn are known to be no less than lo if fromLo and no greater
+// than hi if toHi
+func (tr *BPlusTree) aggregate(tn *tNode, m *Monoid, lo, hi int64, fromLo, toHi bool) interface{} {
+	if fromLo && toHi {
+		return tn.summarize(m)
+	}
+
+	acc := m.Identity
+	if tn.isLeaf {
+		pos := 0
+		if !fromLo {
+			pos = tn.findLeafInsertPos(lo)
+		}
+
+		for ; pos < len(tn.keys)-1; pos++ {
+			if !toHi && tr.cfg.compare(tn.keys[pos], hi) > 0 {
+				break
+			}
+			acc = m.Combine(acc, m.lift(tn.keys[pos], tn.ptrs[pos]))
+		}
+
+		return acc
+	}
+
+	s, e := 0, len(tn.keys)-1
+	if !fromLo {
+		s = tn.findChildPos(lo)
+	}
+	if !toHi {
+		e = tn.findChildPos(hi)
+	}
+
+	// children between the ones lo and hi fall into are entirely in
+	// range
+	for i := s; i <= e; i++ {
+		acc = m.Combine(acc, tr.aggregate(tn.child(i), m, lo, hi, fromLo || i > s, toHi || i < e))
+	}
+
+	return acc
+}
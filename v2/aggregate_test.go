@@ -0,0 +1,172 @@
+package v2
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// concat lists entries in order, so that aggregates check both order
+// and values of entries
+var concat = Monoid{
+	Identity: "",
+	Combine:  func(a, b interface{}) interface{} { return a.(string) + b.(string) },
+	Lift:     func(key int64, value interface{}) interface{} { return fmt.Sprintf("%d=%v,", key, value) },
+}
+
+func concatModel(model map[int64]int, lo, hi int64) string {
+	keys := []int64{}
+	for k := range model {
+		if k >= lo && k <= hi {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	var sb strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&sb, "%d=%d,", k, model[k])
+	}
+
+	return sb.String()
+}
+
+func TestAggregateRandom(t *testing.T) {
+	cases := [][]Option{
+		{WithFanout(3)},
+		{WithFanout(4), WithDupPolicy(DupReplace)},
+		{WithFanout(7), WithBStar()},
+		{WithFanout(16), WithMinFill(0.2), WithDupPolicy(DupReplace)},
+	}
+
+	for i, opts := range cases {
+		tr, err := NewTree(append(opts, WithAggregate(concat))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		model := map[int64]int{}
+		r := rand.New(rand.NewSource(int64(i)))
+		for step := 0; step < 3000; step++ {
+			key := r.Int63n(300)
+			switch r.Intn(5) {
+			case 0:
+				if tr.Delete(key) == nil {
+					delete(model, key)
+				}
+			case 1:
+				var kvs []KV
+				for j := 0; j < 8; j++ {
+					kvs = append(kvs, KV{Key: key + r.Int63n(20), Value: step})
+				}
+				// keys in tree fail the batch unless replaced
+				tr.InsertBatch(kvs, nil)
+				for _, kv := range kvs {
+					if _, ok := model[kv.Key]; !ok || tr.cfg.dup == DupReplace {
+						model[kv.Key] = step
+					}
+				}
+			default:
+				if tr.Insert(&Entry{key: key, pointer: step}) == nil || tr.cfg.dup == DupReplace {
+					model[key] = step
+				}
+			}
+
+			lo := r.Int63n(320) - 10
+			hi := lo + r.Int63n(200)
+			if step%100 == 0 {
+				lo, hi = minKey, maxKey
+			}
+
+			got, err := tr.Aggregate(lo, hi)
+			if want := concatModel(model, lo, hi); err != nil || got != want {
+				t.Fatalf("case %d, step %d: expect aggregate of [%d, %d] %q but got %q, %+v\n%s", i, step, lo, hi, want, got, err, tr.ToString())
+			}
+		}
+	}
+}
+
+func TestAggregateMonoids(t *testing.T) {
+	tr, _ := NewTree(WithFanout(4))
+	if _, err := tr.Aggregate(minKey, maxKey); !errors.Is(err, ErrNoMonoid) {
+		t.Fatalf("expect error %+v but got %+v", ErrNoMonoid, err)
+	}
+
+	if _, err := NewTree(WithAggregate(Monoid{Identity: 0})); err == nil {
+		t.Fatalf("expect error of monoid without combine function but got none")
+	}
+
+	cases := []struct {
+		m          Monoid
+		full, part interface{}
+	}{
+		{Count, 10, 4},
+		{Sum, 55.0, 18.0},
+		{Min, 1.0, 3.0},
+		{Max, 10.0, 6.0},
+	}
+
+	for _, c := range cases {
+		tr, _ := NewTree(WithFanout(3), WithAggregate(c.m))
+		if got, _ := tr.Aggregate(minKey, maxKey); got != c.m.Identity {
+			t.Fatalf("expect aggregate %v of empty tree but got %v", c.m.Identity, got)
+		}
+
+		for k := 1; k <= 10; k++ {
+			tr.Insert(&Entry{key: int64(k), pointer: float64(k)})
+		}
+
+		if got, _ := tr.Aggregate(minKey, maxKey); got != c.full {
+			t.Fatalf("expect aggregate %v but got %v", c.full, got)
+		}
+
+		if got, _ := tr.Aggregate(3, 6); got != c.part {
+			t.Fatalf("expect aggregate %v of [3, 6] but got %v", c.part, got)
+		}
+
+		if got, _ := tr.Aggregate(6, 3); got != c.m.Identity {
+			t.Fatalf("expect aggregate %v of empty range but got %v", c.m.Identity, got)
+		}
+	}
+}
+
+func TestAggregateCached(t *testing.T) {
+	combines := 0
+	m := Count
+	m.Combine = func(a, b interface{}) interface{} {
+		combines++
+		return a.(int) + b.(int)
+	}
+
+	const fanout = 8
+	tr, _ := NewTree(WithFanout(fanout), WithAggregate(m))
+	for k := 0; k < 100000; k++ {
+		tr.Insert(&Entry{key: int64(k), pointer: k})
+	}
+
+	height := 0
+	for tn := tr.root; !tn.isLeaf; tn = tn.child(0) {
+		height++
+	}
+
+	// the first aggregate summarizes the whole tree
+	tr.Aggregate(minKey, maxKey)
+	for _, k := range []int64{10, 5000, 99990} {
+		tr.Delete(k)
+		tr.Insert(&Entry{key: k, pointer: k})
+
+		combines = 0
+		if got, _ := tr.Aggregate(7, 99997); got != 99991 {
+			t.Fatalf("expect 99991 keys but got %v", got)
+		}
+
+		// nodes on the path of the changed key are summarized again
+		// and both ends of range visit at most a node per level
+		if max := 3 * fanout * (height + 1); combines > max {
+			t.Fatalf("expect at most %d combines but got %d", max, combines)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package v2
+
+// minAppendStreak is the number of consecutive appends after which a
+// full rightmost node is split unevenly, keeping the old node full and
+// starting the new one almost empty
+const minAppendStreak = 2
+
+// rightmostLeaf returns the rightmost leaf of tr
+func (tr *BPlusTree) rightmostLeaf() *tNode {
+	if tr.last != nil && tr.onRightSpine(tr.last) {
+		return tr.last
+	}
+
+	tn := tr.root
+	for !tn.isLeaf {
+		tn = tn.child(len(tn.ptrs) - 1)
+	}
+
+	tr.last = tn
+	return tn
+}
+
+// onRightSpine tells whether tn is the last child of every node on its
+// path to root
+func (tr *BPlusTree) onRightSpine(tn *tNode) bool {
+	for tn.parent != nil {
+		p := tn.parent
+		if p.ptrs[len(p.ptrs)-1] != tn {
+			return false
+		}
+		tn = p
+	}
+
+	return tn == tr.root
+}
+
+// appendLeaf returns the rightmost leaf if key is greater than all keys
+// in tr, nil otherwise
+func (tr *BPlusTree) appendLeaf(key int64) *tNode {
+	leaf := tr.rightmostLeaf()
+	n := len(leaf.keys) - 1
+	if n == 0 {
+		// only the root leaf of an empty tree has no keys
+		if leaf != tr.root {
+			return nil
+		}
+		return leaf
+	}
+
+	if tr.cfg.compare(key, leaf.keys[n-1]) <= 0 {
+		return nil
+	}
+
+	return leaf
+}
+
+// tryAppend appends e to the rightmost leaf without searching from
+// root. A full leaf is only handled here if appends go on, splits of
+// it and its full ancestors leave the old nodes full. It reports
+// whether e is inserted.
+func (tr *BPlusTree) tryAppend(leaf *tNode, e *Entry) bool {
+	full := len(leaf.keys) == tr.cfg.maxEntries(true)
+	if full && tr.appends < minAppendStreak {
+		return false
+	}
+
+	// move sibling pointer, nil for the rightmost leaf, one step right
+	n := len(leaf.keys)
+	leaf.resize(n + 1)
+	leaf.setEntry(n, leaf.entry(n-1))
+	leaf.setEntry(n-1, *e)
+	if !full {
+		return true
+	}
+
+	// keep leafCap keys in the old leaf and move the new one
+	ne := leaf.splitLeafNodeAt(n - 1)
+	tr.last = ne.pointer.(*tNode)
+	tr.last.appended = true
+	if tr.obs != nil {
+		tr.obs.OnLeafSplit(leaf.keyList(), ne.pointer.(*tNode).keyList())
+	}
+
+	tn := leaf
+	for tn.parent != nil {
+		p := tn.parent
+		p.insertAt(p.size(), &ne)
+		if !p.overflowed() {
+			return true
+		}
+
+		// the new node takes the last two children
+		ne = p.splitInternalNodeAt(p.size() - 2)
+		ne.pointer.(*tNode).appended = true
+		if tr.obs != nil {
+			tr.obs.OnInternalSplit(p.keyList(), ne.key, ne.pointer.(*tNode).keyList())
+		}
+		tn = p
+	}
+
+	tr.growRoot(ne)
+	return true
+}
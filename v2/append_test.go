@@ -0,0 +1,190 @@
+package v2
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestAppendFill(t *testing.T) {
+	for _, fanout := range []int{3, 4, 8, 64} {
+		for _, bstar := range []bool{false, true} {
+			opts := []Option{WithFanout(fanout), WithAppendFastPath()}
+			if bstar {
+				opts = append(opts, WithBStar())
+			}
+
+			tr, _ := NewTree(opts...)
+			for i := int64(0); i < 5000; i++ {
+				if err := tr.Insert(&Entry{key: i, pointer: i}); err != nil {
+					t.Fatalf("error inserting key %d: %+v", i, err)
+				}
+			}
+
+			if err := tr.Check(); err != nil {
+				t.Fatalf("fanout %d: %+v", fanout, err)
+			}
+
+			// every leaf but the last one is full
+			st := tr.Stats()
+			if want := (5000 + fanout - 2) / (fanout - 1); st.LeafNodes != want {
+				t.Fatalf("fanout %d, B* %t: expect %d leaves but got %d", fanout, bstar, want, st.LeafNodes)
+			}
+
+			if v, err := tr.Find(4321); err != nil || v != int64(4321) {
+				t.Fatalf("expect value 4321 but got %v, %+v", v, err)
+			}
+		}
+	}
+}
+
+func leafKeys(tr *BPlusTree) [][]int64 {
+	var keys [][]int64
+	for tn := tr.firstLeaf(); tn != nil; tn = tn.next() {
+		keys = append(keys, tn.keyList())
+	}
+
+	return keys
+}
+
+func TestAppendStreak(t *testing.T) {
+	tr, _ := NewTree(WithFanout(4), WithAppendFastPath())
+	// a single append to a full leaf splits it evenly
+	for _, k := range []int64{3, 1, 2, 4} {
+		tr.Insert(&Entry{key: k, pointer: k})
+	}
+
+	if want := [][]int64{{1, 2}, {3, 4}}; !reflect.DeepEqual(leafKeys(tr), want) {
+		t.Fatalf("expect leaves %v but got %v", want, leafKeys(tr))
+	}
+
+	// consecutive appends keep the old leaf full
+	for _, k := range []int64{5, 6} {
+		tr.Insert(&Entry{key: k, pointer: k})
+	}
+
+	if want := [][]int64{{1, 2}, {3, 4, 5}, {6}}; !reflect.DeepEqual(leafKeys(tr), want) {
+		t.Fatalf("expect leaves %v but got %v", want, leafKeys(tr))
+	}
+}
+
+func TestAppendMixedOps(t *testing.T) {
+	for _, fanout := range []int{3, 4, 5, 9} {
+		tr, _ := NewTree(WithFanout(fanout), WithAppendFastPath())
+		model := map[int64]bool{}
+		r := rand.New(rand.NewSource(int64(fanout)))
+		next := int64(0)
+		for i := 0; i < 2000; i++ {
+			switch op := r.Intn(10); {
+			case op < 6:
+				// append
+				next += 1 + r.Int63n(3)
+				if err := tr.Insert(&Entry{key: next, pointer: next}); err != nil {
+					t.Fatalf("error appending key %d: %+v", next, err)
+				}
+				model[next] = true
+			case op < 8:
+				// delete one of the greatest keys so that the right
+				// spine shrinks and the cached leaf goes away
+				key := next - r.Int63n(4)
+				err := tr.Delete(key)
+				if (err == nil) != model[key] {
+					t.Fatalf("key %d in model: %t, delete error: %+v", key, model[key], err)
+				}
+				delete(model, key)
+			default:
+				key := r.Int63n(next + 1)
+				if err := tr.Insert(&Entry{key: key, pointer: key}); (err == nil) == model[key] {
+					t.Fatalf("key %d in model: %t, insert error: %+v", key, model[key], err)
+				}
+				model[key] = true
+			}
+
+			if err := tr.Check(); err != nil {
+				t.Fatalf("fanout %d, step %d: %+v\n%s", fanout, i, err, tr.ToString())
+			}
+		}
+
+		if tr.Len() != len(model) {
+			t.Fatalf("expect %d keys but got %d", len(model), tr.Len())
+		}
+	}
+}
+
+func TestAppendObserver(t *testing.T) {
+	l := &eventLog{}
+	tr, _ := NewTree(WithFanout(4), WithAppendFastPath(), WithObserver(l))
+	for k := int64(1); k <= 7; k++ {
+		tr.Insert(&Entry{key: k, pointer: k})
+	}
+
+	events := []string{
+		"leaf split [1 2 3] [4]",
+		"root grow [4]",
+		"leaf split [4 5 6] [7]",
+	}
+	if !reflect.DeepEqual(l.events, events) {
+		t.Fatalf("expect events %q but got %q", events, l.events)
+	}
+}
+
+func TestAppendCheck(t *testing.T) {
+	build := func(opts ...Option) *BPlusTree {
+		tr, _ := NewTree(append(opts, WithFanout(4))...)
+		for k := int64(0); k < 100; k++ {
+			tr.Insert(&Entry{key: k, pointer: k})
+		}
+		return tr
+	}
+
+	tr := build(WithAppendFastPath())
+	last := tr.rightmostLeaf()
+	if len(last.keys) != 2 || !last.appended {
+		t.Fatalf("expect appended leaf of a single key but got %s", last.ChildrenStr())
+	}
+
+	if err := tr.Check(); err != nil {
+		t.Fatalf("error checking tree: %+v", err)
+	}
+
+	// only nodes split by appends may be short of keys
+	last.appended = false
+	if err := tr.Check(); err == nil {
+		t.Fatalf("expect too few entries in %s", last.ChildrenStr())
+	}
+
+	// the rightmost leaf of an evenly split tree is not exempt
+	tr = build()
+	last = tr.rightmostLeaf()
+	tr.size -= len(last.keys) - 2
+	last.resize(2)
+	last.setEntry(1, Entry{})
+	if err := tr.Check(); err == nil {
+		t.Fatalf("expect too few entries in %s", last.ChildrenStr())
+	}
+}
+
+func TestAppendSaveFile(t *testing.T) {
+	tr, _ := NewTree(WithFanout(8), WithAppendFastPath())
+	for k := int64(0); k < 1000; k++ {
+		tr.Insert(&Entry{key: k, pointer: int(k)})
+	}
+
+	ltr, err := OpenFile(saveTree(t, tr, nil), nil)
+	if err != nil {
+		t.Fatalf("error opening tree: %+v", err)
+	}
+
+	if err := ltr.Check(); err != nil {
+		t.Fatalf("error checking loaded tree: %+v", err)
+	}
+
+	if ltr.ToString() != tr.ToString() {
+		t.Fatalf("expect tree:\n%s\nbut got:\n%s", tr.ToString(), ltr.ToString())
+	}
+
+	if err := tr.Record(bytes.NewBuffer(nil)); err == nil {
+		t.Fatalf("expect error recording tree with append fast path")
+	}
+}
@@ -0,0 +1,281 @@
+package v2
+
+import (
+	"fmt"
+	"sort"
+)
+
+// KV is a key value pair of a batch insert
+type KV struct {
+	Key   int64
+	Value interface{}
+}
+
+// BatchOptions controls how a batch is applied
+type BatchOptions struct {
+	// AllOrNothing leaves tree untouched if any key of the batch fails,
+	// otherwise the other keys are applied.
+	AllOrNothing bool
+}
+
+// KeyError is the error of a single key of a batch
+type KeyError struct {
+	Key int64
+	Err error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("key %d: %v", e.Key, e.Err)
+}
+
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
+
+// BatchError lists keys of a batch that failed in key order
+type BatchError struct {
+	Errs []KeyError
+	// Applied tells whether the other keys of the batch were applied
+	Applied bool
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("%d keys of batch failed, first %v", len(e.Errs), &e.Errs[0])
+}
+
+// Is reports whether any key failed with target, e.g.
+// errors.Is(err, ErrDupKey)
+func (e *BatchError) Is(target error) bool {
+	for i := range e.Errs {
+		if e.Errs[i].Err == target {
+			return true
+		}
+	}
+
+	return false
+}
+
+// InsertBatch inserts kvs in key order, keys falling into the same leaf
+// are inserted in one visit of it and the leaf is split at most once
+// per visit. Keys already in tree or repeated in kvs are handled by the
+// duplicate key policy of tree, failed keys are reported by a
+// *BatchError.
+func (tr *BPlusTree) InsertBatch(kvs []KV, opts *BatchOptions) error {
+	if opts == nil {
+		opts = &BatchOptions{}
+	}
+
+	for i := range kvs {
+		tr.dropExpired(kvs[i].Key)
+	}
+
+	sorted := append([]KV(nil), kvs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return tr.cfg.compare(sorted[i].Key, sorted[j].Key) < 0
+	})
+
+	if opts.AllOrNothing && tr.cfg.dup == DupError {
+		keys := make([]int64, len(sorted))
+		for i := range sorted {
+			keys[i] = sorted[i].Key
+		}
+
+		var errs []KeyError
+		tr.lookupBatch(keys, func(i int, found bool) {
+			if found || i > 0 && tr.cfg.compare(keys[i], keys[i-1]) == 0 {
+				errs = append(errs, KeyError{Key: keys[i], Err: ErrDupKey})
+			}
+		})
+
+		if len(errs) > 0 {
+			return &BatchError{Errs: errs}
+		}
+	}
+
+	var errs []KeyError
+	for i := 0; i < len(sorted); {
+		leaf, hi, bounded := tr.leafRun(sorted[i].Key)
+		for ; i < len(sorted) && (!bounded || tr.cfg.compare(sorted[i].Key, hi) < 0); i++ {
+			// the leaf overflowed, split it before going on
+			if leaf.overflowed() {
+				break
+			}
+
+			kv := &sorted[i]
+			if tr.rec != nil {
+				tr.rec.record(OpInsert, kv.Key, kv.Value)
+			}
+
+			inserted, err := tr.insertBatchEntry(leaf, kv)
+			if err != nil {
+				errs = append(errs, KeyError{Key: kv.Key, Err: err})
+			} else if inserted {
+				tr.size++
+			}
+		}
+
+		tr.splitUp(leaf)
+	}
+
+	if len(errs) > 0 {
+		return &BatchError{Errs: errs, Applied: true}
+	}
+
+	return nil
+}
+
+// insertBatchEntry inserts kv into leaf it falls into, it reports
+// whether a new key is inserted
+func (tr *BPlusTree) insertBatchEntry(leaf *tNode, kv *KV) (bool, error) {
+	pos := leaf.findLeafInsertPos(kv.Key)
+	if pos < len(leaf.keys)-1 && tr.cfg.compare(leaf.keys[pos], kv.Key) == 0 {
+		switch tr.cfg.dup {
+		case DupReplace:
+			leaf.ptrs[pos] = kv.Value
+			leaf.unsummarize()
+			tr.forgetTTL(kv.Key)
+		case DupError:
+			return false, ErrDupKey
+		}
+		return false, nil
+	}
+
+	leaf.insertAt(pos, &Entry{key: kv.Key, pointer: kv.Value})
+	return true, nil
+}
+
+// DeleteBatch deletes keys in key order, keys falling into the same
+// leaf are deleted in one visit of it and the leaf is merged or
+// refilled at most once per visit. Keys not in tree are reported by a
+// *BatchError, and so are expired keys like Delete does.
+func (tr *BPlusTree) DeleteBatch(keys []int64, opts *BatchOptions) error {
+	return tr.deleteBatch(keys, opts, true)
+}
+
+// deleteBatch deletes keys as DeleteBatch does, expired keys are only
+// reported missing if hideExpired so that ExpireNow removes them
+// quietly
+func (tr *BPlusTree) deleteBatch(keys []int64, opts *BatchOptions, hideExpired bool) error {
+	if opts == nil {
+		opts = &BatchOptions{}
+	}
+
+	sorted := append([]int64(nil), keys...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return tr.cfg.compare(sorted[i], sorted[j]) < 0
+	})
+
+	var now int64
+	hide := hideExpired && tr.ttl != nil
+	if hide {
+		now = tr.now()
+	}
+
+	if opts.AllOrNothing {
+		var errs []KeyError
+		tr.lookupBatch(sorted, func(i int, found bool) {
+			if !found || hide && tr.ttl.expired(sorted[i], now) || i > 0 && tr.cfg.compare(sorted[i], sorted[i-1]) == 0 {
+				errs = append(errs, KeyError{Key: sorted[i], Err: ErrKeyNotFound})
+			}
+		})
+
+		if len(errs) > 0 {
+			return &BatchError{Errs: errs}
+		}
+	}
+
+	var errs []KeyError
+	for i := 0; i < len(sorted); {
+		leaf, hi, bounded := tr.leafRun(sorted[i])
+		for ; i < len(sorted) && (!bounded || tr.cfg.compare(sorted[i], hi) < 0); i++ {
+			if tr.rec != nil {
+				tr.rec.record(OpDelete, sorted[i], nil)
+			}
+
+			expired := hide && tr.ttl.expired(sorted[i], now)
+			if err := leaf.deleteEntry(sorted[i]); err != nil {
+				errs = append(errs, KeyError{Key: sorted[i], Err: err})
+			} else {
+				tr.size--
+				tr.forgetTTL(sorted[i])
+				// expired key is gone as far as callers can tell
+				if expired {
+					errs = append(errs, KeyError{Key: sorted[i], Err: ErrKeyNotFound})
+				}
+			}
+		}
+
+		tr.mergeUp(leaf)
+	}
+
+	if len(errs) > 0 {
+		return &BatchError{Errs: errs, Applied: true}
+	}
+
+	return nil
+}
+
+// leafRun returns the leaf in which key resides and the least key of
+// the leaves after it, bounded is false for the last leaf
+func (tr *BPlusTree) leafRun(key int64) (leaf *tNode, hi int64, bounded bool) {
+	tn := tr.root
+	for !tn.isLeaf {
+		pos := tn.findChildPos(key)
+		if pos+1 < len(tn.keys) {
+			hi, bounded = tn.keys[pos+1], true
+		}
+		tn = tn.child(pos)
+	}
+
+	return tn, hi, bounded
+}
+
+// lookupBatch calls fn with index of every key of keys in ascending
+// order and whether it is in tree
+func (tr *BPlusTree) lookupBatch(keys []int64, fn func(i int, found bool)) {
+	for i := 0; i < len(keys); {
+		leaf, hi, bounded := tr.leafRun(keys[i])
+		for ; i < len(keys) && (!bounded || tr.cfg.compare(keys[i], hi) < 0); i++ {
+			pos := leaf.findLeafInsertPos(keys[i])
+			fn(i, pos < len(leaf.keys)-1 && tr.cfg.compare(leaf.keys[pos], keys[i]) == 0)
+		}
+	}
+}
+
+// splitUp splits overflowed tn and then its ancestors overflowed in turn
+func (tr *BPlusTree) splitUp(tn *tNode) {
+	for tn.overflowed() {
+		p := tn.parent
+		if p == nil {
+			tr.growRoot(tr.splitNode(tn))
+			return
+		}
+
+		pos := p.childIndex(tn)
+		var ne Entry
+		if tr.cfg.bstar {
+			pos, ne = tr.resolveOverflow(p, pos)
+		} else {
+			ne = tr.splitNode(tn)
+		}
+
+		if ne.pointer != nil {
+			p.insertAt(pos+1, &ne)
+		}
+		tn = p
+	}
+}
+
+// mergeUp rebalances tn with too few pointers and then its ancestors
+// left with too few pointers in turn
+func (tr *BPlusTree) mergeUp(tn *tNode) {
+	for tn.parent != nil && tn.tooFewPointers() {
+		p := tn.parent
+		if !tr.rebalanceChild(p, p.childIndex(tn)) {
+			break
+		}
+		tn = p
+	}
+
+	tr.shrinkRoot()
+}
@@ -0,0 +1,184 @@
+package v2
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestBatchRandom(t *testing.T) {
+	for _, fanout := range []int{3, 4, 7, 32} {
+		for _, bstar := range []bool{false, true} {
+			opts := []Option{WithFanout(fanout)}
+			if bstar {
+				opts = append(opts, WithBStar())
+			}
+
+			tr, _ := NewTree(opts...)
+			model := map[int64]interface{}{}
+			r := rand.New(rand.NewSource(int64(fanout)))
+			for round := 0; round < 100; round++ {
+				n := r.Intn(200)
+				if r.Intn(2) == 0 {
+					kvs := make([]KV, n)
+					var want []int64
+					seen := map[int64]bool{}
+					for i := range kvs {
+						kvs[i] = KV{Key: r.Int63n(1000), Value: round}
+						if _, ok := model[kvs[i].Key]; ok || seen[kvs[i].Key] {
+							want = append(want, kvs[i].Key)
+						} else {
+							model[kvs[i].Key] = round
+						}
+						seen[kvs[i].Key] = true
+					}
+
+					checkBatchErr(t, tr.InsertBatch(kvs, nil), ErrDupKey, want)
+				} else {
+					keys := make([]int64, n)
+					var want []int64
+					for i := range keys {
+						keys[i] = r.Int63n(1000)
+						if _, ok := model[keys[i]]; !ok {
+							want = append(want, keys[i])
+						}
+						delete(model, keys[i])
+					}
+
+					checkBatchErr(t, tr.DeleteBatch(keys, nil), ErrKeyNotFound, want)
+				}
+
+				if err := tr.Check(); err != nil {
+					t.Fatalf("fanout %d, B* %t, round %d: %+v\n%s", fanout, bstar, round, err, tr.ToString())
+				}
+
+				if tr.Len() != len(model) {
+					t.Fatalf("expect %d keys but got %d", len(model), tr.Len())
+				}
+
+				for k, v := range model {
+					if got, err := tr.Find(k); err != nil || got != v {
+						t.Fatalf("expect value %v of key %d but got %v, %+v", v, k, got, err)
+					}
+				}
+			}
+		}
+	}
+}
+
+// checkBatchErr verifies err reports exactly keys as failed with target
+func checkBatchErr(t *testing.T, err error, target error, keys []int64) {
+	t.Helper()
+	if len(keys) == 0 {
+		if err != nil {
+			t.Fatalf("expect no error but got %+v", err)
+		}
+		return
+	}
+
+	berr := &BatchError{}
+	if !errors.As(err, &berr) || !errors.Is(err, target) || !berr.Applied {
+		t.Fatalf("expect batch error of %v but got %+v", target, err)
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	got := make([]int64, len(berr.Errs))
+	for i, e := range berr.Errs {
+		got[i] = e.Key
+	}
+
+	if !reflect.DeepEqual(got, keys) {
+		t.Fatalf("expect failed keys %v but got %v", keys, got)
+	}
+}
+
+func TestBatchAllOrNothing(t *testing.T) {
+	tr := newTree(t, 4, 20, 2)
+	want := entries(tr)
+	opts := &BatchOptions{AllOrNothing: true}
+
+	err := tr.InsertBatch([]KV{{Key: 2, Value: 2}, {Key: 4, Value: 4}, {Key: 2, Value: 2}, {Key: 6, Value: 6}}, opts)
+	berr := &BatchError{}
+	if !errors.As(err, &berr) || berr.Applied || len(berr.Errs) != 1 || berr.Errs[0].Key != 2 {
+		t.Fatalf("expect batch error of key 2 but got %+v", err)
+	}
+
+	err = tr.DeleteBatch([]int64{1, 2, 3, 3}, opts)
+	if !errors.As(err, &berr) || berr.Applied || len(berr.Errs) != 2 || !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expect batch error of keys 2 and 3 but got %+v", err)
+	}
+
+	if got := entries(tr); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expect tree untouched %+v but got %+v", want, got)
+	}
+
+	if err := tr.InsertBatch([]KV{{Key: 4, Value: 4}, {Key: 2, Value: 2}}, opts); err != nil {
+		t.Fatalf("expect no error but got %+v", err)
+	}
+
+	if err := tr.DeleteBatch([]int64{1, 2, 3, 4, 5}, opts); err != nil {
+		t.Fatalf("expect no error but got %+v", err)
+	}
+
+	if err := tr.Check(); err != nil || tr.Len() != 17 {
+		t.Fatalf("expect 17 keys but got %d, %+v", tr.Len(), err)
+	}
+}
+
+func TestBatchDupPolicy(t *testing.T) {
+	cases := []struct {
+		policy DupPolicy
+		want   interface{}
+	}{
+		{DupReplace, "last"},
+		{DupIgnore, "old"},
+	}
+
+	for _, c := range cases {
+		tr, _ := NewTree(WithFanout(3), WithDupPolicy(c.policy))
+		tr.Insert(&Entry{key: 1, pointer: "old"})
+		kvs := []KV{{Key: 1, Value: "first"}, {Key: 2, Value: 2}, {Key: 1, Value: "last"}}
+		if err := tr.InsertBatch(kvs, &BatchOptions{AllOrNothing: true}); err != nil {
+			t.Fatalf("policy %s: expect no error but got %+v", c.policy, err)
+		}
+
+		if v, _ := tr.Find(1); v != c.want || tr.Len() != 2 {
+			t.Fatalf("policy %s: expect value %v and 2 keys but got %v and %d keys", c.policy, c.want, v, tr.Len())
+		}
+	}
+}
+
+func TestBatchTrace(t *testing.T) {
+	tr := newTree(t, 4, 30, 1)
+	buf := bytes.NewBuffer(nil)
+	if err := tr.Record(buf); err != nil {
+		t.Fatalf("error recording tree: %+v", err)
+	}
+
+	tr.InsertBatch([]KV{{Key: 40, Value: 40}, {Key: 31, Value: 31}, {Key: 5, Value: 5}}, nil)
+	tr.DeleteBatch([]int64{1, 2, 3, 100}, nil)
+	if err := tr.StopRecording(); err != nil {
+		t.Fatalf("error recording tree: %+v", err)
+	}
+
+	tc, err := ReadTrace(buf, nil)
+	if err != nil {
+		t.Fatalf("error reading trace: %+v", err)
+	}
+
+	if len(tc.Ops) != 7 {
+		t.Fatalf("expect 7 ops but got %d", len(tc.Ops))
+	}
+
+	rt, err := Replay(tc)
+	if err != nil {
+		t.Fatalf("error replaying trace: %+v", err)
+	}
+
+	if !reflect.DeepEqual(entries(rt), entries(tr)) {
+		t.Fatalf("expect entries %+v but got %+v", entries(tr), entries(rt))
+	}
+}
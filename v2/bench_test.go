@@ -0,0 +1,157 @@
+package v2
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// benchTree returns tree of fanout holding n random keys in [0, 2n) and
+// the keys
+func benchTree(tb testing.TB, fanout, n int) (*BPlusTree, []int64) {
+	tr, err := NewTree(WithFanout(fanout))
+	if err != nil {
+		tb.Fatalf("error creating tree: %+v", err)
+	}
+
+	r := rand.New(rand.NewSource(int64(n)))
+	keys := make([]int64, 0, n)
+	for _, k := range r.Perm(2 * n)[:n] {
+		key := int64(k)
+		tr.Insert(&Entry{key: key, pointer: key})
+		keys = append(keys, key)
+	}
+
+	return tr, keys
+}
+
+func BenchmarkFind(b *testing.B) {
+	tr, keys := benchTree(b, 32, 100000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := tr.Find(keys[i%len(keys)]); err != nil {
+			b.Fatalf("error finding key: %+v", err)
+		}
+	}
+}
+
+// BenchmarkInsertDelete deletes a key and inserts it back, the tree
+// stays the same size so that split and merged nodes are reused
+func BenchmarkInsertDelete(b *testing.B) {
+	tr, keys := benchTree(b, 32, 100000)
+	es := make([]Entry, len(keys))
+	for i, k := range keys {
+		es[i] = Entry{key: k, pointer: k}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e := &es[i%len(es)]
+		if err := tr.Delete(e.key); err != nil {
+			b.Fatalf("error deleting key: %+v", err)
+		}
+
+		if err := tr.Insert(e); err != nil {
+			b.Fatalf("error inserting key: %+v", err)
+		}
+	}
+}
+
+func TestFindAllocs(t *testing.T) {
+	tr, keys := benchTree(t, 8, 1000)
+	allocs := testing.AllocsPerRun(100, func() {
+		for _, k := range keys {
+			tr.Find(k)
+			tr.Find(-k - 1)
+		}
+	})
+
+	if allocs != 0 {
+		t.Fatalf("expect no allocation finding keys but got %v", allocs)
+	}
+}
+
+func TestInsertDeleteAllocs(t *testing.T) {
+	tr, keys := benchTree(t, 4, 1000)
+	es := make([]Entry, len(keys))
+	for i, k := range keys {
+		es[i] = Entry{key: k, pointer: k}
+	}
+
+	// delete half of keys and insert them back, nodes released by
+	// merges are reused by splits
+	churn := func() {
+		for i := range es {
+			if i%2 == 0 {
+				tr.Delete(es[i].key)
+			}
+		}
+		for i := range es {
+			if i%2 == 0 {
+				tr.Insert(&es[i])
+			}
+		}
+	}
+
+	churn()
+	allocs := testing.AllocsPerRun(10, churn)
+	if allocs > 0 {
+		t.Fatalf("expect no allocation in steady state but got %v", allocs)
+	}
+
+	if err := tr.Check(); err != nil || tr.Len() != len(keys) {
+		t.Fatalf("expect %d keys but got %d, %+v", len(keys), tr.Len(), err)
+	}
+}
+
+func TestNodeReuse(t *testing.T) {
+	tr, keys := benchTree(t, 4, 100)
+	for _, k := range keys {
+		tr.Delete(k)
+	}
+
+	free := len(tr.cfg.free[0]) + len(tr.cfg.free[1])
+	if free == 0 {
+		t.Fatalf("expect released nodes but got none")
+	}
+
+	for _, tn := range append(tr.cfg.free[0], tr.cfg.free[1]...) {
+		for _, p := range tn.ptrs[:cap(tn.ptrs)] {
+			if p != nil {
+				t.Fatalf("expect released node cleared but got %+v", tn.ptrs[:cap(tn.ptrs)])
+			}
+		}
+	}
+
+	for _, k := range keys {
+		tr.Insert(&Entry{key: k, pointer: k})
+	}
+
+	if err := tr.Check(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if n := len(tr.cfg.free[0]) + len(tr.cfg.free[1]); n >= free {
+		t.Fatalf("expect released nodes reused but %d of %d left", n, free)
+	}
+}
+
+// BenchmarkFindSearch compares ways of searching nodes across fanouts
+func BenchmarkFindSearch(b *testing.B) {
+	for _, search := range []Search{SearchBinary, SearchLinear, SearchBranchless} {
+		for _, fanout := range []int{8, 16, 64, 128, 256} {
+			b.Run(fmt.Sprintf("%s/fanout=%d", search, fanout), func(b *testing.B) {
+				tr, keys := benchTree(b, fanout, 100000)
+				tr.cfg.search = search
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := tr.Find(keys[i%len(keys)]); err != nil {
+						b.Fatalf("error finding key: %+v", err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -2,128 +2,301 @@ package v2
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/golang/glog"
 )
 
 type BPlusTree struct {
-	maxSize int // max pointer in a node
-	root    *tNode
+	cfg   *config
+	root  *tNode
+	size  int // number of keys in tree
+	codec ValueCodec
+	rec   *recorder // nil unless recording
+	obs   Observer
+	clock func() time.Time // time.Now if nil
+	ttl   *expiry          // nil until an entry is inserted with a TTL
+
+	// last caches the rightmost leaf for appends, it is validated on
+	// use as structural changes do not maintain it
+	last *tNode
+	// appends counts consecutive inserts of keys greater than all keys
+	// in tree
+	appends int
 }
 
-func (tr *BPlusTree) Find(key int64) (interface{}, error) {
-	tn := tr.root
+// Len returns number of keys in tree
+func (tr *BPlusTree) Len() int {
+	return tr.size
+}
 
+func (tr *BPlusTree) findLeaf(key int64) *tNode {
+	tn := tr.root
 	for !tn.isLeaf {
-		pos := tn.findInternalInsertPos(key)
-		if pos >= len(tn.entries) || tn.entries[pos].key > key {
-			pos -= 1
-		}
-
-		tn = tn.entries[pos].pointer.(*tNode)
+		tn = tn.child(tn.findChildPos(key))
 	}
 
+	return tn
+}
+
+func (tr *BPlusTree) Find(key int64) (interface{}, error) {
+	tn := tr.findLeaf(key)
 	pos := tn.findLeafInsertPos(key)
-	if pos >= len(tn.entries) || tn.entries[pos].key != key {
+	// the last entry of leaf points to sibling
+	if pos >= len(tn.keys)-1 || tr.cfg.compare(tn.keys[pos], key) != 0 {
+		return nil, ErrKeyNotFound
+	}
+
+	if tr.ttl != nil && tr.ttl.expired(key, tr.now()) {
 		return nil, ErrKeyNotFound
 	}
 
-	return tn.entries[pos].pointer, nil
+	return tn.ptrs[pos], nil
 }
 
 func (tr *BPlusTree) Insert(e *Entry) error {
+	_, err := tr.insert(e)
+	return err
+}
+
+// insert inserts e like Insert, it also reports whether the key is
+// already in tree, which is only the case without error under DupReplace
+// and DupIgnore
+func (tr *BPlusTree) insert(e *Entry) (bool, error) {
+	tr.dropExpired(e.key)
+
+	// record before insert so that a crashing op is in the trace
+	if tr.rec != nil {
+		tr.rec.record(OpInsert, e.key, e.pointer)
+	}
+
+	if tr.cfg.appends {
+		if leaf := tr.appendLeaf(e.key); leaf != nil {
+			tr.appends++
+			if tr.tryAppend(leaf, e) {
+				tr.size++
+				return false, nil
+			}
+		} else {
+			tr.appends = 0
+		}
+	}
+
+	if tr.cfg.dup != DupError {
+		tn := tr.findLeaf(e.key)
+		pos := tn.findLeafInsertPos(e.key)
+		if pos < len(tn.keys)-1 && tr.cfg.compare(tn.keys[pos], e.key) == 0 {
+			if tr.cfg.dup == DupReplace {
+				tn.ptrs[pos] = e.pointer
+				tn.unsummarize()
+				tr.forgetTTL(e.key)
+			}
+			return true, nil
+		}
+	}
+
 	ne, err := tr.doInsert(tr.root, e)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	if ne == nil {
-		return nil
+	tr.size++
+
+	if ne.pointer == nil {
+		return false, nil
 	}
 
-	newRoot := newTNode(false, tr.maxSize)
-	newRoot.entries = newRoot.entries[:2]
-	newRoot.entries[0] = Entry{pointer: tr.root}
-	newRoot.entries[1] = *ne
+	tr.growRoot(ne)
+	return false, nil
+}
+
+// growRoot makes a new root of the current root and the entry split
+// from it
+func (tr *BPlusTree) growRoot(ne Entry) {
+	newRoot := tr.cfg.newNode(false)
+	newRoot.resize(2)
+	newRoot.setEntry(0, Entry{pointer: tr.root})
+	newRoot.setEntry(1, ne)
 	tr.root.parent = newRoot
 	ne.pointer.(*tNode).parent = newRoot
 	tr.root = newRoot
-
-	return nil
+	if tr.obs != nil {
+		tr.obs.OnRootGrow(newRoot.keyList())
+	}
 }
 
 // doInsert insert Entry e into root, a new entry is returned and
-// insert to parent node if root is splited
-func (tr *BPlusTree) doInsert(root *tNode, e *Entry) (*Entry, error) {
+// insert to parent node if root is splited, the entry has nil pointer
+// otherwise. In B* mode an overflowed node other than the tree root is
+// left to its parent to resolve.
+func (tr *BPlusTree) doInsert(root *tNode, e *Entry) (Entry, error) {
 	// insert leaf node
 	if root.isLeaf {
-		glog.V(2).Infof("entries size: %d, cap: %d, entries: %+v", len(root.entries), cap(root.entries), root.ChildrenStr())
+		if tr.cfg.debug() {
+			tr.cfg.log.Debugf("entries size: %d, cap: %d, entries: %+v", len(root.keys), cap(root.keys), root.ChildrenStr())
+		}
 		if err := root.insertLeaf(e); err != nil {
-			return nil, err
+			return Entry{}, err
 		}
 
-		glog.V(2).Infof("entries size: %d, cap: %d, entries: %+v", len(root.entries), cap(root.entries), root.ChildrenStr())
-		if len(root.entries) < cap(root.entries) {
-			return nil, nil
+		if tr.cfg.debug() {
+			tr.cfg.log.Debugf("entries size: %d, cap: %d, entries: %+v", len(root.keys), cap(root.keys), root.ChildrenStr())
+		}
+		if len(root.keys) < cap(root.keys) || (tr.cfg.bstar && root.parent != nil) {
+			return Entry{}, nil
 		}
 
-		ne := root.splitLeafNode()
-		glog.V(2).Infof("entries size: %d, cap: %d, entries: %+v", len(root.entries), cap(root.entries), root.ChildrenStr())
-		glog.V(2).Infof("ne: %+v", *ne)
+		ne := tr.splitNode(root)
+		if tr.cfg.debug() {
+			tr.cfg.log.Debugf("entries size: %d, cap: %d, entries: %+v", len(root.keys), cap(root.keys), root.ChildrenStr())
+			tr.cfg.log.Debugf("ne: %+v", ne)
+		}
 		return ne, nil
 	}
 
 	// insert internal node
-	pos := root.findInternalInsertPos(e.key)
-	glog.V(2).Infof("internal insert pos: %d", pos)
-	if pos >= len(root.entries) || root.entries[pos].key > e.key {
-		pos -= 1
+	pos := root.findChildPos(e.key)
+	if tr.cfg.debug() {
+		tr.cfg.log.Debugf("internal insert pos: %d", pos)
 	}
 
 	// nce: new child entry
-	nce, err := tr.doInsert(root.entries[pos].pointer.(*tNode), e)
+	child := root.child(pos)
+	nce, err := tr.doInsert(child, e)
 	if err != nil {
-		return nil, err
+		return Entry{}, err
+	}
+
+	if nce.pointer == nil && child.overflowed() {
+		pos, nce = tr.resolveOverflow(root, pos)
 	}
 
-	if nce == nil {
-		return nil, nil
+	if nce.pointer == nil {
+		return Entry{}, nil
 	}
 
 	// invariant check
-	if len(root.entries) >= cap(root.entries) {
-		glog.Errorf("cap entries: %d, size entries: %d, entries: %+v", cap(root.entries), len(root.entries), root.ChildrenStr())
+	if len(root.keys) >= cap(root.keys) {
+		tr.cfg.log.Errorf("cap entries: %d, size entries: %d, entries: %+v", cap(root.keys), len(root.keys), root.ChildrenStr())
 		glog.Fatalf("illegal node entry size:\n %+v", root)
 	}
 
 	// insert newNode after pos
-	root.insertAt(pos+1, nce)
-	if len(root.entries) < cap(root.entries) {
-		return nil, nil
+	root.insertAt(pos+1, &nce)
+	if len(root.keys) < cap(root.keys) || (tr.cfg.bstar && root.parent != nil) {
+		return Entry{}, nil
 	}
 
-	ne := root.splitInternalNode()
-	return ne, nil
+	return tr.splitNode(root), nil
+}
+
+// splitNode splits tn in halves and returns entry of the new node
+func (tr *BPlusTree) splitNode(tn *tNode) Entry {
+	if tn.isLeaf {
+		ne := tn.splitLeafNode()
+		if tr.obs != nil {
+			tr.obs.OnLeafSplit(tn.keyList(), ne.pointer.(*tNode).keyList())
+		}
+		return ne
+	}
+
+	ne := tn.splitInternalNode()
+	if tr.obs != nil {
+		tr.obs.OnInternalSplit(tn.keyList(), ne.key, ne.pointer.(*tNode).keyList())
+	}
+	return ne
+}
+
+// resolveOverflow shifts entries of overflowed child at pos of root into
+// a sibling that is not full, or splits child and a full sibling into
+// three nodes. In the latter case entry of the new node is returned and
+// should be inserted after the returned position, otherwise the entry
+// has nil pointer.
+func (tr *BPlusTree) resolveOverflow(root *tNode, pos int) (int, Entry) {
+	child := root.child(pos)
+	maxEntries := tr.cfg.maxEntries(child.isLeaf)
+	if pos > 0 {
+		left := root.child(pos - 1)
+		if len(left.keys) < maxEntries {
+			redistribute(left, &root.keys[pos], child)
+			if tr.obs != nil {
+				tr.obs.OnBorrow(BorrowFromRight, left.isLeaf, left.keyList(), child.keyList())
+			}
+			return pos, Entry{}
+		}
+	}
+
+	if pos+1 < len(root.keys) {
+		right := root.child(pos + 1)
+		if len(right.keys) < maxEntries {
+			redistribute(child, &root.keys[pos+1], right)
+			if tr.obs != nil {
+				tr.obs.OnBorrow(BorrowFromLeft, child.isLeaf, child.keyList(), right.keyList())
+			}
+			return pos, Entry{}
+		}
+	} else {
+		// split with the left sibling
+		pos--
+	}
+
+	left := root.child(pos)
+	ne := splitThree(left, &root.keys[pos+1], root.child(pos+1))
+	if tr.obs != nil {
+		mid := ne.pointer.(*tNode)
+		if mid.isLeaf {
+			tr.obs.OnLeafSplit(left.keyList(), mid.keyList())
+		} else {
+			tr.obs.OnInternalSplit(left.keyList(), ne.key, mid.keyList())
+		}
+	}
+	return pos, ne
 }
 
 func (t *BPlusTree) Delete(key int64) error {
+	if t.ttl != nil && t.ttl.expired(key, t.now()) {
+		// expired key is gone as far as callers can tell
+		t.ttl.forget(key)
+		t.remove(key)
+		return fmt.Errorf("error deleting key %d: %w", key, ErrKeyNotFound)
+	}
+
+	t.forgetTTL(key)
+	return t.remove(key)
+}
+
+// remove deletes key from tree regardless of its deadline
+func (t *BPlusTree) remove(key int64) error {
+	if t.rec != nil {
+		t.rec.record(OpDelete, key, nil)
+	}
+
 	deleted, err := t.deleteEntry(t.root, key)
 	if err != nil {
-		return fmt.Errorf("error deleting key %d: %+v", key, err)
+		return fmt.Errorf("error deleting key %d: %w", key, err)
 	}
 
+	t.size--
+
 	if !deleted {
 		return nil
 	}
 
-	if len(t.root.entries) == 1 {
-		if !t.root.isLeaf {
-			t.root = t.root.entries[0].pointer.(*tNode)
-			t.root.parent = nil
+	t.shrinkRoot()
+	return nil
+}
+
+// shrinkRoot makes the only child of root the new root
+func (t *BPlusTree) shrinkRoot() {
+	if len(t.root.keys) == 1 && !t.root.isLeaf {
+		old := t.root
+		t.root = t.root.child(0)
+		t.root.parent = nil
+		t.cfg.release(old)
+		if t.obs != nil {
+			t.obs.OnRootShrink(t.root.keyList())
 		}
 	}
-
-	return nil
 }
 
 func (t *BPlusTree) deleteEntry(root *tNode, key int64) (bool, error) {
@@ -131,67 +304,87 @@ func (t *BPlusTree) deleteEntry(root *tNode, key int64) (bool, error) {
 		return true, root.deleteEntry(key)
 	}
 
-	// pos points to index into which key will be inserted
-	pos := root.findInternalInsertPos(key)
-	if pos >= len(root.entries) || root.entries[pos].key > key {
-		pos -= 1
-	}
+	pos := root.findChildPos(key)
 
-	de := root.entries[pos]
-	child := de.pointer.(*tNode)
+	child := root.child(pos)
 	deleted, err := t.deleteEntry(child, key)
 	if err != nil || !deleted {
 		return false, err
 	}
 
-	glog.Infof("children after deletion: %+v", child.ChildrenStr())
+	if t.cfg.debug() {
+		t.cfg.log.Debugf("children after deletion: %+v", child.ChildrenStr())
+	}
 	if !child.tooFewPointers() {
 		return false, nil
 	}
 
+	return t.rebalanceChild(root, pos), nil
+}
+
+// rebalanceChild merges child at pos of root, which has too few
+// pointers, with a sibling, or moves entries from a sibling into it. It
+// reports whether root lost an entry.
+func (t *BPlusTree) rebalanceChild(root *tNode, pos int) bool {
+	child := root.child(pos)
+
 	// too few pointers, try merge entries
 	if pos-1 >= 0 {
-		left := root.entries[pos-1].pointer.(*tNode)
-		if left.mergeNodes(de.key, child) {
-			glog.Infof("deleting entry at %d from %+v", pos, root.ChildrenStr())
+		left := root.child(pos - 1)
+		if left.mergeNodes(root.keys[pos], child) {
+			if t.cfg.debug() {
+				t.cfg.log.Debugf("deleting entry at %d from %+v", pos, root.ChildrenStr())
+			}
 			root.deleteEntryAt(pos)
-			return true, nil
+			t.cfg.release(child)
+			if t.obs != nil {
+				t.obs.OnMerge(left.isLeaf, left.keyList())
+			}
+			return true
 		}
 	}
 
-	if pos+1 < len(root.entries) {
-		right := root.entries[pos+1].pointer.(*tNode)
-		if child.mergeNodes(root.entries[pos+1].key, right) {
-			glog.Infof("deleting entry at %d from %+v", pos+1, root.ChildrenStr())
+	if pos+1 < len(root.keys) {
+		right := root.child(pos + 1)
+		if child.mergeNodes(root.keys[pos+1], right) {
+			if t.cfg.debug() {
+				t.cfg.log.Debugf("deleting entry at %d from %+v", pos+1, root.ChildrenStr())
+			}
 			root.deleteEntryAt(pos + 1)
-			return true, nil
+			t.cfg.release(right)
+			if t.obs != nil {
+				t.obs.OnMerge(child.isLeaf, child.keyList())
+			}
+			return true
 		}
 	}
 
-	// now try redistribute entries
+	// now try redistribute entries, a child short of more than one
+	// entry, e.g. after a batch delete, shares entries evenly instead
 	if pos-1 >= 0 {
-		borrowFromLeft(root.entries[pos-1].pointer.(*tNode), &root.entries[pos].key, child)
-		return false, nil
+		left := root.child(pos - 1)
+		borrowFromLeft(left, &root.keys[pos], child)
+		if child.tooFewPointers() {
+			redistribute(left, &root.keys[pos], child)
+		}
+		if t.obs != nil {
+			t.obs.OnBorrow(BorrowFromLeft, child.isLeaf, left.keyList(), child.keyList())
+		}
+		return false
 	}
 
-	if pos+1 < len(root.entries) {
-		borrowFromRight(child, &root.entries[pos+1].key, root.entries[pos+1].pointer.(*tNode))
-		return false, nil
+	if pos+1 < len(root.keys) {
+		right := root.child(pos + 1)
+		borrowFromRight(child, &root.keys[pos+1], right)
+		if child.tooFewPointers() {
+			redistribute(child, &root.keys[pos+1], right)
+		}
+		if t.obs != nil {
+			t.obs.OnBorrow(BorrowFromRight, child.isLeaf, child.keyList(), right.keyList())
+		}
+		return false
 	}
 
-	glog.Fatalf("unable to delete key %d from %+v", key, root.entries)
+	glog.Fatalf("unable to rebalance child %d of %s", pos, root.ChildrenStr())
 	panic("unreachable")
 }
-
-func NewTree(maxSize int) (*BPlusTree, error) {
-	if maxSize < 3 {
-		return nil, fmt.Errorf("BPlusTree maxSize should be greater than 3: %d", maxSize)
-	}
-
-	tr := &BPlusTree{
-		maxSize: maxSize,
-		root:    newTNode(true, maxSize),
-	}
-
-	return tr, nil
-}
@@ -1,17 +1,13 @@
 package v2
 
 import (
-	"fmt"
-	"math"
 	"reflect"
 	"strings"
 	"testing"
-
-	"github.com/golang/glog"
 )
 
 func newTree(t *testing.T, maxEntrySize int, numKeys int, step int) *BPlusTree {
-	tr, _ := NewTree(maxEntrySize)
+	tr, _ := NewTree(WithFanout(maxEntrySize))
 	for i := 1; i <= numKeys; i++ {
 		key := (i-1)*step + 1
 		if err := tr.Insert(&Entry{key: int64(key), pointer: key}); err != nil {
@@ -25,11 +21,11 @@ func newTree(t *testing.T, maxEntrySize int, numKeys int, step int) *BPlusTree {
 }
 
 func TestBTreeNewTree(t *testing.T) {
-	if _, err := NewTree(2); err == nil {
+	if _, err := NewTree(WithFanout(2)); err == nil {
 		t.Fatalf("expect error but got none")
 	}
 
-	if _, err := NewTree(3); err != nil {
+	if _, err := NewTree(WithFanout(3)); err != nil {
 		t.Fatalf("expect no error but got: %+v", err)
 	}
 }
@@ -40,7 +36,7 @@ func TestBTreeInsertLeaf(t *testing.T) {
 	tr.Insert(&Entry{1, 1})
 	tr.Insert(&Entry{3, 3})
 
-	t.Logf("%+v", tr.root.entries)
+	t.Logf("%+v", nodeEntries(tr.root))
 	wentries := []Entry{
 		{1, 1},
 		{2, 2},
@@ -48,14 +44,14 @@ func TestBTreeInsertLeaf(t *testing.T) {
 		{0, nil},
 	}
 
-	if !reflect.DeepEqual(tr.root.entries, wentries) {
-		t.Fatalf("expect keys: %+v but got: %+v", wentries, tr.root.entries)
+	if !reflect.DeepEqual(nodeEntries(tr.root), wentries) {
+		t.Fatalf("expect keys: %+v but got: %+v", wentries, nodeEntries(tr.root))
 	}
 }
 
 // TODO: SplitInternalRoot
 func TestBTreeSplitLeafRoot(t *testing.T) {
-	tr, _ := NewTree(6)
+	tr, _ := NewTree(WithFanout(6))
 	tr.Insert(&Entry{4, 4})
 	tr.Insert(&Entry{1, 1})
 	tr.Insert(&Entry{2, 2})
@@ -65,24 +61,24 @@ func TestBTreeSplitLeafRoot(t *testing.T) {
 	t.Logf("tree before split: %+v", tr.root.ToString())
 
 	tr.Insert(&Entry{6, 6})
-	if len(tr.root.entries) != 2 {
-		t.Fatalf("expect root with 2 pointer but got %d", len(tr.root.entries))
+	if len(tr.root.keys) != 2 {
+		t.Fatalf("expect root with 2 pointer but got %d", len(tr.root.keys))
 	}
 
-	if tr.root.entries[1].key != 4 {
-		t.Fatalf("expect first key in root node to be 4 but got %d", tr.root.entries[1].key)
+	if tr.root.keys[1] != 4 {
+		t.Fatalf("expect first key in root node to be 4 but got %d", tr.root.keys[1])
 	}
 
-	c1 := tr.root.entries[0].pointer.(*tNode)
-	c2 := tr.root.entries[1].pointer.(*tNode)
+	c1 := tr.root.child(0)
+	c2 := tr.root.child(1)
 	wentries := []Entry{
 		{1, 1},
 		{2, 2},
 		{3, 3},
 		{0, c2},
 	}
-	if !reflect.DeepEqual(wentries, c1.entries) {
-		t.Fatalf("expect keys %+v but got %+v", wentries, c1.entries)
+	if !reflect.DeepEqual(wentries, nodeEntries(c1)) {
+		t.Fatalf("expect keys %+v but got %+v", wentries, nodeEntries(c1))
 	}
 
 	wentries = []Entry{
@@ -91,15 +87,15 @@ func TestBTreeSplitLeafRoot(t *testing.T) {
 		{6, 6},
 		{0, nil},
 	}
-	if !reflect.DeepEqual(wentries, c2.entries) {
-		t.Fatalf("expect keys %+v but got %+v", wentries, c2.entries)
+	if !reflect.DeepEqual(wentries, nodeEntries(c2)) {
+		t.Fatalf("expect keys %+v but got %+v", wentries, nodeEntries(c2))
 	}
 
 	t.Logf("tree after split:\n%s", tr.root.ToString())
 }
 
 func TestBTreeSplitInternalNode(t *testing.T) {
-	tr, _ := NewTree(3)
+	tr, _ := NewTree(WithFanout(3))
 	keys := []int{3, 1, 2, 4, 5, 6, 7}
 
 	for _, key := range keys {
@@ -109,16 +105,16 @@ func TestBTreeSplitInternalNode(t *testing.T) {
 		t.Logf("tree after insert key: %d\n%s\n", key, tr.ToString())
 	}
 
-	c2 := tr.root.entries[1].pointer.(*tNode)
+	c2 := tr.root.child(1)
 	if c2.isLeaf {
 		t.Fatalf("expect internal node but got leaf: %+v", c2)
 	}
 
-	if len(c2.entries) != 2 {
-		t.Fatalf("expect 2 children but got %d", len(c2.entries))
+	if len(c2.keys) != 2 {
+		t.Fatalf("expect 2 children but got %d", len(c2.keys))
 	}
 
-	for _, e := range c2.entries {
+	for _, e := range nodeEntries(c2) {
 		c := e.pointer.(*tNode)
 		if c.parent != c2 {
 			t.Fatalf("expect parent %+v but got %+v", c2, c.parent)
@@ -127,7 +123,7 @@ func TestBTreeSplitInternalNode(t *testing.T) {
 }
 
 func TestBTreeInsertNode(t *testing.T) {
-	tr, _ := NewTree(3)
+	tr, _ := NewTree(WithFanout(3))
 	keys := []int{3, 1, 2, 4, 5, 6, 7, 20, 18, 19, 13, 10, 12, 11, 17, 16, 14, 15, 9, 8}
 
 	for _, key := range keys {
@@ -263,8 +259,8 @@ func TestBTreeDeleteBorrowRightInternal(t *testing.T) {
 		t.Logf("b tree after deleting %d:\n%s\n", i, tr.ToString())
 	}
 
-	if tr.root.entries[1].key != 9 {
-		t.Fatalf("exptect root first key 9 but got %d", tr.root.entries[1].key)
+	if tr.root.keys[1] != 9 {
+		t.Fatalf("exptect root first key 9 but got %d", tr.root.keys[1])
 	}
 }
 
@@ -279,82 +275,14 @@ func TestBTreeDeleteBorrowLeftInternal(t *testing.T) {
 		t.Fatalf("error deleting key %d: %+v", 19, err)
 	}
 	t.Logf("b tree after deleting key 19:\n%s\n", tr.ToString())
-	c2 := tr.root.entries[1].pointer.(*tNode)
-	if c2.entries[1].key != 19 {
-		t.Fatalf("expect first key 19 after borrowing from left but got %d", c2.entries[1].key)
-	}
-}
-
-func doCheckBPlusTreeInvariant(parent *tNode, tn *tNode, min int64, max int64) error {
-	glog.Infof("checking range [%d, %d]", min, max)
-
-	// 1. check parent pointer
-	if parent != tn.parent {
-		return fmt.Errorf("expect parent of %v to be %+v but got: %+v", tn.ChildrenStr(), parent.ChildrenStr(), tn.parent.ChildrenStr())
-	}
-
-	// 2. check entry size
-	if len(tn.entries) >= cap(tn.entries) {
-		return fmt.Errorf("max entry size %d but got %d entires: %+v", cap(tn.entries)-1, len(tn.entries), tn.entries)
-	}
-
-	if tn.tooFewPointers() {
-		return fmt.Errorf("max entry size %d, too few entrys: %d", cap(tn.entries)-1, tn.entries)
-	}
-
-	if !tn.isLeaf {
-		for i, e := range tn.entries {
-			var cmin int64 = e.key
-			var cmax int64 = max
-
-			if i == 0 {
-				cmin = min
-			}
-
-			if i < len(tn.entries)-1 {
-				cmax = tn.entries[i+1].key
-			}
-
-			child := e.pointer.(*tNode)
-			if err := doCheckBPlusTreeInvariant(tn, child, cmin, cmax); err != nil {
-				return err
-			}
-		}
-	}
-
-	// check value range
-	values := []int64{}
-	if !tn.isLeaf {
-		for _, e := range tn.entries[1:] {
-			values = append(values, e.key)
-		}
-	} else {
-		for _, e := range tn.entries[:len(tn.entries)-1] {
-			values = append(values, e.key)
-		}
-	}
-
-	for i := 1; i < len(values); i++ {
-		if values[i] <= values[i-1] {
-			return fmt.Errorf("illegal value sequence %+v: (value[%d] = %d) <= (value[%d] = %d)",
-				values, i, values[i], i-1, values[i-1])
-		}
-	}
-
-	// check value range
-	if min > values[0] {
-		return fmt.Errorf("expect keys %+v in range [%d, %d] but found key %d", values, min, max, values[0])
+	c2 := tr.root.child(1)
+	if c2.keys[1] != 19 {
+		t.Fatalf("expect first key 19 after borrowing from left but got %d", c2.keys[1])
 	}
-
-	if values[len(values)-1] > max {
-		return fmt.Errorf("expect keys %+v in range [%d, %d] but found key %d", values, min, max, values[len(values)-1])
-	}
-
-	return nil
 }
 
 func checkBPlusTreeInvariant(tr *BPlusTree) error {
-	return doCheckBPlusTreeInvariant(nil, tr.root, math.MinInt64, math.MaxInt64)
+	return tr.Check()
 }
 
 func TestBTreeUpdateParent(t *testing.T) {
@@ -451,3 +379,57 @@ func TestBTreeFind(t *testing.T) {
 		}
 	}
 }
+
+func TestBTreeRange(t *testing.T) {
+	tr := newTree(t, 4, 20, 2)
+	keys := []int64{}
+	tr.Range(4, 12, func(key int64, value interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+
+	wkeys := []int64{5, 7, 9, 11}
+	if !reflect.DeepEqual(wkeys, keys) {
+		t.Fatalf("expect keys %+v but got %+v", wkeys, keys)
+	}
+
+	keys = keys[:0]
+	tr.Range(0, 100, func(key int64, value interface{}) bool {
+		keys = append(keys, key)
+		return len(keys) < 3
+	})
+
+	wkeys = []int64{1, 3, 5}
+	if !reflect.DeepEqual(wkeys, keys) {
+		t.Fatalf("expect keys %+v but got %+v", wkeys, keys)
+	}
+}
+
+func TestBTreeFloorCeiling(t *testing.T) {
+	tr := newTree(t, 4, 20, 2)
+	cases := []struct {
+		key      int64
+		floor    int64
+		floorErr error
+		ceil     int64
+		ceilErr  error
+	}{
+		{key: 0, floorErr: ErrKeyNotFound, ceil: 1},
+		{key: 1, floor: 1, ceil: 1},
+		{key: 8, floor: 7, ceil: 9},
+		{key: 39, floor: 39, ceil: 39},
+		{key: 40, floor: 39, ceilErr: ErrKeyNotFound},
+	}
+
+	for i, tc := range cases {
+		k, _, err := tr.Floor(tc.key)
+		if err != tc.floorErr || (err == nil && k != tc.floor) {
+			t.Fatalf("case %d: expect floor (%d, %+v) but got (%d, %+v)", i, tc.floor, tc.floorErr, k, err)
+		}
+
+		k, _, err = tr.Ceiling(tc.key)
+		if err != tc.ceilErr || (err == nil && k != tc.ceil) {
+			t.Fatalf("case %d: expect ceiling (%d, %+v) but got (%d, %+v)", i, tc.ceil, tc.ceilErr, k, err)
+		}
+	}
+}
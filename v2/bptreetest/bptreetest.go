@@ -0,0 +1,59 @@
+// Package bptreetest provides helpers to reproduce tree bugs from
+// traces recorded by BPlusTree.Record in tests.
+package bptreetest
+
+import (
+	"os"
+	"testing"
+
+	bptree "github.com/kikimo/BPlusTree/v2"
+)
+
+// Replay replays tc and fails tb if any step fails. A failing trace is
+// shrunk to a minimal one, which is saved to a temporary file and
+// logged along with the error.
+func Replay(tb testing.TB, tc *bptree.Trace) *bptree.BPlusTree {
+	tb.Helper()
+	tr, err := bptree.Replay(tc)
+	if err == nil {
+		return tr
+	}
+
+	st := tc.Shrink()
+	_, serr := bptree.Replay(st)
+	if serr == nil {
+		// should not happen unless replay is nondeterministic
+		serr = err
+	}
+
+	f, ferr := os.CreateTemp("", "bptree-*.trace")
+	if ferr != nil {
+		tb.Fatalf("replay failed: %v\nshrunk trace:\n%s", serr, st)
+	}
+	defer f.Close()
+
+	if _, ferr := st.WriteTo(f); ferr != nil {
+		tb.Fatalf("replay failed: %v\nshrunk trace:\n%s", serr, st)
+	}
+
+	tb.Fatalf("replay failed: %v\nshrunk trace saved to %s:\n%s", serr, f.Name(), st)
+	return nil
+}
+
+// ReplayFile replays trace file at path, values are decoded by codec,
+// GobCodec if nil.
+func ReplayFile(tb testing.TB, path string, codec bptree.ValueCodec) *bptree.BPlusTree {
+	tb.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		tb.Fatalf("error opening trace: %v", err)
+	}
+	defer f.Close()
+
+	tc, err := bptree.ReadTrace(f, codec)
+	if err != nil {
+		tb.Fatalf("error reading trace %s: %v", path, err)
+	}
+
+	return Replay(tb, tc)
+}
@@ -0,0 +1,39 @@
+package bptreetest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	bptree "github.com/kikimo/BPlusTree/v2"
+)
+
+func TestReplayFile(t *testing.T) {
+	tr, _ := bptree.NewTree(bptree.WithFanout(3))
+	path := filepath.Join(t.TempDir(), "ops.trace")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("error creating trace: %+v", err)
+	}
+
+	if err := tr.Record(f); err != nil {
+		t.Fatalf("error recording tree: %+v", err)
+	}
+
+	for i := int64(0); i < 100; i++ {
+		tr.Insert(bptree.NewEntry(i*7%100, i))
+		if i%3 == 0 {
+			tr.Delete(i)
+		}
+	}
+
+	if err := tr.StopRecording(); err != nil {
+		t.Fatalf("error recording tree: %+v", err)
+	}
+	f.Close()
+
+	rt := ReplayFile(t, path, nil)
+	if rt.Len() != tr.Len() || rt.ToString() != tr.ToString() {
+		t.Fatalf("expect tree:\n%s\nbut got:\n%s", tr.ToString(), rt.ToString())
+	}
+}
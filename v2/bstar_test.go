@@ -0,0 +1,96 @@
+package v2
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestBStarRandomOps(t *testing.T) {
+	cases := []struct {
+		leafCap, innerCap int
+	}{
+		{2, 3},
+		{3, 4},
+		{4, 3},
+		{6, 5},
+		{16, 16},
+	}
+
+	for _, c := range cases {
+		tr, _ := NewTree(WithLeafCapacity(c.leafCap), WithInternalCapacity(c.innerCap), WithBStar())
+		model := map[int64]bool{}
+		r := rand.New(rand.NewSource(int64(c.leafCap*100 + c.innerCap)))
+		for i := 0; i < 3000; i++ {
+			key := r.Int63n(500)
+			if r.Intn(4) == 0 {
+				err := tr.Delete(key)
+				if (err == nil) != model[key] {
+					t.Fatalf("key %d in model: %t, delete error: %+v", key, model[key], err)
+				}
+				delete(model, key)
+			} else {
+				err := tr.Insert(&Entry{key: key, pointer: key})
+				if (err == nil) == model[key] {
+					t.Fatalf("key %d in model: %t, insert error: %+v", key, model[key], err)
+				}
+				model[key] = true
+			}
+
+			if err := tr.Check(); err != nil {
+				t.Fatalf("leaf capacity %d, internal capacity %d, step %d: %+v\n%s", c.leafCap, c.innerCap, i, err, tr.ToString())
+			}
+		}
+
+		if tr.Len() != len(model) {
+			t.Fatalf("expect %d keys but got %d", len(model), tr.Len())
+		}
+	}
+}
+
+func TestBStarFill(t *testing.T) {
+	for _, fanout := range []int{4, 8, 16, 64} {
+		plain, _ := NewTree(WithFanout(fanout))
+		bstar, _ := NewTree(WithFanout(fanout), WithBStar())
+		for i := int64(0); i < 5000; i++ {
+			plain.Insert(&Entry{key: i, pointer: i})
+			bstar.Insert(&Entry{key: i, pointer: i})
+		}
+
+		if err := bstar.Check(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+
+		ps, bs := plain.Stats(), bstar.Stats()
+		if bs.FillFactor < 0.66 || bs.LeafNodes >= ps.LeafNodes {
+			t.Fatalf("fanout %d: expect fill factor of at least 0.66 and fewer than %d leaves but got %.2f and %d leaves",
+				fanout, ps.LeafNodes, bs.FillFactor, bs.LeafNodes)
+		}
+	}
+}
+
+func TestBStarObserver(t *testing.T) {
+	l := &eventLog{}
+	tr, _ := NewTree(WithFanout(3), WithBStar(), WithObserver(l))
+	// [1 2] shifts 2 into [3] on inserting 0, then both are full when
+	// inserting 4
+	for _, k := range []int64{1, 2, 3, 0, 4} {
+		tr.Insert(&Entry{key: k, pointer: k})
+	}
+
+	want := []string{
+		"leaf split [1 2] [3]",
+		"root grow [3]",
+		"borrow from left leaf=true [0 1] [2 3]",
+		"leaf split [0] [1 2]",
+	}
+
+	if len(l.events) != len(want) {
+		t.Fatalf("expect events %q but got %q", want, l.events)
+	}
+
+	for i := range want {
+		if l.events[i] != want[i] {
+			t.Fatalf("expect events %q but got %q", want, l.events)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package v2
+
+import (
+	"fmt"
+)
+
+// bulkLoader builds a tree bottom up from entries in strictly ascending
+// key order. Leaves and internal nodes are filled up, except for the
+// last two nodes of every level which share their entries evenly when
+// the last one would have too few pointers.
+type bulkLoader struct {
+	cfg *config
+	// leaves holds first key and pointer of every leaf built so far
+	leaves []Entry
+	leaf   *tNode
+	size   int
+}
+
+func newBulkLoader(cfg *config) *bulkLoader {
+	return &bulkLoader{cfg: cfg}
+}
+
+func (bl *bulkLoader) add(key int64, value interface{}) error {
+	if bl.leaf != nil {
+		last := len(bl.leaf.keys) - 2
+		if last >= 0 && bl.cfg.compare(bl.leaf.keys[last], key) >= 0 {
+			return fmt.Errorf("keys not in ascending order: %d after %d", key, bl.leaf.keys[last])
+		}
+	}
+
+	// a leaf holds at most leafCap keys plus the sibling pointer
+	if bl.leaf == nil || len(bl.leaf.keys) == bl.cfg.maxEntries(true) {
+		leaf := bl.cfg.newNode(true)
+		if bl.leaf != nil {
+			bl.leaf.ptrs[len(bl.leaf.keys)-1] = leaf
+		}
+		bl.leaf = leaf
+		bl.leaves = append(bl.leaves, Entry{key: key, pointer: leaf})
+	}
+
+	sz := len(bl.leaf.keys)
+	bl.leaf.resize(sz + 1)
+	bl.leaf.setEntry(sz, bl.leaf.entry(sz-1))
+	bl.leaf.setEntry(sz-1, Entry{key: key, pointer: value})
+	bl.size++
+
+	return nil
+}
+
+// build returns tree made up of all entries added
+func (bl *bulkLoader) build() *BPlusTree {
+	tr := &BPlusTree{
+		cfg:  bl.cfg,
+		size: bl.size,
+	}
+
+	if len(bl.leaves) == 0 {
+		tr.root = bl.cfg.newNode(true)
+		return tr
+	}
+
+	level := bl.leaves
+	balanceLast(level)
+	for len(level) > 1 {
+		var parents []Entry
+		var parent *tNode
+		for _, e := range level {
+			if parent == nil || len(parent.keys) == bl.cfg.maxEntries(false) {
+				parent = bl.cfg.newNode(false)
+				parents = append(parents, Entry{key: e.key, pointer: parent})
+				e.key = 0
+			}
+
+			e.pointer.(*tNode).parent = parent
+			parent.resize(parent.size() + 1)
+			parent.setEntry(parent.size()-1, e)
+		}
+
+		balanceLast(parents)
+		level = parents
+	}
+
+	tr.root = level[0].pointer.(*tNode)
+	return tr
+}
+
+// balanceLast shares entries of the last two nodes of level if the last
+// one has too few pointers
+func balanceLast(level []Entry) {
+	n := len(level)
+	if n < 2 {
+		return
+	}
+
+	last := level[n-1].pointer.(*tNode)
+	if last.tooFewPointers() {
+		redistribute(level[n-2].pointer.(*tNode), &level[n-1].key, last)
+	}
+}
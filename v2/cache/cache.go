@@ -0,0 +1,207 @@
+// Package cache provides a bounded map of int64 keys iterated in key
+// order. Entries are kept in a v2 tree and the least recently used ones
+// are deleted from it once the cache holds too many entries or bytes:
+//
+//	c, err := cache.New(&cache.Options{
+//		MaxBytes: 64 << 20,
+//		Size:     func(key int64, value interface{}) int64 { return int64(len(value.([]byte))) },
+//		OnEvict:  func(key int64, value interface{}) { log.Printf("evicted %d", key) },
+//	})
+package cache
+
+import (
+	"container/list"
+	"fmt"
+
+	bptree "github.com/kikimo/BPlusTree/v2"
+)
+
+// Options configures a cache, at least one of MaxEntries and MaxBytes
+// should be set
+type Options struct {
+	// MaxEntries bounds number of entries, no bound if 0
+	MaxEntries int
+	// MaxBytes bounds total size of entries as told by Size, no bound
+	// if 0
+	MaxBytes int64
+	// Size returns size of an entry, it is required by MaxBytes
+	Size func(key int64, value interface{}) int64
+	// OnEvict is called with every entry evicted, but not with entries
+	// removed by Remove
+	OnEvict func(key int64, value interface{})
+	// TreeOptions configure the tree holding entries, its duplicate key
+	// policy is ignored
+	TreeOptions []bptree.Option
+}
+
+// Stats counts lookups and evictions of a cache
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Len       int
+	Bytes     int64
+}
+
+type entry struct {
+	key   int64
+	value interface{}
+	size  int64
+}
+
+// Cache is a bounded map evicting least recently used entries. Just
+// like the tree it is not safe for concurrent use.
+type Cache struct {
+	opts Options
+	// tr maps keys to elements of lru
+	tr *bptree.BPlusTree
+	// lru holds entries from the most recently used to the least
+	lru   *list.List
+	bytes int64
+	stats Stats
+}
+
+// New returns an empty cache configured by opts
+func New(opts *Options) (*Cache, error) {
+	if opts.MaxEntries < 0 || opts.MaxBytes < 0 {
+		return nil, fmt.Errorf("max entries and max bytes should not be negative: %d, %d", opts.MaxEntries, opts.MaxBytes)
+	}
+
+	if opts.MaxEntries == 0 && opts.MaxBytes == 0 {
+		return nil, fmt.Errorf("either max entries or max bytes should be set")
+	}
+
+	if opts.MaxBytes > 0 && opts.Size == nil {
+		return nil, fmt.Errorf("size function should be set along with max bytes")
+	}
+
+	tr, err := bptree.NewTree(opts.TreeOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cache{opts: *opts, tr: tr, lru: list.New()}, nil
+}
+
+func (c *Cache) lookup(key int64) (*list.Element, bool) {
+	v, err := c.tr.Find(key)
+	if err != nil {
+		return nil, false
+	}
+
+	return v.(*list.Element), true
+}
+
+// Get returns value of key and marks it most recently used
+func (c *Cache) Get(key int64) (interface{}, bool) {
+	el, ok := c.lookup(key)
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+
+	c.stats.Hits++
+	c.lru.MoveToFront(el)
+	return el.Value.(*entry).value, true
+}
+
+// Peek returns value of key without marking it used or counting the
+// lookup in stats
+func (c *Cache) Peek(key int64) (interface{}, bool) {
+	el, ok := c.lookup(key)
+	if !ok {
+		return nil, false
+	}
+
+	return el.Value.(*entry).value, true
+}
+
+// Put sets value of key, marks it most recently used and evicts least
+// recently used entries while the cache is over its bounds. An entry
+// larger than MaxBytes is refused.
+func (c *Cache) Put(key int64, value interface{}) error {
+	var size int64
+	if c.opts.Size != nil {
+		size = c.opts.Size(key, value)
+	}
+
+	if c.opts.MaxBytes > 0 && size > c.opts.MaxBytes {
+		return fmt.Errorf("size of key %d exceeds max bytes %d: %d", key, c.opts.MaxBytes, size)
+	}
+
+	if el, ok := c.lookup(key); ok {
+		e := el.Value.(*entry)
+		c.bytes += size - e.size
+		e.value, e.size = value, size
+		c.lru.MoveToFront(el)
+	} else {
+		el := c.lru.PushFront(&entry{key: key, value: value, size: size})
+		if err := c.tr.Insert(bptree.NewEntry(key, el)); err != nil {
+			c.lru.Remove(el)
+			return err
+		}
+		c.bytes += size
+	}
+
+	for c.over() {
+		c.evict()
+	}
+
+	return nil
+}
+
+func (c *Cache) over() bool {
+	return c.opts.MaxEntries > 0 && c.lru.Len() > c.opts.MaxEntries ||
+		c.opts.MaxBytes > 0 && c.bytes > c.opts.MaxBytes
+}
+
+// evict deletes the least recently used entry
+func (c *Cache) evict() {
+	e := c.remove(c.lru.Back())
+	c.stats.Evictions++
+	if c.opts.OnEvict != nil {
+		c.opts.OnEvict(e.key, e.value)
+	}
+}
+
+func (c *Cache) remove(el *list.Element) *entry {
+	e := c.lru.Remove(el).(*entry)
+	if err := c.tr.Delete(e.key); err != nil {
+		panic(fmt.Sprintf("cache entry of key %d missing from tree: %v", e.key, err))
+	}
+	c.bytes -= e.size
+	return e
+}
+
+// Remove deletes key and tells whether it was cached
+func (c *Cache) Remove(key int64) bool {
+	el, ok := c.lookup(key)
+	if !ok {
+		return false
+	}
+
+	c.remove(el)
+	return true
+}
+
+// Range calls fn for every key in [lo, hi] in ascending order without
+// marking them used, it stops as soon as fn returns false. The cache
+// should not be modified by fn.
+func (c *Cache) Range(lo, hi int64, fn func(key int64, value interface{}) bool) {
+	c.tr.Range(lo, hi, func(key int64, v interface{}) bool {
+		return fn(key, v.(*list.Element).Value.(*entry).value)
+	})
+}
+
+// Len returns number of entries cached
+func (c *Cache) Len() int {
+	return c.lru.Len()
+}
+
+// Stats returns counters of lookups and evictions along with current
+// size of the cache
+func (c *Cache) Stats() Stats {
+	st := c.stats
+	st.Len, st.Bytes = c.lru.Len(), c.bytes
+	return st
+}
@@ -0,0 +1,165 @@
+package cache
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	bptree "github.com/kikimo/BPlusTree/v2"
+)
+
+func keys(c *Cache) []int64 {
+	ks := []int64{}
+	c.Range(-1<<63, 1<<63-1, func(key int64, value interface{}) bool {
+		ks = append(ks, key)
+		return true
+	})
+
+	return ks
+}
+
+func TestCacheOptions(t *testing.T) {
+	cases := []struct {
+		opts Options
+		want string
+	}{
+		{Options{}, "either max entries or max bytes should be set"},
+		{Options{MaxEntries: -1}, "should not be negative"},
+		{Options{MaxBytes: 10}, "size function should be set"},
+		{Options{MaxEntries: 1, TreeOptions: []bptree.Option{bptree.WithFanout(2)}}, "fanout should be at least 3"},
+	}
+
+	for _, c := range cases {
+		_, err := New(&c.opts)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Fatalf("expect error %q but got %+v", c.want, err)
+		}
+	}
+}
+
+func TestCacheMaxEntries(t *testing.T) {
+	var evicted []int64
+	c, err := New(&Options{
+		MaxEntries:  3,
+		OnEvict:     func(key int64, value interface{}) { evicted = append(evicted, key) },
+		TreeOptions: []bptree.Option{bptree.WithFanout(3)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []int64{5, 1, 3} {
+		c.Put(k, int(k))
+	}
+
+	// 5 becomes the most recently used, 1 the least
+	if v, ok := c.Get(5); !ok || v != 5 {
+		t.Fatalf("expect value 5 but got %v, %t", v, ok)
+	}
+
+	// peek does not mark 1 used
+	c.Peek(1)
+	c.Put(4, 4)
+	c.Put(2, 2)
+	if want := []int64{1, 3}; !reflect.DeepEqual(evicted, want) {
+		t.Fatalf("expect evicted %v but got %v", want, evicted)
+	}
+
+	if want := []int64{2, 4, 5}; !reflect.DeepEqual(keys(c), want) {
+		t.Fatalf("expect keys %v but got %v", want, keys(c))
+	}
+
+	c.Get(1)
+	if !c.Remove(4) || c.Remove(4) {
+		t.Fatalf("expect key 4 removed once")
+	}
+
+	want := Stats{Hits: 1, Misses: 1, Evictions: 2, Len: 2}
+	if st := c.Stats(); st != want {
+		t.Fatalf("expect stats %+v but got %+v", want, st)
+	}
+}
+
+func TestCacheMaxBytes(t *testing.T) {
+	var evicted []string
+	c, _ := New(&Options{
+		MaxBytes: 10,
+		Size:     func(key int64, value interface{}) int64 { return int64(len(value.(string))) },
+		OnEvict:  func(key int64, value interface{}) { evicted = append(evicted, value.(string)) },
+	})
+
+	c.Put(1, "aaaa")
+	c.Put(2, "bbbb")
+	if err := c.Put(3, "ccccccccccc"); err == nil {
+		t.Fatalf("expect error putting entry larger than max bytes but got none")
+	}
+
+	// growing key 1 evicts key 2, the least recently used
+	c.Put(1, "aaaaaaa")
+	if want := []string{"bbbb"}; !reflect.DeepEqual(evicted, want) {
+		t.Fatalf("expect evicted %v but got %v", want, evicted)
+	}
+
+	c.Put(3, "ccc")
+	if st := c.Stats(); st.Len != 2 || st.Bytes != 10 {
+		t.Fatalf("expect 2 entries of 10 bytes but got %+v", st)
+	}
+
+	c.Put(4, "d")
+	if want := []string{"bbbb", "aaaaaaa"}; !reflect.DeepEqual(evicted, want) {
+		t.Fatalf("expect evicted %v but got %v", want, evicted)
+	}
+}
+
+func TestCacheRandom(t *testing.T) {
+	const maxEntries = 50
+	c, _ := New(&Options{MaxEntries: maxEntries, TreeOptions: []bptree.Option{bptree.WithFanout(4)}})
+	// model holds keys from the least recently used to the most
+	var model []int64
+	touch := func(k int64) bool {
+		for i, mk := range model {
+			if mk == k {
+				model = append(append(model[:i:i], model[i+1:]...), k)
+				return true
+			}
+		}
+		return false
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		k := r.Int63n(100)
+		switch r.Intn(3) {
+		case 0:
+			_, ok := c.Get(k)
+			if ok != touch(k) {
+				t.Fatalf("step %d: expect key %d cached %t", i, k, !ok)
+			}
+		case 1:
+			c.Put(k, k)
+			if !touch(k) {
+				model = append(model, k)
+				if len(model) > maxEntries {
+					model = model[1:]
+				}
+			}
+		default:
+			c.Remove(k)
+			for j, mk := range model {
+				if mk == k {
+					model = append(model[:j], model[j+1:]...)
+					break
+				}
+			}
+		}
+
+		if c.Len() != len(model) || len(keys(c)) != len(model) {
+			t.Fatalf("step %d: expect %d keys but got %d", i, len(model), c.Len())
+		}
+	}
+
+	if err := c.tr.Check(); err != nil {
+		t.Fatal(err)
+	}
+}
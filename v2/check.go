@@ -0,0 +1,141 @@
+package v2
+
+import (
+	"fmt"
+)
+
+// Check verifies structural invariants of tr: parent pointers, node
+// sizes, key order and ranges, leaf depth and sibling links. It returns
+// the first violation found.
+func (tr *BPlusTree) Check() error {
+	if tr.root == nil {
+		return fmt.Errorf("tree has no root")
+	}
+
+	c := &checker{tr: tr, leafDepth: -1}
+	if err := c.check(nil, tr.root, nil, nil, 0, true); err != nil {
+		return err
+	}
+
+	if c.last != nil {
+		if sibling := c.last.ptrs[len(c.last.keys)-1]; sibling != nil {
+			return fmt.Errorf("last leaf %s points to sibling %+v", c.last.ChildrenStr(), sibling)
+		}
+	}
+
+	if c.count != tr.size {
+		return fmt.Errorf("expect %d keys in tree but found %d", tr.size, c.count)
+	}
+
+	return nil
+}
+
+type checker struct {
+	tr        *BPlusTree
+	leafDepth int
+	count     int
+	last      *tNode
+}
+
+// check verifies subtree rooted at tn, keys of which should reside in
+// [min, max), nil bound is unlimited. Nodes started almost empty by an
+// append split may have too few pointers while on the right spine.
+func (c *checker) check(parent *tNode, tn *tNode, min *int64, max *int64, depth int, rightmost bool) error {
+	if parent != tn.parent {
+		return fmt.Errorf("expect parent of %s to be %s but got: %s", tn.ChildrenStr(), parent.ChildrenStr(), tn.parent.ChildrenStr())
+	}
+
+	if tn.cfg != c.tr.cfg {
+		return fmt.Errorf("node %s does not share config of tree", tn.ChildrenStr())
+	}
+
+	if len(tn.keys) != len(tn.ptrs) {
+		return fmt.Errorf("node %s has %d keys but %d pointers", tn.ChildrenStr(), len(tn.keys), len(tn.ptrs))
+	}
+
+	maxEntries := c.tr.cfg.maxEntries(tn.isLeaf)
+	if len(tn.keys) > maxEntries {
+		return fmt.Errorf("max entry size %d but got %d entries: %s", maxEntries, len(tn.keys), tn.ChildrenStr())
+	}
+
+	// root is allowed to have too few pointers
+	if parent != nil && !(rightmost && tn.appended) && tn.tooFewPointers() {
+		return fmt.Errorf("max entry size %d, too few entries: %s", maxEntries, tn.ChildrenStr())
+	}
+
+	keys := []int64{}
+	if tn.isLeaf {
+		if len(tn.keys) < 1 {
+			return fmt.Errorf("leaf without sibling entry")
+		}
+
+		if parent != nil && len(tn.keys) < 2 {
+			return fmt.Errorf("leaf without keys")
+		}
+
+		keys = append(keys, tn.keys[:len(tn.keys)-1]...)
+	} else {
+		if len(tn.keys) < 2 && parent != nil {
+			return fmt.Errorf("internal node with %d children", len(tn.keys))
+		}
+
+		keys = append(keys, tn.keys[1:]...)
+	}
+
+	for i, k := range keys {
+		if i > 0 && c.tr.cfg.compare(k, keys[i-1]) <= 0 {
+			return fmt.Errorf("illegal key sequence %+v: (keys[%d] = %d) <= (keys[%d] = %d)", keys, i, k, i-1, keys[i-1])
+		}
+
+		if (min != nil && c.tr.cfg.compare(k, *min) < 0) || (max != nil && c.tr.cfg.compare(k, *max) >= 0) {
+			return fmt.Errorf("expect keys %+v in range [%s, %s) but found key %d", keys, bound(min), bound(max), k)
+		}
+	}
+
+	if tn.isLeaf {
+		if c.leafDepth >= 0 && c.leafDepth != depth {
+			return fmt.Errorf("leaf %s at depth %d but expect %d", tn.ChildrenStr(), depth, c.leafDepth)
+		}
+		c.leafDepth = depth
+
+		if c.last != nil {
+			if sibling := c.last.next(); sibling != tn {
+				return fmt.Errorf("expect leaf %s to point to sibling %s but got %s", c.last.ChildrenStr(), tn.ChildrenStr(), sibling.ChildrenStr())
+			}
+		}
+
+		c.last = tn
+		c.count += len(keys)
+		return nil
+	}
+
+	for i, p := range tn.ptrs {
+		cmin, cmax := &tn.keys[i], max
+		if i == 0 {
+			cmin = min
+		}
+
+		if i < len(tn.keys)-1 {
+			cmax = &tn.keys[i+1]
+		}
+
+		child, ok := p.(*tNode)
+		if !ok || child == nil {
+			return fmt.Errorf("child %d of %s is not a node: %+v", i, tn.ChildrenStr(), p)
+		}
+
+		if err := c.check(tn, child, cmin, cmax, depth+1, rightmost && i == len(tn.keys)-1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func bound(b *int64) string {
+	if b == nil {
+		return "inf"
+	}
+
+	return fmt.Sprint(*b)
+}
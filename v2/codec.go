@@ -0,0 +1,104 @@
+package v2
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// ValueCodec converts values stored in the tree to and from bytes
+// when the tree is persisted.
+type ValueCodec interface {
+	// Name identifies the codec in persisted files, opening a file
+	// with a codec of a different name fails.
+	Name() string
+	Encode(v interface{}) ([]byte, error)
+	Decode(b []byte) (interface{}, error)
+}
+
+// BytesCodec stores []byte and string values as raw bytes, values
+// are always decoded as []byte.
+type BytesCodec struct{}
+
+func (BytesCodec) Name() string {
+	return "bytes"
+}
+
+func (BytesCodec) Encode(v interface{}) ([]byte, error) {
+	switch val := v.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return val, nil
+	case string:
+		return []byte(val), nil
+	}
+
+	return nil, fmt.Errorf("bytes codec: unsupported value type %T", v)
+}
+
+func (BytesCodec) Decode(b []byte) (interface{}, error) {
+	return b, nil
+}
+
+// GobCodec encodes values with encoding/gob. Concrete types other than
+// the gob builtin ones must be registered with gob.Register.
+type GobCodec struct{}
+
+func (GobCodec) Name() string {
+	return "gob"
+}
+
+func (GobCodec) Encode(v interface{}) ([]byte, error) {
+	// an empty encoding stands for nil value
+	if v == nil {
+		return nil, nil
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := gob.NewEncoder(buf).Encode(&v); err != nil {
+		return nil, fmt.Errorf("gob codec: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Decode(b []byte) (interface{}, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+
+	var v interface{}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&v); err != nil {
+		return nil, fmt.Errorf("gob codec: %w", err)
+	}
+
+	return v, nil
+}
+
+// JSONCodec encodes values with encoding/json, values are decoded into
+// the generic json types, e.g. numbers are decoded as float64.
+type JSONCodec struct{}
+
+func (JSONCodec) Name() string {
+	return "json"
+}
+
+func (JSONCodec) Encode(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("json codec: %w", err)
+	}
+
+	return b, nil
+}
+
+func (JSONCodec) Decode(b []byte) (interface{}, error) {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, fmt.Errorf("json codec: %w", err)
+	}
+
+	return v, nil
+}
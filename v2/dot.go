@@ -0,0 +1,128 @@
+package v2
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// DOTOptions controls Graphviz rendering of WriteDOT.
+type DOTOptions struct {
+	// ParentEdges draws dotted edges from every node to its parent
+	ParentEdges bool
+	// NoSiblingEdges omits dashed edges between sibling leaves
+	NoSiblingEdges bool
+	// SearchPath highlights nodes and edges visited when searching
+	// for any of these keys
+	SearchPath []int64
+}
+
+// WriteDOT writes structure of tr into w in Graphviz DOT format. Nodes
+// are rendered as records with a port for every child pointer.
+func (tr *BPlusTree) WriteDOT(w io.Writer, opts *DOTOptions) error {
+	if opts == nil {
+		opts = &DOTOptions{}
+	}
+
+	nodes := levelOrder(tr.root)
+	ids := make(map[*tNode]int, len(nodes))
+	for i, tn := range nodes {
+		ids[tn] = i
+	}
+
+	// highlighted edges are keyed by child node
+	onPath := map[*tNode]bool{}
+	for _, key := range opts.SearchPath {
+		tn := tr.root
+		onPath[tn] = true
+		for !tn.isLeaf {
+			tn = tn.child(tn.findChildPos(key))
+			onPath[tn] = true
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString("digraph bplustree {\n")
+	buf.WriteString("\tnode [shape=record, height=.1];\n")
+
+	leaves := []*tNode{}
+	for _, tn := range nodes {
+		attrs := ""
+		if onPath[tn] {
+			attrs = `, style=filled, fillcolor="#ffe08a"`
+		}
+		fmt.Fprintf(buf, "\tn%d [label=\"%s\"%s];\n", ids[tn], dotLabel(tn), attrs)
+
+		if tn.isLeaf {
+			leaves = append(leaves, tn)
+			continue
+		}
+
+		for i, p := range tn.ptrs {
+			child := p.(*tNode)
+			attrs := ""
+			if onPath[child] {
+				attrs = " [color=red, penwidth=2]"
+			}
+			fmt.Fprintf(buf, "\tn%d:p%d -> n%d%s;\n", ids[tn], i, ids[child], attrs)
+		}
+	}
+
+	if !opts.NoSiblingEdges {
+		for _, tn := range leaves {
+			if sibling := tn.next(); sibling != nil {
+				fmt.Fprintf(buf, "\tn%d:next -> n%d [style=dashed, constraint=false];\n", ids[tn], ids[sibling])
+			}
+		}
+	}
+
+	if opts.ParentEdges {
+		for _, tn := range nodes {
+			if tn.parent == nil {
+				continue
+			}
+
+			// parent may be a node no longer in tree
+			pid, ok := ids[tn.parent]
+			if !ok {
+				fmt.Fprintf(buf, "\tn%d -> orphan%d [style=dotted, color=red, constraint=false];\n", ids[tn], ids[tn])
+				continue
+			}
+			fmt.Fprintf(buf, "\tn%d -> n%d [style=dotted, color=gray, constraint=false];\n", ids[tn], pid)
+		}
+	}
+
+	if len(leaves) > 1 {
+		buf.WriteString("\t{ rank=same;")
+		for _, tn := range leaves {
+			fmt.Fprintf(buf, " n%d;", ids[tn])
+		}
+		buf.WriteString(" }\n")
+	}
+
+	buf.WriteString("}\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// dotLabel renders tn as record label, internal nodes get a port for
+// every child and leaves get a port for the sibling pointer
+func dotLabel(tn *tNode) string {
+	buf := bytes.NewBuffer(nil)
+	if tn.isLeaf {
+		for _, k := range tn.keys[:len(tn.keys)-1] {
+			fmt.Fprintf(buf, "%d|", k)
+		}
+		buf.WriteString("<next>")
+		return buf.String()
+	}
+
+	for i, k := range tn.keys {
+		if i > 0 {
+			fmt.Fprintf(buf, "|%d|", k)
+		}
+		fmt.Fprintf(buf, "<p%d>", i)
+	}
+
+	return buf.String()
+}
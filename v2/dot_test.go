@@ -0,0 +1,37 @@
+package v2
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteDOT(t *testing.T) {
+	tr := newTree(t, 3, 4, 1)
+	buf := bytes.NewBuffer(nil)
+	if err := tr.WriteDOT(buf, &DOTOptions{ParentEdges: true, SearchPath: []int64{4}}); err != nil {
+		t.Fatalf("error writing dot: %+v", err)
+	}
+
+	// tree:
+	//      (3)
+	// (1,2)   (3,4)
+	dot := buf.String()
+	t.Logf("dot:\n%s", dot)
+	lines := []string{
+		`n0 [label="<p0>|3|<p1>", style=filled, fillcolor="#ffe08a"];`,
+		`n1 [label="1|2|<next>"];`,
+		`n2 [label="3|4|<next>", style=filled, fillcolor="#ffe08a"];`,
+		`n0:p0 -> n1;`,
+		`n0:p1 -> n2 [color=red, penwidth=2];`,
+		`n1:next -> n2 [style=dashed, constraint=false];`,
+		`n1 -> n0 [style=dotted, color=gray, constraint=false];`,
+		`{ rank=same; n1; n2; }`,
+	}
+
+	for _, l := range lines {
+		if !strings.Contains(dot, "\t"+l+"\n") {
+			t.Fatalf("expect line %q in dot output", l)
+		}
+	}
+}
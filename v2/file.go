@@ -0,0 +1,395 @@
+package v2
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// FileOptions controls how a tree is persisted to and loaded from file.
+type FileOptions struct {
+	// PageSize is the size of a page in bytes, defaults to 4096.
+	// Nodes larger than a page span multiple contiguous pages.
+	PageSize int
+	// Codec encodes values of the tree, defaults to GobCodec.
+	Codec ValueCodec
+	// VerifyOnOpen verifies checksum of every page in the file at
+	// open time, including pages not reachable from root.
+	VerifyOnOpen bool
+	// TruncateSeparators stores for every key of an internal node the
+	// key with most trailing zero bytes that still parts the children
+	// around it instead of the key in tree, so that internal pages
+	// store fewer bytes per key. Trees loaded from file route keys in
+	// tree the same way but may have other internal keys.
+	TruncateSeparators bool
+	// TreeOptions configure the tree loaded by OpenFile, capacities
+	// and min fill are restored from file instead. A custom comparator
+	// is refused since the file keeps keys in ascending order.
+	TreeOptions []Option
+}
+
+func (opts *FileOptions) withDefaults() *FileOptions {
+	o := FileOptions{}
+	if opts != nil {
+		o = *opts
+	}
+
+	if o.PageSize == 0 {
+		o.PageSize = defaultPageSize
+	}
+
+	if o.Codec == nil {
+		o.Codec = GobCodec{}
+	}
+
+	return &o
+}
+
+// SaveFile persists tr into file at path. The file is written to a
+// temporary file first and renamed to path once it has been synced.
+func (tr *BPlusTree) SaveFile(path string, opts *FileOptions) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	w := bufio.NewWriter(tmp)
+	if err := tr.writePages(w, opts); err != nil {
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// writePages writes tr into w in page format, every node keeps its
+// exact shape unless tr holds expired keys, which are left out. Internal
+// keys are kept as well unless opts.TruncateSeparators is set.
+func (tr *BPlusTree) writePages(w io.Writer, opts *FileOptions) error {
+	if tr.cfg.cmp != nil {
+		return ErrCustomOrder
+	}
+
+	if tr.ttl != nil {
+		tr = tr.withoutExpired(tr.now())
+	}
+
+	opts = opts.withDefaults()
+	if opts.PageSize < minPageSize {
+		return fmt.Errorf("page size should be at least %d: %d", minPageSize, opts.PageSize)
+	}
+
+	nodes := levelOrder(tr.root)
+	ids := make(map[*tNode]uint64, len(nodes))
+	payloads := make([][]byte, len(nodes))
+	keys := make([][]int64, len(nodes))
+
+	// page 0 is reserved for meta
+	next := uint64(1)
+	for i, tn := range nodes {
+		if tn.isLeaf {
+			payload, err := encodeLeafPage(tn, opts.Codec)
+			if err != nil {
+				return err
+			}
+			payloads[i] = payload
+		} else {
+			keys[i] = tn.keys
+			if opts.TruncateSeparators {
+				keys[i] = truncateSeparators(tn)
+			}
+			payloads[i] = make([]byte, internalPageSize(keys[i]))
+		}
+
+		ids[tn] = next
+		next += uint64(pageSpan(len(payloads[i]), opts.PageSize))
+	}
+
+	meta := &fileMeta{
+		pageSize: opts.PageSize,
+		maxSize:  tr.cfg.innerCap,
+		leafCap:  tr.cfg.leafCap,
+		minFill:  tr.cfg.minFill,
+		root:     ids[tr.root],
+		count:    tr.size,
+		pages:    next,
+		codec:    opts.Codec.Name(),
+	}
+
+	if _, err := w.Write(framePage(0, pageTypeMeta, meta.encode(), opts.PageSize)); err != nil {
+		return err
+	}
+
+	for i, tn := range nodes {
+		typ := byte(pageTypeInternal)
+		if tn.isLeaf {
+			typ = pageTypeLeaf
+			// fill in page id of sibling
+			if sibling := tn.next(); sibling != nil {
+				binary.LittleEndian.PutUint64(payloads[i], ids[sibling])
+			}
+		} else {
+			encodeInternalPage(payloads[i], tn, keys[i], ids)
+		}
+
+		if _, err := w.Write(framePage(ids[tn], typ, payloads[i], opts.PageSize)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// OpenFile loads tree persisted by SaveFile into memory, every page
+// is verified against its checksum as it is read.
+func OpenFile(path string, opts *FileOptions) (*BPlusTree, error) {
+	opts = opts.withDefaults()
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	pf, err := openPageFile(readerAtSource{f}, fi.Size(), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return pf.load(opts.TreeOptions)
+}
+
+type pageFile struct {
+	src   pageSource
+	meta  *fileMeta
+	codec ValueCodec
+}
+
+func openPageFile(src pageSource, size int64, opts *FileOptions) (*pageFile, error) {
+	meta, err := readMeta(src)
+	if err != nil {
+		return nil, err
+	}
+
+	// a short file is most likely the result of a torn write
+	if want := int64(meta.pages) * int64(meta.pageSize); size != want {
+		return nil, corruptPage(0, "expect file of %d pages(%d bytes) but got %d bytes", meta.pages, want, size)
+	}
+
+	if meta.codec != opts.Codec.Name() {
+		return nil, fmt.Errorf("file encoded with codec %q but opened with codec %q", meta.codec, opts.Codec.Name())
+	}
+
+	pf := &pageFile{
+		src:   src,
+		meta:  meta,
+		codec: opts.Codec,
+	}
+
+	if opts.VerifyOnOpen {
+		if err := pf.verify(); err != nil {
+			return nil, err
+		}
+	}
+
+	return pf, nil
+}
+
+func (pf *pageFile) readPage(id uint64) (*page, error) {
+	if id == 0 || id >= pf.meta.pages {
+		return nil, corruptPage(id, "page id out of range [1, %d)", pf.meta.pages)
+	}
+
+	return readPage(pf.src, id, pf.meta.pageSize, pf.meta.pages)
+}
+
+// verify verifies checksum and layout of all pages in file
+func (pf *pageFile) verify() error {
+	for id := uint64(1); id < pf.meta.pages; {
+		p, err := pf.readPage(id)
+		if err != nil {
+			return err
+		}
+
+		if err := p.check(); err != nil {
+			return err
+		}
+
+		id += uint64(p.span)
+	}
+
+	return nil
+}
+
+// load decodes all pages reachable from root into an in memory tree
+// configured by opts, capacities and min fill are taken from meta
+func (pf *pageFile) load(opts []Option) (*BPlusTree, error) {
+	base, err := NewTree(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if base.cfg.cmp != nil {
+		return nil, ErrCustomOrder
+	}
+
+	base.cfg.minFill = pf.meta.minFill
+	l := &loader{pf: pf, cfg: base.loadConfig(pf.meta.leafCap, pf.meta.maxSize), leafDepth: -1}
+	root, err := l.loadNode(pf.meta.root, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.next != 0 {
+		return nil, corruptPage(l.lastID, "last leaf points to page %d", l.next)
+	}
+
+	if l.count != pf.meta.count {
+		return nil, corruptPage(0, "expect %d keys but got %d", pf.meta.count, l.count)
+	}
+
+	tr := &BPlusTree{
+		cfg:   l.cfg,
+		root:  root,
+		size:  l.count,
+		obs:   base.obs,
+		codec: base.codec,
+		clock: base.clock,
+	}
+
+	return tr, nil
+}
+
+type loader struct {
+	pf        *pageFile
+	cfg       *config
+	leafDepth int
+	count     int
+	visited   int
+	last      *tNode
+	lastID    uint64
+	// next is the page id that the previously loaded leaf points to
+	next uint64
+}
+
+func (l *loader) loadNode(id uint64, depth int) (*tNode, error) {
+	// every node occupies at least one page, guard against cycles
+	l.visited++
+	if uint64(l.visited) >= l.pf.meta.pages {
+		return nil, corruptPage(id, "cycle detected")
+	}
+
+	p, err := l.pf.readPage(id)
+	if err != nil {
+		return nil, err
+	}
+
+	leafCap, maxSize := l.pf.meta.leafCap, l.pf.meta.maxSize
+	switch p.typ {
+	case pageTypeLeaf:
+		lp := leafPage(p.payload)
+		if err := lp.check(id); err != nil {
+			return nil, err
+		}
+
+		if l.leafDepth >= 0 && l.leafDepth != depth {
+			return nil, corruptPage(id, "leaf at depth %d but expect %d", depth, l.leafDepth)
+		}
+		l.leafDepth = depth
+
+		if l.last != nil && l.next != id {
+			return nil, corruptPage(l.lastID, "leaf points to page %d but next leaf is page %d", l.next, id)
+		}
+
+		n := lp.size()
+		if n > leafCap {
+			return nil, corruptPage(id, "leaf of %d keys exceeds leaf capacity %d", n, leafCap)
+		}
+
+		tn := l.cfg.newNode(true)
+		tn.appended = lp.appended()
+		tn.resize(n + 1)
+		for i := 0; i < n; i++ {
+			v, err := l.pf.codec.Decode(lp.value(i))
+			if err != nil {
+				return nil, fmt.Errorf("page %d: error decoding value of key %d: %w", id, lp.key(i), err)
+			}
+			tn.setEntry(i, Entry{key: lp.key(i), pointer: v})
+		}
+
+		if l.last != nil {
+			l.last.ptrs[len(l.last.keys)-1] = tn
+		}
+		l.last, l.lastID, l.next = tn, id, lp.next()
+		l.count += n
+		return tn, nil
+
+	case pageTypeInternal:
+		ip := internalPage(p.payload)
+		if err := ip.check(id); err != nil {
+			return nil, err
+		}
+
+		n := ip.size()
+		if n > maxSize {
+			return nil, corruptPage(id, "internal node of %d children exceeds max size %d", n, maxSize)
+		}
+
+		tn := l.cfg.newNode(false)
+		tn.appended = ip.appended()
+		tn.resize(n)
+		for i := 0; i < n; i++ {
+			child, err := l.loadNode(ip.child(i), depth+1)
+			if err != nil {
+				return nil, err
+			}
+
+			child.parent = tn
+			tn.ptrs[i] = child
+			if i > 0 {
+				tn.keys[i] = ip.key(i)
+			}
+		}
+
+		return tn, nil
+	}
+
+	return nil, corruptPage(id, "unexpected page type %d", p.typ)
+}
+
+// levelOrder returns nodes of tree rooted at root in level order
+func levelOrder(root *tNode) []*tNode {
+	nodes := []*tNode{root}
+	for i := 0; i < len(nodes); i++ {
+		tn := nodes[i]
+		if tn.isLeaf {
+			continue
+		}
+
+		for _, p := range tn.ptrs {
+			nodes = append(nodes, p.(*tNode))
+		}
+	}
+
+	return nodes
+}
@@ -0,0 +1,500 @@
+package v2
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func saveTree(t *testing.T, tr *BPlusTree, opts *FileOptions) string {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	if err := tr.SaveFile(path, opts); err != nil {
+		t.Fatalf("error saving tree: %+v", err)
+	}
+
+	return path
+}
+
+func TestFileSaveOpen(t *testing.T) {
+	cases := []struct {
+		maxSize  int
+		numKeys  int
+		pageSize int
+	}{
+		{maxSize: 4, numKeys: 0},
+		{maxSize: 4, numKeys: 3},
+		{maxSize: 4, numKeys: 100},
+		{maxSize: 64, numKeys: 1000},
+		// nodes span multiple pages
+		{maxSize: 128, numKeys: 1000, pageSize: 512},
+	}
+
+	for i, tc := range cases {
+		tr := newTree(t, tc.maxSize, tc.numKeys, 3)
+		path := saveTree(t, tr, &FileOptions{PageSize: tc.pageSize})
+
+		ltr, err := OpenFile(path, &FileOptions{VerifyOnOpen: true})
+		if err != nil {
+			t.Fatalf("case %d: error opening tree: %+v", i, err)
+		}
+
+		if ltr.Len() != tc.numKeys {
+			t.Fatalf("case %d: expect %d keys but got %d", i, tc.numKeys, ltr.Len())
+		}
+
+		if ltr.ToString() != tr.ToString() {
+			t.Fatalf("case %d: expect tree:\n%s\nbut got:\n%s", i, tr.ToString(), ltr.ToString())
+		}
+
+		for k := 1; k <= tc.numKeys*3; k++ {
+			v, err := ltr.Find(int64(k))
+			if k%3 != 1 {
+				if err != ErrKeyNotFound {
+					t.Fatalf("case %d: expect error %+v but got %+v", i, ErrKeyNotFound, err)
+				}
+				continue
+			}
+
+			if !reflect.DeepEqual(v, k) {
+				t.Fatalf("case %d: expect val %d but got %+v", i, k, v)
+			}
+		}
+
+		// loaded tree is still writable
+		if err := ltr.Insert(&Entry{key: 2, pointer: 2}); err != nil {
+			t.Fatalf("case %d: error inserting key 2: %+v", i, err)
+		}
+	}
+}
+
+func TestFileBytesCodec(t *testing.T) {
+	tr := newTree(t, 4, 0, 0)
+	tr.Insert(&Entry{key: 1, pointer: []byte("foo")})
+	tr.Insert(&Entry{key: 2, pointer: "bar"})
+	path := saveTree(t, tr, &FileOptions{Codec: BytesCodec{}})
+
+	if _, err := OpenFile(path, nil); err == nil {
+		t.Fatalf("expect codec mismatch error but got none")
+	}
+
+	ltr, err := OpenFile(path, &FileOptions{Codec: BytesCodec{}})
+	if err != nil {
+		t.Fatalf("error opening tree: %+v", err)
+	}
+
+	if v, _ := ltr.Find(2); !reflect.DeepEqual(v, []byte("bar")) {
+		t.Fatalf("expect value %q but got %+v", "bar", v)
+	}
+
+	tr.Insert(&Entry{key: 3, pointer: 3})
+	if err := tr.SaveFile(path, &FileOptions{Codec: BytesCodec{}}); err == nil {
+		t.Fatalf("expect error encoding int value but got none")
+	}
+}
+
+func TestFileChecksumMismatch(t *testing.T) {
+	tr := newTree(t, 4, 20, 1)
+	path := saveTree(t, tr, nil)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// flip a key in the last leaf
+	last := len(data)/defaultPageSize - 1
+	data[last*defaultPageSize+pageHeaderSize+leafFixedSize] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, verify := range []bool{false, true} {
+		_, err = OpenFile(path, &FileOptions{VerifyOnOpen: verify})
+		var cerr *ErrChecksumMismatch
+		if !errors.As(err, &cerr) {
+			t.Fatalf("expect checksum mismatch but got %+v", err)
+		}
+
+		if cerr.PageID != uint64(last) {
+			t.Fatalf("expect checksum mismatch on page %d but got page %d", last, cerr.PageID)
+		}
+	}
+}
+
+func TestFileTornWrite(t *testing.T) {
+	tr := newTree(t, 4, 20, 1)
+	path := saveTree(t, tr, nil)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, data[:len(data)-defaultPageSize/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = OpenFile(path, nil)
+	var cerr *ErrCorruptPage
+	if !errors.As(err, &cerr) {
+		t.Fatalf("expect corrupt page error but got %+v", err)
+	}
+}
+
+func TestFileVerifyOnOpen(t *testing.T) {
+	tr := newTree(t, 4, 20, 1)
+	path := saveTree(t, tr, nil)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// corrupt page header of the last page, pages are only verified
+	// lazily when VerifyOnOpen is not set
+	last := len(data)/defaultPageSize - 1
+	data[last*defaultPageSize+16] ^= 0xff
+	src := bytesSource(data)
+	if _, err := openPageFile(src, int64(len(data)), (&FileOptions{}).withDefaults()); err != nil {
+		t.Fatalf("expect no error but got %+v", err)
+	}
+
+	_, err = openPageFile(src, int64(len(data)), (&FileOptions{VerifyOnOpen: true}).withDefaults())
+	var cerr *ErrChecksumMismatch
+	if !errors.As(err, &cerr) || cerr.PageID != uint64(last) {
+		t.Fatalf("expect checksum mismatch on page %d but got %+v", last, err)
+	}
+}
+
+// downgradeV1 rewrites pages of a saved tree in format version 1
+// legacyPayload rewrites node payload with full 8 byte keys and no key
+// prefix as format version 2 or earlier lays it out
+func legacyPayload(typ byte, payload []byte) []byte {
+	if typ == pageTypeLeaf {
+		lp := leafPage(payload)
+		n := lp.size()
+		buf := make([]byte, leafFixedSize+n*8, len(payload)+n*8)
+		copy(buf, payload[:12])
+		for i := 0; i < n; i++ {
+			binary.LittleEndian.PutUint64(buf[leafFixedSize+i*8:], uint64(lp.key(i)))
+		}
+		return append(buf, payload[lp.offsets():]...)
+	}
+
+	ip := internalPage(payload)
+	n := ip.size()
+	buf := make([]byte, nodeFixedSize+n*16)
+	binary.LittleEndian.PutUint32(buf, uint32(n))
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			binary.LittleEndian.PutUint64(buf[nodeFixedSize+i*8:], uint64(ip.key(i)))
+		}
+		binary.LittleEndian.PutUint64(buf[nodeFixedSize+n*8+i*8:], ip.child(i))
+	}
+	return buf
+}
+
+// downgradeV1 rewrites a saved tree in format version 1, page ids are
+// kept so every node must stay in its span of pages
+func downgradeV1(data []byte, pageSize int) []byte {
+	var out []byte
+	for off := 0; off < len(data); {
+		span := int(binary.LittleEndian.Uint32(data[off+8:]))
+		sz := int(binary.LittleEndian.Uint32(data[off+12:]))
+		typ := data[off+4]
+		payload := data[off+pageHeaderSize : off+pageHeaderSize+sz]
+		if off == 0 {
+			// meta of version 1 has no leaf capacity
+			payload = append(payload[:metaFixedSizeV1:metaFixedSizeV1], payload[metaFixedSize:]...)
+		} else {
+			payload = legacyPayload(typ, payload)
+		}
+
+		buf := framePage(uint64(off/pageSize), typ, payload, pageSize)
+		if off > 0 && len(buf) != span*pageSize {
+			panic("legacy node outgrows its span of pages")
+		}
+
+		buf[5] = 1
+		binary.LittleEndian.PutUint32(buf, crc32.Checksum(buf[4:pageHeaderSize+len(payload)], crcTable))
+		out = append(out, buf...)
+		off += span * pageSize
+	}
+
+	return out
+}
+
+func TestFileFormatV1(t *testing.T) {
+	tr := newTree(t, 4, 100, 1)
+	data, err := os.ReadFile(saveTree(t, tr, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "v1.db")
+	if err := os.WriteFile(path, downgradeV1(data, defaultPageSize), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ntr, err := OpenFile(path, nil)
+	if err != nil {
+		t.Fatalf("error opening version 1 file: %+v", err)
+	}
+
+	if ntr.cfg.leafCap != 3 || ntr.cfg.innerCap != 4 || !reflect.DeepEqual(entries(ntr), entries(tr)) {
+		t.Fatalf("expect capacities 3 and 4 and entries %+v but got %d, %d and %+v", entries(tr), ntr.cfg.leafCap, ntr.cfg.innerCap, entries(ntr))
+	}
+}
+
+func TestFileMinFill(t *testing.T) {
+	tr, _ := NewTree(WithFanout(8), WithMinFill(0.25))
+	for k := int64(0); k < 200; k++ {
+		tr.Insert(&Entry{key: k, pointer: int(k)})
+	}
+	// leaves are left a quarter full
+	for k := int64(0); k < 200; k++ {
+		if k%4 != 0 {
+			tr.Delete(k)
+		}
+	}
+	path := saveTree(t, tr, nil)
+
+	ltr, err := OpenFile(path, &FileOptions{TreeOptions: []Option{WithDupPolicy(DupReplace)}})
+	if err != nil {
+		t.Fatalf("error opening tree: %+v", err)
+	}
+
+	if ltr.cfg.minFill != 0.25 || ltr.cfg.dup != DupReplace {
+		t.Fatalf("expect min fill 0.25 and policy %s but got %v and %s", DupReplace, ltr.cfg.minFill, ltr.cfg.dup)
+	}
+
+	if err := ltr.Check(); err != nil {
+		t.Fatalf("error checking loaded tree: %+v", err)
+	}
+
+	if ltr.ToString() != tr.ToString() {
+		t.Fatalf("expect tree:\n%s\nbut got:\n%s", tr.ToString(), ltr.ToString())
+	}
+
+	if err := ltr.Insert(&Entry{key: 4, pointer: 0}); err != nil {
+		t.Fatalf("expect key 4 replaced but got %+v", err)
+	}
+
+	reverse := WithComparator(func(a, b int64) int { return int(b - a) })
+	if _, err := OpenFile(path, &FileOptions{TreeOptions: []Option{reverse}}); !errors.Is(err, ErrCustomOrder) {
+		t.Fatalf("expect ErrCustomOrder but got %+v", err)
+	}
+}
+
+func TestFileKeyPrefix(t *testing.T) {
+	cases := []struct {
+		keys  []int64
+		width int
+	}{
+		{nil, 1},
+		{[]int64{42}, 1},
+		{[]int64{0x0123456789abcd00, 0x0123456789abcdff}, 1},
+		{[]int64{0x0123456789ab0000, 0x0123456789abffff}, 2},
+		{[]int64{-2, -1}, 1},
+		{[]int64{-1, 0}, 8},
+		{[]int64{math.MinInt64, math.MaxInt64}, 8},
+	}
+
+	for _, c := range cases {
+		prefix, w := keyPrefix(c.keys)
+		if w != c.width {
+			t.Fatalf("keys %x: expect width %d but got %d", c.keys, c.width, w)
+		}
+
+		buf := make([]byte, 8)
+		for _, k := range c.keys {
+			putSuffix(buf, k, w)
+			if got := int64(prefix | getSuffix(buf, w)); got != k {
+				t.Fatalf("keys %x: expect key %x but got %x", c.keys, k, got)
+			}
+		}
+	}
+
+	// keys of a tenant share their leading bytes
+	tr, _ := NewTree(WithFanout(128))
+	for tenant := int64(1); tenant <= 4; tenant++ {
+		for i := int64(0); i < 1000; i++ {
+			tr.Insert(&Entry{key: tenant<<40 | i, pointer: int(i)})
+		}
+	}
+	tr.Insert(&Entry{key: math.MinInt64, pointer: 0})
+	tr.Insert(&Entry{key: math.MaxInt64, pointer: 0})
+
+	path := saveTree(t, tr, &FileOptions{PageSize: minPageSize})
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pages, legacy := 0, 0
+	for off := minPageSize; off < len(data); {
+		span := int(binary.LittleEndian.Uint32(data[off+8:]))
+		sz := int(binary.LittleEndian.Uint32(data[off+12:]))
+		payload := legacyPayload(data[off+4], data[off+pageHeaderSize:off+pageHeaderSize+sz])
+		pages += span
+		legacy += pageSpan(len(payload), minPageSize)
+		off += span * minPageSize
+	}
+
+	if pages >= legacy {
+		t.Fatalf("expect nodes in less than %d pages but got %d", legacy, pages)
+	}
+	t.Logf("nodes take %d pages, %d without key prefix", pages, legacy)
+
+	ltr, err := OpenFile(path, &FileOptions{VerifyOnOpen: true})
+	if err != nil {
+		t.Fatalf("error opening tree: %+v", err)
+	}
+
+	rt, err := OpenReadOnly(path, nil)
+	if err != nil {
+		t.Fatalf("error opening read only tree: %+v", err)
+	}
+	defer rt.Close()
+
+	for _, e := range entries(tr) {
+		if v, err := ltr.Find(e.key); err != nil || v != e.value {
+			t.Fatalf("expect value %v of key %x but got %v, %+v", e.value, e.key, v, err)
+		}
+
+		if v, err := rt.Find(e.key); err != nil || v != e.value {
+			t.Fatalf("read only: expect value %v of key %x but got %v, %+v", e.value, e.key, v, err)
+		}
+	}
+}
+
+// internalBytes returns payload bytes of internal pages in a saved tree
+func internalBytes(data []byte, pageSize int) int {
+	n := 0
+	for off := pageSize; off < len(data); {
+		span := int(binary.LittleEndian.Uint32(data[off+8:]))
+		if data[off+4] == pageTypeInternal {
+			n += int(binary.LittleEndian.Uint32(data[off+12:]))
+		}
+		off += span * pageSize
+	}
+
+	return n
+}
+
+func TestFileSeparators(t *testing.T) {
+	cases := []struct {
+		lo, hi, want int64
+	}{
+		{0x12ff, 0x1301, 0x1300},
+		{0x10, 0x12, 0x12},
+		{5, 6, 6},
+		{-1, 0, 0},
+		{-300, -5, -256},
+		{0x0123456789abcdef, 0x0123466789abcdef, 0x0123460000000000},
+		{math.MinInt64, math.MaxInt64, 0x7f00000000000000},
+	}
+
+	for _, c := range cases {
+		if got := shortestSeparator(c.lo, c.hi); got != c.want {
+			t.Fatalf("expect separator %x between %x and %x but got %x", c.want, c.lo, c.hi, got)
+		}
+	}
+
+	// keys apart by 1<<16 share two low zero bytes, which are dropped
+	// from internal keys without changing them
+	tr, _ := NewTree(WithFanout(16))
+	for i := int64(-1000); i < 1000; i++ {
+		tr.Insert(&Entry{key: i << 16, pointer: int(i)})
+	}
+	path := saveTree(t, tr, nil)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for off := defaultPageSize; off < len(data); {
+		span := int(binary.LittleEndian.Uint32(data[off+8:]))
+		if ip := internalPage(data[off+pageHeaderSize:]); data[off+4] == pageTypeInternal && ip.low() < 2 {
+			t.Fatalf("expect at least 2 low bytes dropped from keys of page %d but got %d", off/defaultPageSize, ip.low())
+		}
+		off += span * defaultPageSize
+	}
+
+	ltr, err := OpenFile(path, &FileOptions{VerifyOnOpen: true})
+	if err != nil {
+		t.Fatalf("error opening tree: %+v", err)
+	}
+
+	if ltr.ToString() != tr.ToString() {
+		t.Fatalf("expect tree:\n%s\nbut got:\n%s", tr.ToString(), ltr.ToString())
+	}
+
+	// sparse keys leave room for separators with low zero bytes
+	r := rand.New(rand.NewSource(1))
+	tr, _ = NewTree(WithFanout(64))
+	for i := 0; i < 10000; i++ {
+		tr.Insert(&Entry{key: r.Int63() - 1<<62, pointer: i})
+	}
+
+	exact, err := os.ReadFile(saveTree(t, tr, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path = saveTree(t, tr, &FileOptions{TruncateSeparators: true})
+	truncated, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, m := internalBytes(truncated, defaultPageSize), internalBytes(exact, defaultPageSize); n >= m {
+		t.Fatalf("expect internal pages in less than %d bytes but got %d", m, n)
+	} else {
+		t.Logf("internal pages take %d bytes, %d with exact separators", n, m)
+	}
+
+	ltr, err = OpenFile(path, &FileOptions{VerifyOnOpen: true})
+	if err != nil {
+		t.Fatalf("error opening tree: %+v", err)
+	}
+
+	if err := ltr.Check(); err != nil {
+		t.Fatalf("error checking loaded tree: %+v", err)
+	}
+
+	if !reflect.DeepEqual(entries(ltr), entries(tr)) {
+		t.Fatalf("expect entries of loaded tree same as saved one")
+	}
+
+	rt, err := OpenReadOnly(path, nil)
+	if err != nil {
+		t.Fatalf("error opening read only tree: %+v", err)
+	}
+	defer rt.Close()
+
+	for i := 0; i < 1000; i++ {
+		key := r.Int63() - 1<<62
+		wv, werr := tr.Find(key)
+		if v, err := ltr.Find(key); v != wv || !errors.Is(err, werr) {
+			t.Fatalf("expect %v, %v finding key %x but got %v, %v", wv, werr, key, v, err)
+		}
+
+		wk, wv, werr := tr.Floor(key)
+		if k, v, err := rt.Floor(key); k != wk || v != wv || !errors.Is(err, werr) {
+			t.Fatalf("read only: expect floor %x, %v, %v of key %x but got %x, %v, %v", wk, wv, werr, key, k, v, err)
+		}
+	}
+
+	for _, e := range entries(tr) {
+		if v, err := rt.Find(e.key); err != nil || v != e.value {
+			t.Fatalf("read only: expect value %v of key %x but got %v, %+v", e.value, e.key, v, err)
+		}
+	}
+}
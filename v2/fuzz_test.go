@@ -0,0 +1,215 @@
+package v2
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+const (
+	fuzzInsert = iota
+	fuzzDelete
+	fuzzFind
+	fuzzInsertBatch
+	fuzzDeleteBatch
+	fuzzNumOps
+)
+
+// fuzz option flags, the high nibble is leaf capacity if
+// fuzzLeafCapacity is set
+const (
+	fuzzBStar = 1 << iota
+	fuzzMinFill
+	fuzzLeafCapacity
+	fuzzAppend
+)
+
+// model is the reference trees are compared with
+type model struct {
+	values map[int64]int
+	keys   []int64 // sorted
+}
+
+func (m *model) find(key int64) int {
+	return sort.Search(len(m.keys), func(i int) bool { return m.keys[i] >= key })
+}
+
+func (m *model) insert(key int64, value int) bool {
+	if _, ok := m.values[key]; ok {
+		return false
+	}
+
+	m.values[key] = value
+	pos := m.find(key)
+	m.keys = append(m.keys, 0)
+	copy(m.keys[pos+1:], m.keys[pos:])
+	m.keys[pos] = key
+	return true
+}
+
+func (m *model) delete(key int64) bool {
+	if _, ok := m.values[key]; !ok {
+		return false
+	}
+
+	delete(m.values, key)
+	pos := m.find(key)
+	m.keys = append(m.keys[:pos], m.keys[pos+1:]...)
+	return true
+}
+
+// fuzzOptions decodes fanout and option flags into tree options
+func fuzzOptions(fanout, flags byte) []Option {
+	opts := []Option{WithFanout(3 + int(fanout%14))}
+	if flags&fuzzBStar != 0 {
+		opts = append(opts, WithBStar())
+	}
+
+	if flags&fuzzMinFill != 0 {
+		opts = append(opts, WithMinFill(0.25))
+	}
+
+	if flags&fuzzLeafCapacity != 0 {
+		opts = append(opts, WithLeafCapacity(2+int(flags>>4)))
+	}
+
+	if flags&fuzzAppend != 0 {
+		opts = append(opts, WithAppendFastPath())
+	}
+
+	return opts
+}
+
+// checkBatch compares error of a batch with keys expected to fail
+func checkBatch(err error, failed []int64, allOrNothing bool) error {
+	if len(failed) == 0 {
+		return err
+	}
+
+	var be *BatchError
+	if !errors.As(err, &be) {
+		return errors.New("expect batch error")
+	}
+
+	keys := []int64{}
+	for _, ke := range be.Errs {
+		keys = append(keys, ke.Key)
+	}
+
+	if !reflect.DeepEqual(keys, failed) || be.Applied == allOrNothing {
+		return errors.New("unexpected failed keys or applied flag")
+	}
+
+	return nil
+}
+
+// FuzzTrees decodes data into a fanout and option flags followed by a
+// sequence of operations, each of which takes two bytes: op and key.
+// The op byte also encodes size and mode of batch ops. Every op is
+// applied to the tree and the model, results and content of the tree
+// are compared with the model after every step.
+func FuzzTrees(f *testing.F) {
+	f.Add([]byte{0, 0, 0, 0, 1, 0, 2, 0, 3, 1, 1, 1, 2})
+	f.Add([]byte{1, fuzzBStar, 0, 5, 0, 4, 0, 3, 0, 2, 0, 1, 1, 5, 1, 4})
+	f.Add([]byte{5, fuzzMinFill | fuzzAppend, 0, 1, 0, 2, 0, 3, 0, 4, 0, 5, 1, 2, 1, 3, 1, 4})
+	f.Add([]byte{13, 0x70 | fuzzLeafCapacity | fuzzBStar, 0x73, 0, 0x7c, 9, 0x34, 3, 0x7c, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) < 2 {
+			return
+		}
+
+		tr, err := NewTree(fuzzOptions(data[0], data[1])...)
+		if err != nil {
+			t.Fatalf("error creating tree of options %#x %#x: %+v", data[0], data[1], err)
+		}
+
+		m := &model{values: map[int64]int{}}
+		for step := 0; 2*step+3 < len(data); step++ {
+			b := data[2*step+2]
+			op := b % fuzzNumOps
+			key := int64(int8(data[2*step+3]))
+			// batches of up to 8 keys 3 apart
+			var batch []int64
+			for i := 0; i <= int(b/fuzzNumOps)%8; i++ {
+				batch = append(batch, key+int64(3*i))
+			}
+			bopts := &BatchOptions{AllOrNothing: b/fuzzNumOps/8%2 == 1}
+
+			switch op {
+			case fuzzInsert:
+				ok := m.insert(key, step)
+				if err := tr.Insert(NewEntry(key, step)); (err == nil) != ok {
+					t.Fatalf("step %d, insert key %d, expect success %t but got %+v", step, key, ok, err)
+				}
+			case fuzzDelete:
+				ok := m.delete(key)
+				if err := tr.Delete(key); (err == nil) != ok {
+					t.Fatalf("step %d, delete key %d, expect success %t but got %+v", step, key, ok, err)
+				}
+			case fuzzFind:
+				wv, ok := m.values[key]
+				if v, err := tr.Find(key); (ok && (err != nil || v != wv)) || (!ok && !errors.Is(err, ErrKeyNotFound)) {
+					t.Fatalf("step %d, find key %d, expect (%d, %t) but got (%+v, %+v)", step, key, wv, ok, v, err)
+				}
+			case fuzzInsertBatch:
+				var kvs []KV
+				var failed []int64
+				for _, k := range batch {
+					kvs = append(kvs, KV{Key: k, Value: step})
+					if _, ok := m.values[k]; ok {
+						failed = append(failed, k)
+					}
+				}
+
+				if len(failed) == 0 || !bopts.AllOrNothing {
+					for _, k := range batch {
+						m.insert(k, step)
+					}
+				}
+
+				if err := checkBatch(tr.InsertBatch(kvs, bopts), failed, bopts.AllOrNothing); err != nil {
+					t.Fatalf("step %d, insert batch %+v of options %+v, expect failed keys %+v: %+v", step, batch, *bopts, failed, err)
+				}
+			case fuzzDeleteBatch:
+				var failed []int64
+				for _, k := range batch {
+					if _, ok := m.values[k]; !ok {
+						failed = append(failed, k)
+					}
+				}
+
+				if len(failed) == 0 || !bopts.AllOrNothing {
+					for _, k := range batch {
+						m.delete(k)
+					}
+				}
+
+				if err := checkBatch(tr.DeleteBatch(batch, bopts), failed, bopts.AllOrNothing); err != nil {
+					t.Fatalf("step %d, delete batch %+v of options %+v, expect failed keys %+v: %+v", step, batch, *bopts, failed, err)
+				}
+			}
+
+			if err := tr.Check(); err != nil {
+				t.Fatalf("step %d, broken tree:\n%s\nerror: %+v", step, tr.ToString(), err)
+			}
+
+			compareContent(t, step, m, tr)
+		}
+	})
+}
+
+func compareContent(t *testing.T, step int, m *model, tr *BPlusTree) {
+	i := 0
+	tr.Range(-1<<63, 1<<63-1, func(key int64, p interface{}) bool {
+		if i >= len(m.keys) || key != m.keys[i] || p != m.values[key] {
+			t.Fatalf("step %d, entry %d, expect keys %+v but got (%d, %+v)", step, i, m.keys, key, p)
+		}
+		i++
+		return true
+	})
+	if i != len(m.keys) || tr.Len() != len(m.keys) {
+		t.Fatalf("step %d, expect %d keys but got %d, Len %d", step, len(m.keys), i, tr.Len())
+	}
+}
@@ -0,0 +1,332 @@
+// Package httpdebug serves a live view of a tree over HTTP, much like
+// net/http/pprof does for profiles:
+//
+//	mux.Handle("/debug/bptree/", http.StripPrefix("/debug/bptree", httpdebug.Handler(tr, &httpdebug.Options{Mutex: &mu})))
+//
+// Endpoints, all accepting GET:
+//
+//	/stats                  shape of tree as JSON
+//	/check                  result of invariant check, 500 on violation
+//	/keys?from=K&limit=N    entries with key >= K as JSON, paginated by next
+//	/find?key=K             value of key K as JSON, 404 if not found
+//	/print?key=K&depth=D    ASCII rendering of subtree at depth D on the path of K
+//	/dot?key=K&depth=D      the same subtree in Graphviz DOT format
+//
+// Access is read only unless Options.Writable is set, which enables
+// POST /insert?key=K&value=V and POST /delete?key=K.
+package httpdebug
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	bptree "github.com/kikimo/BPlusTree/v2"
+)
+
+const (
+	defaultLimit    = 100
+	maxLimit        = 10000
+	defaultMaxNodes = 64
+)
+
+type Options struct {
+	// Mutex guards the tree, read lock is taken for reads and write lock
+	// for writes. The tree must not be modified concurrently if nil.
+	Mutex *sync.RWMutex
+	// Writable enables insert and delete endpoints, inserted values are
+	// strings.
+	Writable bool
+	// MaxNodes limits number of nodes rendered by /print and /dot,
+	// 64 if 0.
+	MaxNodes int
+}
+
+type handler struct {
+	tr   *bptree.BPlusTree
+	opts Options
+	mux  *http.ServeMux
+}
+
+// Handler returns handler serving tr, opts may be nil.
+func Handler(tr *bptree.BPlusTree, opts *Options) http.Handler {
+	h := &handler{tr: tr, mux: http.NewServeMux()}
+	if opts != nil {
+		h.opts = *opts
+	}
+	if h.opts.MaxNodes == 0 {
+		h.opts.MaxNodes = defaultMaxNodes
+	}
+
+	h.mux.HandleFunc("/", h.index)
+	h.mux.HandleFunc("/stats", h.read(h.stats))
+	h.mux.HandleFunc("/check", h.read(h.check))
+	h.mux.HandleFunc("/keys", h.read(h.keys))
+	h.mux.HandleFunc("/find", h.read(h.find))
+	h.mux.HandleFunc("/print", h.read(h.print))
+	h.mux.HandleFunc("/dot", h.read(h.dot))
+	h.mux.HandleFunc("/insert", h.write(h.insert))
+	h.mux.HandleFunc("/delete", h.write(h.delete))
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// httpError is an error with a status code
+type httpError struct {
+	code int
+	err  error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &httpError{code: http.StatusBadRequest, err: fmt.Errorf(format, args...)}
+}
+
+type handlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+func (h *handler) read(fn handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if h.opts.Mutex != nil {
+			h.opts.Mutex.RLock()
+			defer h.opts.Mutex.RUnlock()
+		}
+		serve(w, r, fn)
+	}
+}
+
+func (h *handler) write(fn handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.opts.Writable {
+			http.Error(w, "tree is read only", http.StatusForbidden)
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if h.opts.Mutex != nil {
+			h.opts.Mutex.Lock()
+			defer h.opts.Mutex.Unlock()
+		}
+		serve(w, r, fn)
+	}
+}
+
+func serve(w http.ResponseWriter, r *http.Request, fn handlerFunc) {
+	err := fn(w, r)
+	if err == nil {
+		return
+	}
+
+	code := http.StatusInternalServerError
+	var herr *httpError
+	if errors.As(err, &herr) {
+		code = herr.code
+	} else if errors.Is(err, bptree.ErrKeyNotFound) {
+		code = http.StatusNotFound
+	} else if errors.Is(err, bptree.ErrDupKey) {
+		code = http.StatusConflict
+	}
+	http.Error(w, err.Error(), code)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(v)
+}
+
+func intParam(r *http.Request, name string, def int64) (int64, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, badRequest("illegal %s %q: %v", name, s, err)
+	}
+
+	return v, nil
+}
+
+func requiredIntParam(r *http.Request, name string) (int64, error) {
+	if r.URL.Query().Get(name) == "" {
+		return 0, badRequest("missing %s", name)
+	}
+
+	return intParam(r, name, 0)
+}
+
+const index = `<html><body>
+<p>tree debug endpoints:</p>
+<ul>
+<li><a href="stats">stats</a></li>
+<li><a href="check">check</a></li>
+<li><a href="keys">keys</a>?from=K&amp;limit=N</li>
+<li>find?key=K</li>
+<li><a href="print">print</a>?key=K&amp;depth=D</li>
+<li><a href="dot">dot</a>?key=K&amp;depth=D</li>
+</ul>
+</body></html>
+`
+
+func (h *handler) index(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, index)
+}
+
+func (h *handler) stats(w http.ResponseWriter, r *http.Request) error {
+	return writeJSON(w, h.tr.Stats())
+}
+
+func (h *handler) check(w http.ResponseWriter, r *http.Request) error {
+	if err := h.tr.Check(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "ok")
+	return nil
+}
+
+type entry struct {
+	Key   int64  `json:"key"`
+	Value string `json:"value"`
+}
+
+func (h *handler) keys(w http.ResponseWriter, r *http.Request) error {
+	from, err := intParam(r, "from", -1<<63)
+	if err != nil {
+		return err
+	}
+
+	limit, err := intParam(r, "limit", defaultLimit)
+	if err != nil {
+		return err
+	}
+
+	if limit < 1 || limit > maxLimit {
+		return badRequest("limit should be in [1, %d]: %d", maxLimit, limit)
+	}
+
+	// one more entry is scanned to tell where the next page starts
+	page := struct {
+		Entries []entry `json:"entries"`
+		Next    *int64  `json:"next"`
+	}{Entries: []entry{}}
+	h.tr.Range(from, 1<<63-1, func(key int64, value interface{}) bool {
+		if int64(len(page.Entries)) == limit {
+			page.Next = &key
+			return false
+		}
+
+		page.Entries = append(page.Entries, entry{Key: key, Value: fmt.Sprint(value)})
+		return true
+	})
+
+	return writeJSON(w, page)
+}
+
+func (h *handler) find(w http.ResponseWriter, r *http.Request) error {
+	key, err := requiredIntParam(r, "key")
+	if err != nil {
+		return err
+	}
+
+	v, err := h.tr.Find(key)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, entry{Key: key, Value: fmt.Sprint(v)})
+}
+
+func (h *handler) subtree(r *http.Request) (*bptree.BPlusTree, error) {
+	key, err := intParam(r, "key", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	depth, err := intParam(r, "depth", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := h.tr.Subtree(key, int(depth), h.opts.MaxNodes)
+	if errors.Is(err, bptree.ErrSubtreeTooLarge) {
+		return nil, badRequest("subtree has more than %d nodes, try a larger depth", h.opts.MaxNodes)
+	} else if err != nil {
+		return nil, badRequest("%v", err)
+	}
+
+	return st, nil
+}
+
+func (h *handler) print(w http.ResponseWriter, r *http.Request) error {
+	st, err := h.subtree(r)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, st.ToString())
+	return nil
+}
+
+func (h *handler) dot(w http.ResponseWriter, r *http.Request) error {
+	st, err := h.subtree(r)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+	return st.WriteDOT(w, nil)
+}
+
+func (h *handler) insert(w http.ResponseWriter, r *http.Request) error {
+	key, err := requiredIntParam(r, "key")
+	if err != nil {
+		return err
+	}
+
+	if err := h.tr.Insert(bptree.NewEntry(key, r.URL.Query().Get("value"))); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "ok")
+	return nil
+}
+
+func (h *handler) delete(w http.ResponseWriter, r *http.Request) error {
+	key, err := requiredIntParam(r, "key")
+	if err != nil {
+		return err
+	}
+
+	if err := h.tr.Delete(key); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "ok")
+	return nil
+}
@@ -0,0 +1,155 @@
+package httpdebug
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	bptree "github.com/kikimo/BPlusTree/v2"
+)
+
+func newServer(t *testing.T, opts *Options) (*bptree.BPlusTree, *httptest.Server) {
+	tr, _ := bptree.NewTree(bptree.WithFanout(4))
+	for i := int64(0); i < 100; i++ {
+		tr.Insert(bptree.NewEntry(i*2, i))
+	}
+
+	srv := httptest.NewServer(Handler(tr, opts))
+	t.Cleanup(srv.Close)
+	return tr, srv
+}
+
+func get(t *testing.T, srv *httptest.Server, method, path string) (int, string) {
+	req, _ := http.NewRequest(method, srv.URL+path, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error requesting %s: %+v", path, err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestReadEndpoints(t *testing.T) {
+	tr, srv := newServer(t, nil)
+
+	code, body := get(t, srv, http.MethodGet, "/stats")
+	var st bptree.Stats
+	if code != http.StatusOK || json.Unmarshal([]byte(body), &st) != nil || st != tr.Stats() {
+		t.Fatalf("unexpected stats %d %s", code, body)
+	}
+
+	if code, body := get(t, srv, http.MethodGet, "/check"); code != http.StatusOK || body != "ok\n" {
+		t.Fatalf("unexpected check result %d %s", code, body)
+	}
+
+	if code, body := get(t, srv, http.MethodGet, "/find?key=10"); code != http.StatusOK || body != "{\"key\":10,\"value\":\"5\"}\n" {
+		t.Fatalf("unexpected find result %d %s", code, body)
+	}
+
+	if code, _ := get(t, srv, http.MethodGet, "/find?key=11"); code != http.StatusNotFound {
+		t.Fatalf("expect status %d but got %d", http.StatusNotFound, code)
+	}
+
+	if code, _ := get(t, srv, http.MethodGet, "/find?key=x"); code != http.StatusBadRequest {
+		t.Fatalf("expect status %d but got %d", http.StatusBadRequest, code)
+	}
+
+	if code, body := get(t, srv, http.MethodGet, "/print?key=10&depth=2"); code != http.StatusOK || !strings.Contains(body, "10") {
+		t.Fatalf("unexpected print result %d %s", code, body)
+	}
+
+	if code, body := get(t, srv, http.MethodGet, "/dot?key=10&depth=2"); code != http.StatusOK || !strings.HasPrefix(body, "digraph") {
+		t.Fatalf("unexpected dot result %d %s", code, body)
+	}
+
+	if code, _ := get(t, srv, http.MethodGet, "/print"); code != http.StatusBadRequest {
+		t.Fatalf("expect whole tree too large to print but got %d", code)
+	}
+
+	if code, _ := get(t, srv, http.MethodGet, "/nope"); code != http.StatusNotFound {
+		t.Fatalf("expect status %d but got %d", http.StatusNotFound, code)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	_, srv := newServer(t, nil)
+
+	var keys []int64
+	path := "/keys?limit=30"
+	for {
+		code, body := get(t, srv, http.MethodGet, path)
+		var page struct {
+			Entries []entry
+			Next    *int64
+		}
+		if code != http.StatusOK || json.Unmarshal([]byte(body), &page) != nil {
+			t.Fatalf("unexpected keys result %d %s", code, body)
+		}
+
+		for _, e := range page.Entries {
+			keys = append(keys, e.Key)
+		}
+		if page.Next == nil {
+			break
+		}
+		path = "/keys?limit=30&from=" + strconv.FormatInt(*page.Next, 10)
+	}
+
+	if len(keys) != 100 {
+		t.Fatalf("expect 100 keys but got %d", len(keys))
+	}
+	for i, key := range keys {
+		if key != int64(i*2) {
+			t.Fatalf("expect key %d at %d but got %d", i*2, i, key)
+		}
+	}
+
+	if code, _ := get(t, srv, http.MethodGet, "/keys?limit=0"); code != http.StatusBadRequest {
+		t.Fatalf("expect status %d but got %d", http.StatusBadRequest, code)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	_, srv := newServer(t, nil)
+	if code, _ := get(t, srv, http.MethodPost, "/insert?key=1&value=a"); code != http.StatusForbidden {
+		t.Fatalf("expect status %d but got %d", http.StatusForbidden, code)
+	}
+
+	if code, _ := get(t, srv, http.MethodPost, "/stats"); code != http.StatusMethodNotAllowed {
+		t.Fatalf("expect status %d but got %d", http.StatusMethodNotAllowed, code)
+	}
+}
+
+func TestWritable(t *testing.T) {
+	tr, srv := newServer(t, &Options{Writable: true, Mutex: &sync.RWMutex{}})
+	if code, _ := get(t, srv, http.MethodPost, "/insert?key=1&value=a"); code != http.StatusOK {
+		t.Fatalf("expect status %d but got %d", http.StatusOK, code)
+	}
+
+	if v, err := tr.Find(1); err != nil || v != "a" {
+		t.Fatalf("expect value a but got (%+v, %+v)", v, err)
+	}
+
+	if code, _ := get(t, srv, http.MethodPost, "/insert?key=1&value=b"); code != http.StatusConflict {
+		t.Fatalf("expect status %d but got %d", http.StatusConflict, code)
+	}
+
+	if code, _ := get(t, srv, http.MethodGet, "/delete?key=1"); code != http.StatusMethodNotAllowed {
+		t.Fatalf("expect status %d but got %d", http.StatusMethodNotAllowed, code)
+	}
+
+	if code, _ := get(t, srv, http.MethodPost, "/delete?key=1"); code != http.StatusOK {
+		t.Fatalf("expect status %d but got %d", http.StatusOK, code)
+	}
+
+	if code, _ := get(t, srv, http.MethodPost, "/delete?key=1"); code != http.StatusNotFound {
+		t.Fatalf("expect status %d but got %d", http.StatusNotFound, code)
+	}
+}
@@ -0,0 +1,134 @@
+package v2
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// MarshalBinary encodes tr as snapshot written by WriteTo
+func (tr *BPlusTree) MarshalBinary() ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	if _, err := tr.WriteTo(buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary replaces content of tr with snapshot in data
+func (tr *BPlusTree) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if _, err := tr.ReadFrom(r); err != nil {
+		return err
+	}
+
+	if r.Len() > 0 {
+		return fmt.Errorf("%d trailing bytes after snapshot", r.Len())
+	}
+
+	return nil
+}
+
+func (tr *BPlusTree) GobEncode() ([]byte, error) {
+	return tr.MarshalBinary()
+}
+
+func (tr *BPlusTree) GobDecode(data []byte) error {
+	return tr.UnmarshalBinary(data)
+}
+
+type jsonEntry struct {
+	Key   int64           `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+type jsonTree struct {
+	MaxSize int `json:"maxSize"`
+	// LeafCapacity is omitted when leaves hold maxSize-1 keys
+	LeafCapacity int         `json:"leafCapacity,omitempty"`
+	Entries      []jsonEntry `json:"entries"`
+}
+
+// MarshalJSON encodes tr as an object holding max size and entries not
+// expired of the tree sorted by key, e.g.:
+//
+//	{"maxSize":4,"entries":[{"key":1,"value":"foo"},{"key":2,"value":"bar"}]}
+//
+// max size is the internal capacity, leaf capacity is encoded as well
+// if it is not one less than max size.
+func (tr *BPlusTree) MarshalJSON() ([]byte, error) {
+	jt := jsonTree{
+		MaxSize: tr.cfg.innerCap,
+		Entries: make([]jsonEntry, 0, tr.size),
+	}
+
+	if tr.cfg.leafCap != tr.cfg.innerCap-1 {
+		jt.LeafCapacity = tr.cfg.leafCap
+	}
+
+	var err error
+	tr.ascend(func(key int64, value interface{}) bool {
+		var v []byte
+		if v, err = json.Marshal(value); err != nil {
+			err = fmt.Errorf("error encoding value of key %d: %w", key, err)
+			return false
+		}
+
+		jt.Entries = append(jt.Entries, jsonEntry{Key: key, Value: v})
+		return true
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(&jt)
+}
+
+// UnmarshalJSON replaces content of tr with entries encoded by
+// MarshalJSON, values are decoded into the generic json types.
+func (tr *BPlusTree) UnmarshalJSON(data []byte) error {
+	jt := jsonTree{}
+	if err := json.Unmarshal(data, &jt); err != nil {
+		return err
+	}
+
+	if jt.MaxSize < 3 {
+		return fmt.Errorf("BPlusTree maxSize should be at least 3: %d", jt.MaxSize)
+	}
+
+	if jt.LeafCapacity == 0 {
+		jt.LeafCapacity = jt.MaxSize - 1
+	} else if jt.LeafCapacity < 2 {
+		return fmt.Errorf("BPlusTree leafCapacity should be at least 2: %d", jt.LeafCapacity)
+	}
+
+	cfg := tr.loadConfig(jt.LeafCapacity, jt.MaxSize)
+	sort.SliceStable(jt.Entries, func(i, j int) bool {
+		return cfg.compare(jt.Entries[i].Key, jt.Entries[j].Key) < 0
+	})
+
+	bl := newBulkLoader(cfg)
+	for i, e := range jt.Entries {
+		if i > 0 && cfg.compare(e.Key, jt.Entries[i-1].Key) == 0 {
+			return fmt.Errorf("%w: %d", ErrDupKey, e.Key)
+		}
+
+		var v interface{}
+		if len(e.Value) == 0 {
+			// missing value
+		} else if err := json.Unmarshal(e.Value, &v); err != nil {
+			return fmt.Errorf("error decoding value of key %d: %w", e.Key, err)
+		}
+
+		if err := bl.add(e.Key, v); err != nil {
+			return err
+		}
+	}
+
+	ntr := bl.build()
+	tr.cfg, tr.root, tr.size, tr.ttl = ntr.cfg, ntr.root, ntr.size, nil
+	return nil
+}
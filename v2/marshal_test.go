@@ -0,0 +1,129 @@
+package v2
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+type kv struct {
+	key   int64
+	value interface{}
+}
+
+func entries(tr *BPlusTree) []kv {
+	kvs := []kv{}
+	tr.Range(minKey, maxKey, func(key int64, value interface{}) bool {
+		kvs = append(kvs, kv{key, value})
+		return true
+	})
+
+	return kvs
+}
+
+func randomTree(t *testing.T, r *rand.Rand) *BPlusTree {
+	tr, _ := NewTree(WithFanout(3 + r.Intn(8)))
+	numKeys := r.Intn(200)
+	for i := 0; i < numKeys; i++ {
+		key := r.Int63n(1000) - 500
+		tr.Insert(&Entry{key: key, pointer: int(key)})
+	}
+
+	// delete some keys to exercise merge and borrow
+	for i := 0; i < numKeys/4; i++ {
+		tr.Delete(r.Int63n(1000) - 500)
+	}
+
+	return tr
+}
+
+type wrapper struct {
+	Name string
+	Tree *BPlusTree
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		tr := randomTree(t, r)
+		want := entries(tr)
+
+		data, err := tr.MarshalBinary()
+		if err != nil {
+			t.Fatalf("error marshaling tree: %+v", err)
+		}
+
+		btr := &BPlusTree{}
+		if err := btr.UnmarshalBinary(data); err != nil {
+			t.Fatalf("error unmarshaling tree: %+v", err)
+		}
+
+		if got := entries(btr); btr.cfg.innerCap != tr.cfg.innerCap || !reflect.DeepEqual(want, got) {
+			t.Fatalf("expect entries %+v but got %+v", want, got)
+		}
+
+		if err := checkBPlusTreeInvariant(btr); err != nil {
+			t.Fatalf("b tree invariant check failed: %+v", err)
+		}
+
+		buf := bytes.NewBuffer(nil)
+		if err := gob.NewEncoder(buf).Encode(&wrapper{Name: "gob", Tree: tr}); err != nil {
+			t.Fatalf("error encoding tree with gob: %+v", err)
+		}
+
+		gw := wrapper{}
+		if err := gob.NewDecoder(buf).Decode(&gw); err != nil {
+			t.Fatalf("error decoding tree with gob: %+v", err)
+		}
+
+		if got := entries(gw.Tree); gw.Name != "gob" || gw.Tree.cfg.innerCap != tr.cfg.innerCap || !reflect.DeepEqual(want, got) {
+			t.Fatalf("expect entries %+v but got %+v", want, got)
+		}
+
+		data, err = json.Marshal(&wrapper{Name: "json", Tree: tr})
+		if err != nil {
+			t.Fatalf("error encoding tree with json: %+v", err)
+		}
+
+		jw := wrapper{}
+		if err := json.Unmarshal(data, &jw); err != nil {
+			t.Fatalf("error decoding tree with json: %+v", err)
+		}
+
+		// json decodes numbers as float64
+		for i := range want {
+			want[i].value = float64(want[i].value.(int))
+		}
+
+		if got := entries(jw.Tree); jw.Name != "json" || jw.Tree.cfg.innerCap != tr.cfg.innerCap || !reflect.DeepEqual(want, got) {
+			t.Fatalf("expect entries %+v but got %+v", want, got)
+		}
+
+		if err := checkBPlusTreeInvariant(jw.Tree); err != nil {
+			t.Fatalf("b tree invariant check failed: %+v", err)
+		}
+	}
+}
+
+func TestMarshalJSONFormat(t *testing.T) {
+	tr, _ := NewTree(WithFanout(4))
+	tr.Insert(&Entry{key: 2, pointer: "bar"})
+	tr.Insert(&Entry{key: 1, pointer: "foo"})
+
+	data, err := json.Marshal(tr)
+	if err != nil {
+		t.Fatalf("error encoding tree with json: %+v", err)
+	}
+
+	want := `{"maxSize":4,"entries":[{"key":1,"value":"foo"},{"key":2,"value":"bar"}]}`
+	if string(data) != want {
+		t.Fatalf("expect %s but got %s", want, data)
+	}
+
+	if err := json.Unmarshal([]byte(`{"maxSize":4,"entries":[{"key":1},{"key":1}]}`), tr); err == nil {
+		t.Fatalf("expect duplicate key error but got none")
+	}
+}
@@ -0,0 +1,140 @@
+package metrics
+
+import (
+	"expvar"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBuckets are upper bounds of latency histogram buckets
+var DefaultBuckets = []time.Duration{
+	100 * time.Nanosecond,
+	250 * time.Nanosecond,
+	500 * time.Nanosecond,
+	time.Microsecond,
+	2500 * time.Nanosecond,
+	5 * time.Microsecond,
+	10 * time.Microsecond,
+	25 * time.Microsecond,
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+}
+
+// ExpvarSink publishes metrics as an expvar.Map:
+//
+//	{
+//		"ops": {"find": {"ok": 10, "not_found": 2, ...}, ...},
+//		"latency": {"find": {"buckets": {"100ns": 3, ..., "+Inf": 12}, "count": 12, "sum_ns": 4200}, ...},
+//		"len": 100, "height": 3, "fill_factor": 0.71
+//	}
+//
+// histogram buckets are cumulative as in Prometheus.
+type ExpvarSink struct {
+	m       *expvar.Map
+	ops     [len(opNames)][len(resultNames)]*expvar.Int
+	latency [len(opNames)]*Histogram
+	len     *expvar.Int
+	height  *expvar.Int
+	fill    *expvar.Float
+}
+
+// NewExpvarSink publishes metrics under name, it panics if name is
+// already published as expvar.Publish does.
+func NewExpvarSink(name string) *ExpvarSink {
+	s := newExpvarSink()
+	expvar.Publish(name, s.m)
+	return s
+}
+
+func newExpvarSink() *ExpvarSink {
+	s := &ExpvarSink{
+		m:      new(expvar.Map).Init(),
+		len:    new(expvar.Int),
+		height: new(expvar.Int),
+		fill:   new(expvar.Float),
+	}
+
+	ops := new(expvar.Map).Init()
+	latency := new(expvar.Map).Init()
+	for i, op := range opNames {
+		results := new(expvar.Map).Init()
+		for j, res := range resultNames {
+			s.ops[i][j] = new(expvar.Int)
+			results.Set(res, s.ops[i][j])
+		}
+		ops.Set(op, results)
+
+		s.latency[i] = NewHistogram(DefaultBuckets)
+		latency.Set(op, s.latency[i])
+	}
+
+	s.m.Set("ops", ops)
+	s.m.Set("latency", latency)
+	s.m.Set("len", s.len)
+	s.m.Set("height", s.height)
+	s.m.Set("fill_factor", s.fill)
+	return s
+}
+
+func (s *ExpvarSink) ObserveOp(op Op, res Result, d time.Duration) {
+	s.ops[op][res].Add(1)
+	s.latency[op].Observe(d)
+}
+
+func (s *ExpvarSink) SetShape(sh Shape) {
+	s.len.Set(int64(sh.Len))
+	s.height.Set(int64(sh.Height))
+	s.fill.Set(sh.FillFactor)
+}
+
+// Histogram counts durations into buckets, it is safe for concurrent
+// use and implements expvar.Var.
+type Histogram struct {
+	bounds []time.Duration
+	// counts[i] counts durations in (bounds[i-1], bounds[i]], the last
+	// one counts those above all bounds
+	counts []int64
+	sum    int64
+}
+
+// NewHistogram returns histogram with bucket upper bounds in ascending
+// order
+func NewHistogram(bounds []time.Duration) *Histogram {
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]int64, len(bounds)+1),
+	}
+}
+
+func (h *Histogram) Observe(d time.Duration) {
+	i := 0
+	for i < len(h.bounds) && d > h.bounds[i] {
+		i++
+	}
+
+	atomic.AddInt64(&h.counts[i], 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+func (h *Histogram) String() string {
+	buf := strings.Builder{}
+	buf.WriteString(`{"buckets": {`)
+	n := int64(0)
+	for i := range h.counts {
+		n += atomic.LoadInt64(&h.counts[i])
+		bound := "+Inf"
+		if i < len(h.bounds) {
+			bound = h.bounds[i].String()
+		}
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		fmt.Fprintf(&buf, "%q: %d", bound, n)
+	}
+	fmt.Fprintf(&buf, `}, "count": %d, "sum_ns": %d}`, n, atomic.LoadInt64(&h.sum))
+
+	return buf.String()
+}
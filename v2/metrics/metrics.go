@@ -0,0 +1,209 @@
+// Package metrics exports operation and shape metrics of a v2 tree.
+//
+// Tree wraps a tree and reports every Find, Insert and Delete to a Sink.
+// ExpvarSink publishes metrics via expvar, other monitoring systems are
+// plugged in by implementing Sink, e.g. for Prometheus:
+//
+//	type promSink struct {
+//		ops     *prometheus.CounterVec   // labels: op, result
+//		latency *prometheus.HistogramVec // labels: op
+//		len     prometheus.Gauge
+//		height  prometheus.Gauge
+//		fill    prometheus.Gauge
+//	}
+//
+//	func (s *promSink) ObserveOp(op metrics.Op, res metrics.Result, d time.Duration) {
+//		s.ops.WithLabelValues(op.String(), res.String()).Inc()
+//		s.latency.WithLabelValues(op.String()).Observe(d.Seconds())
+//	}
+//
+//	func (s *promSink) SetShape(sh metrics.Shape) {
+//		s.len.Set(float64(sh.Len))
+//		s.height.Set(float64(sh.Height))
+//		s.fill.Set(sh.FillFactor)
+//	}
+package metrics
+
+import (
+	"errors"
+	"time"
+
+	bptree "github.com/kikimo/BPlusTree/v2"
+)
+
+type Op int
+
+const (
+	OpFind Op = iota
+	OpInsert
+	OpDelete
+)
+
+var opNames = [...]string{"find", "insert", "delete"}
+
+func (op Op) String() string {
+	return opNames[op]
+}
+
+// Result is outcome of an operation
+type Result int
+
+const (
+	ResultOK Result = iota
+	ResultNotFound
+	ResultDupKey
+	ResultError
+)
+
+var resultNames = [...]string{"ok", "not_found", "dup_key", "error"}
+
+func (r Result) String() string {
+	return resultNames[r]
+}
+
+func resultOf(err error) Result {
+	switch {
+	case err == nil:
+		return ResultOK
+	case errors.Is(err, bptree.ErrKeyNotFound):
+		return ResultNotFound
+	case errors.Is(err, bptree.ErrDupKey):
+		return ResultDupKey
+	}
+
+	return ResultError
+}
+
+// Shape describes size of a tree, see also bptree.Stats
+type Shape struct {
+	Len        int
+	Height     int
+	FillFactor float64
+}
+
+// Sink receives metrics of a tree
+type Sink interface {
+	// ObserveOp is called after every operation with its latency
+	ObserveOp(op Op, res Result, d time.Duration)
+	// SetShape is called with current shape of tree when the tree is
+	// wrapped and after every successful mutation
+	SetShape(sh Shape)
+}
+
+// Tree is a tree reporting its operations to a sink. Just like the
+// wrapped tree it is not safe for concurrent use.
+type Tree struct {
+	tr   *bptree.BPlusTree
+	sink Sink
+
+	// shape is maintained by observing structural changes so that it
+	// costs nothing to report it after every mutation
+	leafCap       int
+	innerCap      int
+	height        int
+	leafNodes     int
+	internalNodes int
+}
+
+// New wraps tr, tr is observed by the returned tree and any observer
+// of tr is replaced. tr should not be modified other than through the
+// returned tree.
+func New(tr *bptree.BPlusTree, sink Sink) *Tree {
+	st := tr.Stats()
+	t := &Tree{
+		tr:            tr,
+		sink:          sink,
+		leafCap:       st.LeafCapacity,
+		innerCap:      st.InternalCapacity,
+		height:        st.Height,
+		leafNodes:     st.LeafNodes,
+		internalNodes: st.InternalNodes,
+	}
+
+	tr.SetObserver(shapeObserver{t})
+	sink.SetShape(t.Shape())
+	return t
+}
+
+// Tree returns the wrapped tree
+func (t *Tree) Tree() *bptree.BPlusTree {
+	return t.tr
+}
+
+func (t *Tree) Len() int {
+	return t.tr.Len()
+}
+
+// Shape returns current shape of tree
+func (t *Tree) Shape() Shape {
+	// every node but root takes one child slot of its parent
+	used := t.tr.Len() + t.leafNodes + t.internalNodes - 1
+	slots := t.leafNodes*t.leafCap + t.internalNodes*t.innerCap
+	return Shape{
+		Len:        t.tr.Len(),
+		Height:     t.height,
+		FillFactor: float64(used) / float64(slots),
+	}
+}
+
+func (t *Tree) Find(key int64) (interface{}, error) {
+	start := time.Now()
+	v, err := t.tr.Find(key)
+	t.sink.ObserveOp(OpFind, resultOf(err), time.Since(start))
+
+	return v, err
+}
+
+func (t *Tree) Insert(e *bptree.Entry) error {
+	start := time.Now()
+	err := t.tr.Insert(e)
+	t.sink.ObserveOp(OpInsert, resultOf(err), time.Since(start))
+	if err == nil {
+		t.sink.SetShape(t.Shape())
+	}
+
+	return err
+}
+
+func (t *Tree) Delete(key int64) error {
+	start := time.Now()
+	err := t.tr.Delete(key)
+	t.sink.ObserveOp(OpDelete, resultOf(err), time.Since(start))
+	if err == nil {
+		t.sink.SetShape(t.Shape())
+	}
+
+	return err
+}
+
+type shapeObserver struct {
+	t *Tree
+}
+
+func (o shapeObserver) OnLeafSplit(left, right []int64) {
+	o.t.leafNodes++
+}
+
+func (o shapeObserver) OnInternalSplit(left []int64, sep int64, right []int64) {
+	o.t.internalNodes++
+}
+
+func (o shapeObserver) OnMerge(leaf bool, merged []int64) {
+	if leaf {
+		o.t.leafNodes--
+	} else {
+		o.t.internalNodes--
+	}
+}
+
+func (o shapeObserver) OnBorrow(dir bptree.BorrowDir, leaf bool, left, right []int64) {}
+
+func (o shapeObserver) OnRootGrow(root []int64) {
+	o.t.height++
+	o.t.internalNodes++
+}
+
+func (o shapeObserver) OnRootShrink(root []int64) {
+	o.t.height--
+	o.t.internalNodes--
+}
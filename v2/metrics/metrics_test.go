@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	bptree "github.com/kikimo/BPlusTree/v2"
+)
+
+type testSink struct {
+	ops   map[Op]map[Result]int
+	shape Shape
+}
+
+func (s *testSink) ObserveOp(op Op, res Result, d time.Duration) {
+	if s.ops[op] == nil {
+		s.ops[op] = map[Result]int{}
+	}
+	s.ops[op][res]++
+}
+
+func (s *testSink) SetShape(sh Shape) {
+	s.shape = sh
+}
+
+func TestShape(t *testing.T) {
+	for _, maxSize := range []int{3, 4, 9} {
+		tr, _ := bptree.NewTree(bptree.WithFanout(maxSize))
+		for i := int64(0); i < 50; i++ {
+			tr.Insert(bptree.NewEntry(i, i))
+		}
+
+		sink := &testSink{ops: map[Op]map[Result]int{}}
+		mt := New(tr, sink)
+		r := rand.New(rand.NewSource(int64(maxSize)))
+		for i := 0; i < 2000; i++ {
+			key := r.Int63n(200)
+			if r.Intn(2) == 0 {
+				mt.Delete(key)
+			} else {
+				mt.Insert(bptree.NewEntry(key, key))
+			}
+
+			st := tr.Stats()
+			sh := sink.shape
+			if sh.Len != st.Len || sh.Height != st.Height || math.Abs(sh.FillFactor-st.FillFactor) > 1e-9 {
+				t.Fatalf("step %d, expect shape %+v but got %+v", i, st, sh)
+			}
+		}
+	}
+}
+
+func TestOpResults(t *testing.T) {
+	tr, _ := bptree.NewTree(bptree.WithFanout(4))
+	sink := &testSink{ops: map[Op]map[Result]int{}}
+	mt := New(tr, sink)
+
+	mt.Insert(bptree.NewEntry(1, 1))
+	mt.Insert(bptree.NewEntry(1, 1))
+	mt.Find(1)
+	mt.Find(2)
+	mt.Delete(2)
+	mt.Delete(1)
+
+	wops := map[Op]map[Result]int{
+		OpInsert: {ResultOK: 1, ResultDupKey: 1},
+		OpFind:   {ResultOK: 1, ResultNotFound: 1},
+		OpDelete: {ResultOK: 1, ResultNotFound: 1},
+	}
+	for op, results := range wops {
+		for res, n := range results {
+			if sink.ops[op][res] != n {
+				t.Fatalf("expect %d %s of %s but got %d", n, res, op, sink.ops[op][res])
+			}
+		}
+	}
+
+	if sink.shape.Len != 0 || mt.Len() != 0 {
+		t.Fatalf("expect empty tree but got shape %+v", sink.shape)
+	}
+}
+
+func TestExpvarSink(t *testing.T) {
+	tr, _ := bptree.NewTree(bptree.WithFanout(4))
+	sink := newExpvarSink()
+	mt := New(tr, sink)
+	for i := int64(0); i < 100; i++ {
+		mt.Insert(bptree.NewEntry(i, i))
+	}
+	mt.Find(1000)
+
+	var got struct {
+		Ops     map[string]map[string]int64
+		Latency map[string]struct {
+			Buckets map[string]int64
+			Count   int64
+		}
+		Len        int
+		Height     int
+		FillFactor float64 `json:"fill_factor"`
+	}
+	if err := json.Unmarshal([]byte(sink.m.String()), &got); err != nil {
+		t.Fatalf("error decoding %s: %+v", sink.m.String(), err)
+	}
+
+	if got.Ops["insert"]["ok"] != 100 || got.Ops["find"]["not_found"] != 1 {
+		t.Fatalf("unexpected op counts: %+v", got.Ops)
+	}
+
+	if l := got.Latency["insert"]; l.Count != 100 || l.Buckets["+Inf"] != 100 {
+		t.Fatalf("unexpected insert latency: %+v", l)
+	}
+
+	st := tr.Stats()
+	if got.Len != 100 || got.Height != st.Height || got.FillFactor != st.FillFactor {
+		t.Fatalf("expect shape %+v but got %+v", st, got)
+	}
+
+	NewExpvarSink("bptree_test")
+	if expvar.Get("bptree_test") == nil {
+		t.Fatalf("expect metrics published")
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram([]time.Duration{time.Microsecond, time.Millisecond})
+	for _, d := range []time.Duration{time.Nanosecond, time.Microsecond, 2 * time.Microsecond, time.Second} {
+		h.Observe(d)
+	}
+
+	want := `{"buckets": {"1µs": 2, "1ms": 3, "+Inf": 4}, "count": 4, "sum_ns": 1000003001}`
+	if h.String() != want {
+		t.Fatalf("expect %s but got %s", want, h.String())
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package v2
+
+import (
+	"io"
+	"os"
+)
+
+// mmapFile falls back to reading the whole file on platforms without mmap
+func mmapFile(f *os.File) ([]byte, error) {
+	return io.ReadAll(f)
+}
+
+func munmapFile(data []byte) error {
+	return nil
+}
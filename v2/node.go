@@ -9,6 +9,10 @@ import (
 var ErrDupKey error = fmt.Errorf("duplicate key")
 var ErrKeyNotFound error = fmt.Errorf("key not found")
 
+// tNode keeps keys and pointers of its entries in two arrays of the
+// same length, so that searching a node only scans contiguous keys. The
+// last pointer of a leaf links to its sibling, keys[0] of an internal
+// node is unused.
 type tNode struct {
 	isLeaf bool
 	// parent points to parent pointer. When should parent pointer
@@ -16,8 +20,17 @@ type tNode struct {
 	// 	1. update children's parent pointers when an entry is being
 	//     merged or splited
 	// 	2. update parent pointer when an entry is being inserted
-	parent  *tNode
-	entries []Entry
+	parent *tNode
+	cfg    *config
+	keys   []int64
+	ptrs   []interface{}
+	// summary caches aggregate of entries of the subtree, it is valid
+	// only if summarized, see Aggregate
+	summary    interface{}
+	summarized bool
+	// appended is set for nodes started almost empty by an append
+	// split, they may have too few pointers while on the right spine
+	appended bool
 }
 
 type Entry struct {
@@ -25,26 +38,103 @@ type Entry struct {
 	pointer interface{}
 }
 
-func newTNode(isLeaf bool, maxSize int) *tNode {
-	n := &tNode{
-		isLeaf:  isLeaf,
-		entries: make([]Entry, 0, maxSize+1),
+// NewEntry returns entry of key with value p to be inserted into tree
+func NewEntry(key int64, p interface{}) *Entry {
+	return &Entry{key: key, pointer: p}
+}
+
+func (e *Entry) Key() int64 {
+	return e.key
+}
+
+func (e *Entry) Value() interface{} {
+	return e.pointer
+}
+
+// size returns number of entries of tn
+func (tn *tNode) size() int {
+	return len(tn.keys)
+}
+
+// resize sets number of entries of tn to n, which is within capacity
+func (tn *tNode) resize(n int) {
+	tn.keys = tn.keys[:n]
+	tn.ptrs = tn.ptrs[:n]
+	if tn.summarized {
+		tn.unsummarize()
 	}
+}
 
-	if isLeaf {
-		n.entries = n.entries[:1]
+// overflowed tells whether tn holds one entry more than allowed and
+// should be split
+func (tn *tNode) overflowed() bool {
+	return len(tn.keys) == cap(tn.keys)
+}
+
+// child returns child at pos of internal node tn
+func (tn *tNode) child(pos int) *tNode {
+	return tn.ptrs[pos].(*tNode)
+}
+
+// next returns right sibling of leaf tn, nil for the rightmost leaf
+func (tn *tNode) next() *tNode {
+	sibling, _ := tn.ptrs[len(tn.ptrs)-1].(*tNode)
+	return sibling
+}
+
+// prev returns left sibling of leaf tn, nil for the leftmost leaf.
+// Leaves only link to their right sibling, so it goes up to the nearest
+// ancestor with a child left of the path and down its rightmost path.
+func (tn *tNode) prev() *tNode {
+	for tn.parent != nil {
+		p := tn.parent
+		if i := p.childIndex(tn); i > 0 {
+			tn = p.child(i - 1)
+			for !tn.isLeaf {
+				tn = tn.child(len(tn.ptrs) - 1)
+			}
+			return tn
+		}
+		tn = p
 	}
 
-	return n
+	return nil
+}
+
+func (tn *tNode) entry(pos int) Entry {
+	return Entry{key: tn.keys[pos], pointer: tn.ptrs[pos]}
+}
+
+func (tn *tNode) setEntry(pos int, e Entry) {
+	tn.keys[pos], tn.ptrs[pos] = e.key, e.pointer
+}
+
+// copyEntries copies entries [from, to) of src into dst from pos on,
+// src and dst may be the same node like slices of copy
+func copyEntries(dst *tNode, pos int, src *tNode, from, to int) {
+	copy(dst.keys[pos:], src.keys[from:to])
+	copy(dst.ptrs[pos:], src.ptrs[from:to])
 }
 
-// findInsertPos find smallest index such that tn.entries[index].key >= key
+// findInsertPos find smallest index such that tn.keys[index] >= key
 func (tn *tNode) findInsertPos(key int64, s, e int) int {
+	switch tn.cfg.search {
+	case SearchLinear:
+		for s < e && tn.cfg.compare(tn.keys[s], key) < 0 {
+			s++
+		}
+		return s
+	case SearchBranchless:
+		if tn.cfg.cmp == nil {
+			return s + lowerBound(tn.keys[s:e], key)
+		}
+	}
+
 	for s < e {
 		m := (s + e) / 2
-		if tn.entries[m].key >= key {
+		if tn.cfg.compare(tn.keys[m], key) >= 0 {
 			e = m
-		} else { // tn.entries[m].key < key
+		} else { // tn.keys[m] < key
 			s = m + 1
 		}
 	}
@@ -52,34 +142,82 @@ func (tn *tNode) findInsertPos(key int64, s, e int) int {
 	return s
 }
 
-// findInsertPos find smallest index such that tn.entries[index].key >= key
+// lowerBound returns smallest index i such that keys[i] >= key in
+// natural order. The search range shrinks by the same amount whatever
+// the comparison tells, which the compiler turns into a conditional
+// move instead of a branch.
+func lowerBound(keys []int64, key int64) int {
+	n := len(keys)
+	if n == 0 {
+		return 0
+	}
+
+	base := 0
+	for n > 1 {
+		half := n / 2
+		if keys[base+half] < key {
+			base += half
+		}
+		n -= half
+	}
+
+	if keys[base] < key {
+		base++
+	}
+
+	return base
+}
+
+// findInsertPos find smallest index such that tn.keys[index] >= key
 func (tn *tNode) findLeafInsertPos(key int64) int {
-	return tn.findInsertPos(key, 0, len(tn.entries)-1)
+	return tn.findInsertPos(key, 0, len(tn.keys)-1)
 }
 
-// findInsertPos find smallest index such that tn.entries[index].key >= key
+// findInsertPos find smallest index such that tn.keys[index] >= key
 func (tn *tNode) findInternalInsertPos(key int64) int {
-	return tn.findInsertPos(key, 1, len(tn.entries))
+	return tn.findInsertPos(key, 1, len(tn.keys))
+}
+
+// findChildPos returns index of child entry in which key resides
+func (tn *tNode) findChildPos(key int64) int {
+	pos := tn.findInternalInsertPos(key)
+	if pos >= len(tn.keys) || tn.cfg.compare(tn.keys[pos], key) > 0 {
+		pos -= 1
+	}
+
+	return pos
+}
+
+// childIndex returns index of entry of tn pointing to child
+func (tn *tNode) childIndex(child *tNode) int {
+	for i := range tn.ptrs {
+		if tn.ptrs[i] == child {
+			return i
+		}
+	}
+
+	glog.Fatalf("%s is not a child of %s", child.ChildrenStr(), tn.ChildrenStr())
+	panic("unreachable")
 }
 
 func (tn *tNode) insertAt(pos int, e *Entry) {
-	// expand tn.entries by one
-	sz := len(tn.entries)
-	tn.entries = tn.entries[:sz+1]
-	copy(tn.entries[pos+1:], tn.entries[pos:sz])
-	tn.entries[pos] = *e
+	// expand tn by one
+	sz := len(tn.keys)
+	tn.resize(sz + 1)
+	copyEntries(tn, pos+1, tn, pos, sz)
+	tn.setEntry(pos, *e)
 }
 
 func (tn *tNode) insertLeaf(e *Entry) error {
-	sz := len(tn.entries)
+	sz := len(tn.keys)
 
 	// check invariant
-	if sz+1 > cap(tn.entries) {
-		glog.Fatalf("leaf entry overflow(maxsize: %d) inserting new entry: %+v", cap(tn.entries), e)
+	if sz+1 > cap(tn.keys) {
+		glog.Fatalf("leaf entry overflow(maxsize: %d) inserting new entry: %+v", cap(tn.keys), e)
 	}
 
 	pos := tn.findLeafInsertPos(e.key)
-	if pos < sz-1 && tn.entries[pos].key == e.key {
+	if pos < sz-1 && tn.cfg.compare(tn.keys[pos], e.key) == 0 {
 		return ErrDupKey
 	}
 
@@ -88,51 +226,100 @@ func (tn *tNode) insertLeaf(e *Entry) error {
 }
 
 // split nodes
-func (tn *tNode) splitInternalNode() *Entry {
+func (tn *tNode) splitInternalNode() Entry {
 	// 4 -> 2
 	// 5 -> 2
-	sz := len(tn.entries)
-	pos := (sz + 1) / 2
-	newN := newTNode(false, sz-1)
+	return tn.splitInternalNodeAt((len(tn.keys) + 1) / 2)
+}
+
+// splitInternalNodeAt moves children from pos on to a new node
+func (tn *tNode) splitInternalNodeAt(pos int) Entry {
+	newN := tn.cfg.newNode(false)
 	newN.parent = tn.parent
-	// glog.Infof("pos: %d, e: %+v, entries: %+v", pos, tn.entries[pos], tn.entries)
 
 	// split pointers
-	newN.entries = newN.entries[:len(tn.entries[pos:])]
-	copy(newN.entries, tn.entries[pos:])
-	tn.entries = tn.entries[:pos]
+	sz := len(tn.keys)
+	newN.resize(sz - pos)
+	copyEntries(newN, 0, tn, pos, sz)
+	tn.resize(pos)
 
 	// adjust parent for splited children
-	for _, p := range newN.entries {
-		child := p.pointer.(*tNode)
+	for _, p := range newN.ptrs {
+		child := p.(*tNode)
 		child.parent = newN
 	}
 
 	// insert newEntry into parent
-	ne := &Entry{key: newN.entries[0].key, pointer: newN}
-	newN.entries[0].key = 0
+	ne := Entry{key: newN.keys[0], pointer: newN}
+	newN.keys[0] = 0
 	return ne
 }
 
-func (tn *tNode) splitLeafNode() *Entry {
-	glog.V(2).Infof("spliting leaf node: %s", tn.ChildrenStr())
-	sz := len(tn.entries)
+// splitThree splits full siblings left and right, one of which has
+// overflowed, into three nodes of about the same size. key points to
+// the key of right in parent and is updated accordingly, entry of the
+// new middle node is returned and should be inserted before right.
+func splitThree(left *tNode, key *int64, right *tNode) Entry {
+	count := func(tn *tNode) int {
+		if tn.isLeaf {
+			// the last entry of leaf points to sibling
+			return len(tn.keys) - 1
+		}
+		return len(tn.keys)
+	}
+
+	ln, rn := count(left), count(right)
+	n := ln + rn
+	a, b := n/3, (n+1)/3
+	mid := left.cfg.newNode(left.isLeaf)
+	mid.parent = left.parent
+	if left.isLeaf {
+		mid.setEntry(0, Entry{pointer: right})
+		left.setEntry(ln, Entry{pointer: mid})
+	}
+
+	// the overflowed node gives entries to mid first so that mid is not
+	// empty when the other one shares entries with it
+	sep := *key
+	if ln > rn {
+		share(left, &sep, mid, a)
+		share(mid, key, right, b)
+	} else {
+		share(mid, key, right, rn-(n-a-b))
+		share(left, &sep, mid, a)
+	}
+
+	return Entry{key: sep, pointer: mid}
+}
+
+func (tn *tNode) splitLeafNode() Entry {
 	// 4 -> 2
 	// 5 -> 2
-	pos := sz / 2
+	return tn.splitLeafNodeAt(len(tn.keys) / 2)
+}
+
+// splitLeafNodeAt moves keys from pos on to a new leaf
+func (tn *tNode) splitLeafNodeAt(pos int) Entry {
+	if tn.cfg.debug() {
+		tn.cfg.log.Debugf("spliting leaf node: %s", tn.ChildrenStr())
+	}
 
-	newN := newTNode(true, sz-1)
+	newN := tn.cfg.newNode(true)
 	newN.parent = tn.parent
-	newN.entries = newN.entries[:len(tn.entries[pos:])]
-	copy(newN.entries, tn.entries[pos:])
+	sz := len(tn.keys)
+	newN.resize(sz - pos)
+	copyEntries(newN, 0, tn, pos, sz)
 
 	// leave one extra space to connect to sibling
-	tn.entries = tn.entries[:pos+1]
+	tn.resize(pos + 1)
 	// connect to sibling
-	tn.entries[pos] = Entry{pointer: newN}
+	tn.setEntry(pos, Entry{pointer: newN})
 
-	glog.V(2).Infof("new entry after split leaf: %s", newN.ChildrenStr())
-	return &Entry{key: newN.entries[0].key, pointer: newN}
+	if tn.cfg.debug() {
+		tn.cfg.log.Debugf("new entry after split leaf: %s", newN.ChildrenStr())
+	}
+
+	return Entry{key: newN.keys[0], pointer: newN}
 }
 
 // merge nodes
@@ -148,45 +335,45 @@ func (tn *tNode) mergeNodes(key int64, right *tNode) bool {
 }
 
 func (tn *tNode) mergeLeaves(right *tNode) bool {
-	sz := len(tn.entries) + len(right.entries) - 1
+	sz := len(tn.keys) + len(right.keys) - 1
 
 	// unable to merge
-	if sz > cap(tn.entries)-1 {
+	if sz > tn.cfg.maxEntries(true) {
 		return false
 	}
 
-	glog.V(2).Infof("merge internal node, left: %s, right: %s", tn.ChildrenStr(), right.ChildrenStr())
-	start := len(tn.entries) - 1
-	tn.entries = tn.entries[:sz]
-	for i := range right.entries {
-		tn.entries[start+i] = right.entries[i]
+	if tn.cfg.debug() {
+		tn.cfg.log.Debugf("merge internal node, left: %s, right: %s", tn.ChildrenStr(), right.ChildrenStr())
 	}
+	start := len(tn.keys) - 1
+	tn.resize(sz)
+	copyEntries(tn, start, right, 0, len(right.keys))
 
 	return true
 }
 
 // mergeInternalNodes mrege children of right into tn
 func (tn *tNode) mergeInternalNodes(key int64, right *tNode) bool {
-	sz := len(tn.entries) + len(right.entries)
+	sz := len(tn.keys) + len(right.keys)
 
 	// unable to merge
-	if sz > cap(tn.entries)-1 {
+	if sz > tn.cfg.maxEntries(false) {
 		return false
 	}
 
-	glog.V(2).Infof("merge %s into %s", right.ChildrenStr(), tn.ChildrenStr())
+	if tn.cfg.debug() {
+		tn.cfg.log.Debugf("merge %s into %s", right.ChildrenStr(), tn.ChildrenStr())
+	}
 	// update parent of right children
-	for _, e := range right.entries {
-		c := e.pointer.(*tNode)
+	for _, p := range right.ptrs {
+		c := p.(*tNode)
 		c.parent = tn
 	}
 
-	right.entries[0].key = key
-	start := len(tn.entries)
-	tn.entries = tn.entries[:sz]
-	for i := range right.entries {
-		tn.entries[start+i] = right.entries[i]
-	}
+	right.keys[0] = key
+	start := len(tn.keys)
+	tn.resize(sz)
+	copyEntries(tn, start, right, 0, len(right.keys))
 
 	return true
 }
@@ -194,13 +381,16 @@ func (tn *tNode) mergeInternalNodes(key int64, right *tNode) bool {
 // delete entry with key
 func (tn *tNode) deleteEntry(key int64) error {
 	var pos int
+	sz := len(tn.keys)
 	if !tn.isLeaf {
 		pos = tn.findInternalInsertPos(key)
 	} else {
 		pos = tn.findLeafInsertPos(key)
+		// the last entry of leaf points to sibling
+		sz -= 1
 	}
 
-	if pos >= len(tn.entries) || tn.entries[pos].key != key {
+	if pos >= sz || tn.cfg.compare(tn.keys[pos], key) != 0 {
 		return ErrKeyNotFound
 	}
 
@@ -211,17 +401,21 @@ func (tn *tNode) deleteEntry(key int64) error {
 // delete entry at pos
 func (tn *tNode) deleteEntryAt(pos int) {
 	// delete entry at from leaf
-	glog.V(2).Infof("deleting entry at %d: %+v", pos, tn.entries)
-	copy(tn.entries[pos:], tn.entries[pos+1:])
-	tn.entries = tn.entries[:len(tn.entries)-1]
+	if tn.cfg.debug() {
+		tn.cfg.log.Debugf("deleting entry at %d: %s", pos, tn.ChildrenStr())
+	}
+	sz := len(tn.keys)
+	copyEntries(tn, pos, tn, pos+1, sz)
+	tn.resize(sz - 1)
 }
 
 func (tn *tNode) tooFewPointers() bool {
 	if tn.isLeaf {
-		return len(tn.entries) < (cap(tn.entries)+1)/2
+		// the last entry of leaf points to sibling
+		return len(tn.keys)-1 < tn.cfg.minLeafKeys
 	}
 
-	return len(tn.entries) < cap(tn.entries)/2
+	return len(tn.keys) < tn.cfg.minChildren
 }
 
 func borrowFromLeft(left *tNode, key *int64, right *tNode) {
@@ -255,69 +449,74 @@ func borrowFromRight(left *tNode, key *int64, right *tNode) {
 }
 
 func leafBorrowFromLeft(left *tNode, key *int64, right *tNode) {
-	sz := len(left.entries)
-	e := left.entries[sz-2]
+	sz := len(left.keys)
+	e := left.entry(sz - 2)
 
 	// shrink left by one
-	left.entries[sz-2] = left.entries[sz-1]
-	left.entries = left.entries[:sz-1]
+	left.setEntry(sz-2, left.entry(sz-1))
+	left.resize(sz - 1)
 
 	*key = e.key
 
 	// prepend entry e to right
 	// expand right first
-	sz = len(right.entries)
-	right.entries = right.entries[:sz+1]
-	copy(right.entries[1:], right.entries[:sz-1])
-	right.entries[0] = e
+	sz = len(right.keys)
+	right.resize(sz + 1)
+	copyEntries(right, 1, right, 0, sz)
+	right.setEntry(0, e)
 }
 
 func leafBorrowFromRight(left *tNode, key *int64, right *tNode) {
-	sz := len(right.entries)
-	e := right.entries[0]
+	sz := len(right.keys)
+	e := right.entry(0)
 
 	// shrink right by one
-	copy(right.entries[:sz-1], right.entries[1:])
-	right.entries = right.entries[:sz-1]
+	copyEntries(right, 0, right, 1, sz)
+	right.resize(sz - 1)
 
-	*key = e.key
+	// right now starts with the entry next to e
+	*key = right.keys[0]
 
 	// append entry (k, p) to left
 	// expand left first
-	sz = len(left.entries)
-	left.entries = left.entries[:sz+1]
-	left.entries[sz] = left.entries[sz-1]
-	left.entries[sz-1] = e
+	sz = len(left.keys)
+	left.resize(sz + 1)
+	left.setEntry(sz, left.entry(sz-1))
+	left.setEntry(sz-1, e)
 }
 
 func internalBorrowFromLeft(left *tNode, key *int64, right *tNode) {
-	glog.V(2).Infof("borrow one key from left %s to right %s", left.ChildrenStr(), right.ChildrenStr())
-	sz := len(left.entries)
-	e := left.entries[sz-1]
+	if left.cfg.debug() {
+		left.cfg.log.Debugf("borrow one key from left %s to right %s", left.ChildrenStr(), right.ChildrenStr())
+	}
+	sz := len(left.keys)
+	e := left.entry(sz - 1)
 
 	// swap key and e.key
-	*key, right.entries[0].key = e.key, *key
+	*key, right.keys[0] = e.key, *key
 	e.key = 0
 
 	// shrink left by one
-	left.entries = left.entries[:sz-1]
+	left.resize(sz - 1)
 
 	// // update children
 	e.pointer.(*tNode).parent = right
 
 	// prepend entry (k, p) to right
 	// expand right first
-	sz = len(right.entries)
-	right.entries = right.entries[:sz+1]
-	copy(right.entries[1:], right.entries[:sz])
-	right.entries[0] = e
+	sz = len(right.keys)
+	right.resize(sz + 1)
+	copyEntries(right, 1, right, 0, sz)
+	right.setEntry(0, e)
 }
 
 func internalBorrowFromRight(left *tNode, key *int64, right *tNode) {
-	glog.V(2).Infof("borrow one key from right %s to left %s", right.ChildrenStr(), left.ChildrenStr())
-	sz := len(right.entries)
-	e := right.entries[0]
-	e.key = right.entries[1].key
+	if left.cfg.debug() {
+		left.cfg.log.Debugf("borrow one key from right %s to left %s", right.ChildrenStr(), left.ChildrenStr())
+	}
+	sz := len(right.keys)
+	e := right.entry(0)
+	e.key = right.keys[1]
 
 	// swap key and e.key
 	*key, e.key = e.key, *key
@@ -326,12 +525,94 @@ func internalBorrowFromRight(left *tNode, key *int64, right *tNode) {
 	e.pointer.(*tNode).parent = left
 
 	// shrink right by one
-	copy(right.entries[:sz-1], right.entries[1:])
-	right.entries = right.entries[:sz-1]
-	right.entries[0].key = 0
+	copyEntries(right, 0, right, 1, sz)
+	right.resize(sz - 1)
+	right.keys[0] = 0
 
 	// append entry (k, p) to left
-	sz = len(left.entries)
-	left.entries = left.entries[:sz+1]
-	left.entries[sz] = e
+	sz = len(left.keys)
+	left.resize(sz + 1)
+	left.setEntry(sz, e)
+}
+
+// redistribute moves entries between adjacent siblings left and right so
+// that both hold about the same number of entries, key points to the
+// key of right in parent and is updated accordingly.
+func redistribute(left *tNode, key *int64, right *tNode) {
+	n := len(left.keys) + len(right.keys)
+	if left.isLeaf {
+		// the last entry of leaf points to sibling
+		n -= 2
+	}
+
+	share(left, key, right, (n+1)/2)
+}
+
+// share moves entries between adjacent siblings left and right so that
+// left holds n keys if they are leaves or n children otherwise, key
+// points to the key of right in parent and is updated accordingly.
+func share(left *tNode, key *int64, right *tNode, n int) {
+	if left.isLeaf != right.isLeaf {
+		glog.Fatalf("unable to redistribute entries between leaf and internal node, left: %s, right: %s", left.ChildrenStr(), right.ChildrenStr())
+	}
+
+	if left.isLeaf {
+		shareLeaves(left, key, right, n)
+		return
+	}
+
+	shareInternalNodes(left, key, right, n)
+}
+
+func shareLeaves(left *tNode, key *int64, right *tNode, half int) {
+	// the last entry of leaf points to sibling
+	ln, rn := len(left.keys)-1, len(right.keys)-1
+	if ln > half {
+		m := ln - half
+		right.resize(rn + 1 + m)
+		copyEntries(right, m, right, 0, rn+1)
+		copyEntries(right, 0, left, half, ln)
+		left.setEntry(half, left.entry(ln))
+		left.resize(half + 1)
+	} else if ln < half {
+		m := half - ln
+		left.resize(half + 1)
+		copyEntries(left, ln, right, 0, m)
+		left.setEntry(half, Entry{pointer: right})
+		copyEntries(right, 0, right, m, rn+1)
+		right.resize(rn + 1 - m)
+	}
+
+	*key = right.keys[0]
+}
+
+func shareInternalNodes(left *tNode, key *int64, right *tNode, half int) {
+	ln, rn := len(left.keys), len(right.keys)
+	// right is empty when it is the new node of splitThree
+	if rn > 0 {
+		right.keys[0] = *key
+	}
+
+	if ln > half {
+		m := ln - half
+		right.resize(rn + m)
+		copyEntries(right, m, right, 0, rn)
+		copyEntries(right, 0, left, half, ln)
+		left.resize(half)
+		for _, p := range right.ptrs[:m] {
+			p.(*tNode).parent = right
+		}
+	} else if ln < half {
+		m := half - ln
+		left.resize(half)
+		copyEntries(left, ln, right, 0, m)
+		copyEntries(right, 0, right, m, rn)
+		right.resize(rn - m)
+		for _, p := range left.ptrs[ln:] {
+			p.(*tNode).parent = left
+		}
+	}
+
+	*key = right.keys[0]
+	right.keys[0] = 0
 }
@@ -1,10 +1,34 @@
 package v2
 
 import (
+	"math/rand"
 	"reflect"
+	"sort"
 	"testing"
 )
 
+func newTNode(isLeaf bool, maxSize int) *tNode {
+	return newConfig(maxSize-1, maxSize).newNode(isLeaf)
+}
+
+// nodeEntries returns entries of tn
+func nodeEntries(tn *tNode) []Entry {
+	es := make([]Entry, tn.size())
+	for i := range es {
+		es[i] = tn.entry(i)
+	}
+
+	return es
+}
+
+// setEntries replaces entries of tn with es
+func setEntries(tn *tNode, es []Entry) {
+	tn.resize(len(es))
+	for i, e := range es {
+		tn.setEntry(i, e)
+	}
+}
+
 func TestLeafInsert(t *testing.T) {
 	leaf := newTNode(true, 4)
 	keys := []int{5, 1, 4}
@@ -34,8 +58,8 @@ func TestLeafInsert(t *testing.T) {
 		{0, nil},
 	}
 
-	if !reflect.DeepEqual(wentries, leaf.entries) {
-		t.Fatalf("want %+v, but got %+v", wentries, leaf.entries)
+	if !reflect.DeepEqual(wentries, nodeEntries(leaf)) {
+		t.Fatalf("want %+v, but got %+v", wentries, nodeEntries(leaf))
 	}
 
 	// leaf.insertLeaf(&Entry{key: 6, pointer: 6})
@@ -53,13 +77,13 @@ func TestSplitLeafNodeEven(t *testing.T) {
 	right := ne.pointer.(*tNode)
 	t.Logf("after split, left: %s, right: %s", leaf.ToString(), right.ToString())
 
-	lsz, rsz := len(leaf.entries), len(right.entries)
+	lsz, rsz := len(leaf.keys), len(right.keys)
 	if lsz != 3 || rsz != 3 {
 		t.Fatalf("expect both entry sizes to be 3 after split but got left: %d and right: %d", lsz, rsz)
 	}
 
-	if leaf.entries[lsz-1].pointer.(*tNode) != right {
-		t.Fatalf("expect sibling connected to %+v but got %+v", right, leaf.entries[lsz-1].pointer.(*tNode))
+	if leaf.child(lsz-1) != right {
+		t.Fatalf("expect sibling connected to %+v but got %+v", right, leaf.child(lsz-1))
 	}
 }
 
@@ -74,20 +98,19 @@ func TestSplitLeafNodeOdd(t *testing.T) {
 	right := ne.pointer.(*tNode)
 	t.Logf("after split:\nleft:\n%s\nright:\n%s", leaf.ToString(), right.ToString())
 
-	lsz, rsz := len(leaf.entries), len(right.entries)
+	lsz, rsz := len(leaf.keys), len(right.keys)
 	if lsz != 4 || rsz != 3 {
 		t.Fatalf("expect both entry sizes to be 3 after split but got left: %d and right: %d", lsz, rsz)
 	}
 
-	if leaf.entries[lsz-1].pointer.(*tNode) != right {
-		t.Fatalf("expect sibling connected to %+v but got %+v", right, leaf.entries[lsz-1].pointer.(*tNode))
+	if leaf.child(lsz-1) != right {
+		t.Fatalf("expect sibling connected to %+v but got %+v", right, leaf.child(lsz-1))
 	}
 }
 
 func TestSplitInternalNodeEven(t *testing.T) {
 	inode := newTNode(false, 4)
-	inode.entries = inode.entries[:1]
-	inode.entries[0] = Entry{pointer: &tNode{parent: inode}}
+	setEntries(inode, []Entry{{pointer: &tNode{parent: inode}}})
 	for i := 4; i >= 1; i-- {
 		pos := inode.findInternalInsertPos(int64(i))
 		t.Logf("insert key %d at %d", i, pos)
@@ -95,26 +118,25 @@ func TestSplitInternalNodeEven(t *testing.T) {
 	}
 
 	ne := inode.splitInternalNode()
-	t.Logf("inode entries after split: %+v", inode.entries)
+	t.Logf("inode entries after split: %+v", nodeEntries(inode))
 	if ne.key != 3 {
 		t.Fatalf("expect new entry key 3 but got %d", ne.key)
 	}
 
-	if len(inode.entries) != 3 {
-		t.Fatalf("expect inode entry of size 3 but got %d", len(inode.entries))
+	if len(inode.keys) != 3 {
+		t.Fatalf("expect inode entry of size 3 but got %d", len(inode.keys))
 	}
 
 	newChild := ne.pointer.(*tNode)
-	t.Logf("new child entries: %+v", newChild.entries)
-	if len(newChild.entries) != 2 {
-		t.Fatalf("expect new child entry of size 2 but got %d", len(inode.entries))
+	t.Logf("new child entries: %+v", nodeEntries(newChild))
+	if len(newChild.keys) != 2 {
+		t.Fatalf("expect new child entry of size 2 but got %d", len(inode.keys))
 	}
 }
 
 func TestSplitInternalNodeOdd(t *testing.T) {
 	inode := newTNode(false, 5)
-	inode.entries = inode.entries[:1]
-	inode.entries[0] = Entry{pointer: &tNode{parent: inode}}
+	setEntries(inode, []Entry{{pointer: &tNode{parent: inode}}})
 	for i := 5; i >= 1; i-- {
 		pos := inode.findInternalInsertPos(int64(i))
 		t.Logf("insert key %d at %d", i, pos)
@@ -122,19 +144,19 @@ func TestSplitInternalNodeOdd(t *testing.T) {
 	}
 
 	ne := inode.splitInternalNode()
-	t.Logf("inode entries after split: %+v", inode.entries)
+	t.Logf("inode entries after split: %+v", nodeEntries(inode))
 	if ne.key != 3 {
 		t.Fatalf("expect new entry key 3 but got %d", ne.key)
 	}
 
-	if len(inode.entries) != 3 {
-		t.Fatalf("expect inode entry of size 3 but got %d", len(inode.entries))
+	if len(inode.keys) != 3 {
+		t.Fatalf("expect inode entry of size 3 but got %d", len(inode.keys))
 	}
 
 	newChild := ne.pointer.(*tNode)
-	t.Logf("new child entries: %+v", newChild.entries)
-	if len(newChild.entries) != 3 {
-		t.Fatalf("expect new child entry of size 3 but got %d", len(inode.entries))
+	t.Logf("new child entries: %+v", nodeEntries(newChild))
+	if len(newChild.keys) != 3 {
+		t.Fatalf("expect new child entry of size 3 but got %d", len(inode.keys))
 	}
 }
 
@@ -156,8 +178,8 @@ func TestMergeLeafNodes(t *testing.T) {
 		{0, nil},
 	}
 
-	if !reflect.DeepEqual(wentries, left.entries) {
-		t.Fatalf("expect entries %+v but got %+v", wentries, left.entries)
+	if !reflect.DeepEqual(wentries, nodeEntries(left)) {
+		t.Fatalf("expect entries %+v but got %+v", wentries, nodeEntries(left))
 	}
 
 	right = newTNode(true, 4)
@@ -187,8 +209,8 @@ func TestMergeInternalNode(t *testing.T) {
 		{3, rightChild},
 	}
 
-	if !reflect.DeepEqual(wentries, left.entries) {
-		t.Fatalf("expect entries %+v but got %+v", wentries, left.entries)
+	if !reflect.DeepEqual(wentries, nodeEntries(left)) {
+		t.Fatalf("expect entries %+v but got %+v", wentries, nodeEntries(left))
 	}
 
 	right = newTNode(false, 4)
@@ -207,7 +229,7 @@ func TestDeleteInternalNode(t *testing.T) {
 		{3, 3},
 		{4, 4},
 	}
-	root.entries = wentries
+	setEntries(root, wentries)
 
 	if err := root.deleteEntry(2); err != nil {
 		t.Fatal(err)
@@ -218,8 +240,8 @@ func TestDeleteInternalNode(t *testing.T) {
 		{3, 3},
 		{4, 4},
 	}
-	if !reflect.DeepEqual(wentries, root.entries) {
-		t.Fatalf("expect entries %+v but got %+v", wentries, root.entries)
+	if !reflect.DeepEqual(wentries, nodeEntries(root)) {
+		t.Fatalf("expect entries %+v but got %+v", wentries, nodeEntries(root))
 	}
 
 	if err := root.deleteEntry(1); err != nil {
@@ -230,8 +252,8 @@ func TestDeleteInternalNode(t *testing.T) {
 		{3, 3},
 		{4, 4},
 	}
-	if !reflect.DeepEqual(wentries, root.entries) {
-		t.Fatalf("expect entries %+v but got %+v", wentries, root.entries)
+	if !reflect.DeepEqual(wentries, nodeEntries(root)) {
+		t.Fatalf("expect entries %+v but got %+v", wentries, nodeEntries(root))
 	}
 
 	if err := root.deleteEntry(4); err != nil {
@@ -241,8 +263,8 @@ func TestDeleteInternalNode(t *testing.T) {
 		{0, nil},
 		{3, 3},
 	}
-	if !reflect.DeepEqual(wentries, root.entries) {
-		t.Fatalf("expect entries %+v but got %+v", wentries, root.entries)
+	if !reflect.DeepEqual(wentries, nodeEntries(root)) {
+		t.Fatalf("expect entries %+v but got %+v", wentries, nodeEntries(root))
 	}
 }
 
@@ -256,8 +278,8 @@ func TestDeleteLeafNode(t *testing.T) {
 		{4, 4},
 		{0, nil},
 	}
-	if !reflect.DeepEqual(wentries, root.entries) {
-		t.Fatalf("expect entries %+v but got %+v", wentries, root.entries)
+	if !reflect.DeepEqual(wentries, nodeEntries(root)) {
+		t.Fatalf("expect entries %+v but got %+v", wentries, nodeEntries(root))
 	}
 
 	if err := root.deleteEntry(2); err != nil {
@@ -269,8 +291,8 @@ func TestDeleteLeafNode(t *testing.T) {
 		{4, 4},
 		{0, nil},
 	}
-	if !reflect.DeepEqual(wentries, root.entries) {
-		t.Fatalf("expect entries %+v but got %+v", wentries, root.entries)
+	if !reflect.DeepEqual(wentries, nodeEntries(root)) {
+		t.Fatalf("expect entries %+v but got %+v", wentries, nodeEntries(root))
 	}
 
 	if err := root.deleteEntry(1); err != nil {
@@ -281,8 +303,8 @@ func TestDeleteLeafNode(t *testing.T) {
 		{4, 4},
 		{0, nil},
 	}
-	if !reflect.DeepEqual(wentries, root.entries) {
-		t.Fatalf("expect entries %+v but got %+v", wentries, root.entries)
+	if !reflect.DeepEqual(wentries, nodeEntries(root)) {
+		t.Fatalf("expect entries %+v but got %+v", wentries, nodeEntries(root))
 	}
 
 	if err := root.deleteEntry(4); err != nil {
@@ -292,8 +314,8 @@ func TestDeleteLeafNode(t *testing.T) {
 		{3, 3},
 		{0, nil},
 	}
-	if !reflect.DeepEqual(wentries, root.entries) {
-		t.Fatalf("expect entries %+v but got %+v", wentries, root.entries)
+	if !reflect.DeepEqual(wentries, nodeEntries(root)) {
+		t.Fatalf("expect entries %+v but got %+v", wentries, nodeEntries(root))
 	}
 }
 
@@ -357,8 +379,7 @@ func TestTooFewPointers(t *testing.T) {
 	for i, tc := range cases {
 		node := newTNode(tc.isLeaf, tc.maxSize)
 		if !tc.isLeaf {
-			node.entries = node.entries[:1]
-			node.entries[0] = Entry{key: 0}
+			setEntries(node, []Entry{{key: 0}})
 		}
 
 		for _, k := range tc.keys {
@@ -371,7 +392,136 @@ func TestTooFewPointers(t *testing.T) {
 		}
 
 		if node.tooFewPointers() != tc.tooFewPointers {
-			t.Fatalf("test case %d, expect too few pointers: %t but got: %t, entry: %+v", i, tc.tooFewPointers, node.tooFewPointers(), node.entries)
+			t.Fatalf("test case %d, expect too few pointers: %t but got: %t, entry: %+v", i, tc.tooFewPointers, node.tooFewPointers(), nodeEntries(node))
+		}
+	}
+}
+
+func TestRedistribute(t *testing.T) {
+	cases := []struct {
+		isLeaf    bool
+		left      int
+		right     int
+		wantLeft  int
+		wantRight int
+	}{
+		{isLeaf: true, left: 1, right: 5, wantLeft: 3, wantRight: 3},
+		{isLeaf: true, left: 6, right: 1, wantLeft: 4, wantRight: 3},
+		{isLeaf: true, left: 3, right: 3, wantLeft: 3, wantRight: 3},
+		{isLeaf: false, left: 1, right: 6, wantLeft: 4, wantRight: 3},
+		{isLeaf: false, left: 7, right: 2, wantLeft: 5, wantRight: 4},
+	}
+
+	for i, tc := range cases {
+		// keys of left are [0, left), keys of right are [left, left+right)
+		left, right := newTNode(tc.isLeaf, 8), newTNode(tc.isLeaf, 8)
+		for j := 0; j < tc.left+tc.right; j++ {
+			tn, pos := left, j
+			if j >= tc.left {
+				tn, pos = right, j-tc.left
+			}
+
+			if tn.isLeaf {
+				tn.insertLeaf(&Entry{key: int64(j), pointer: j})
+			} else {
+				tn.insertAt(pos, &Entry{key: int64(j), pointer: &tNode{parent: tn}})
+			}
+		}
+
+		key := int64(tc.left)
+		if tc.isLeaf {
+			left.ptrs[len(left.keys)-1] = right
+		}
+		redistribute(left, &key, right)
+
+		keys := []int64{}
+		for _, tn := range []*tNode{left, right} {
+			es := nodeEntries(tn)
+			if tn.isLeaf {
+				es = es[:len(es)-1]
+			}
+
+			for j, e := range es {
+				if !tn.isLeaf && j == 0 {
+					if tn == right {
+						keys = append(keys, key)
+					} else {
+						keys = append(keys, e.key)
+					}
+					if child := e.pointer.(*tNode); child.parent != tn {
+						t.Fatalf("case %d: expect parent of child %d to be updated", i, j)
+					}
+					continue
+				}
+				keys = append(keys, e.key)
+				if !tn.isLeaf && e.pointer.(*tNode).parent != tn {
+					t.Fatalf("case %d: expect parent of child %d to be updated", i, j)
+				}
+			}
+		}
+
+		lsz, rsz := len(left.keys), len(right.keys)
+		if tc.isLeaf {
+			lsz, rsz = lsz-1, rsz-1
+			if left.ptrs[lsz] != right || right.ptrs[rsz] != nil {
+				t.Fatalf("case %d: expect siblings connected", i)
+			}
+		}
+
+		if lsz != tc.wantLeft || rsz != tc.wantRight {
+			t.Fatalf("case %d: expect sizes (%d, %d) but got (%d, %d)", i, tc.wantLeft, tc.wantRight, lsz, rsz)
+		}
+
+		for j, k := range keys {
+			if k != int64(j) {
+				t.Fatalf("case %d: expect keys in sequence but got %+v", i, keys)
+			}
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, search := range []Search{SearchBinary, SearchLinear, SearchBranchless} {
+		for n := 1; n <= 40; n++ {
+			tn := newTNode(true, 64)
+			tn.cfg.search = search
+			tn.resize(n + 1)
+			k := int64(0)
+			for i := 0; i < n; i++ {
+				k += r.Int63n(3) + 1
+				tn.keys[i] = k
+			}
+
+			for key := int64(0); key <= k+1; key++ {
+				want := sort.Search(n, func(i int) bool { return tn.keys[i] >= key })
+				if got := tn.findLeafInsertPos(key); got != want {
+					t.Fatalf("%s search of %d in %v: expect %d but got %d", search, key, tn.keys[:n], want, got)
+				}
+			}
+		}
+
+		tr, _ := NewTree(WithFanout(16), WithSearch(search))
+		model := map[int64]bool{}
+		for i := 0; i < 3000; i++ {
+			key := r.Int63n(500)
+			if r.Intn(3) == 0 {
+				tr.Delete(key)
+				delete(model, key)
+			} else {
+				tr.Insert(&Entry{key: key, pointer: key})
+				model[key] = true
+			}
+		}
+
+		if err := tr.Check(); err != nil || tr.Len() != len(model) {
+			t.Fatalf("%s search: expect %d keys but got %d, %+v", search, len(model), tr.Len(), err)
+		}
+
+		for key := int64(0); key < 500; key++ {
+			if _, err := tr.Find(key); (err == nil) != model[key] {
+				t.Fatalf("%s search: expect key %d found %t but got %+v", search, key, model[key], err)
+			}
 		}
 	}
 }
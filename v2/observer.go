@@ -0,0 +1,73 @@
+package v2
+
+// Observer is notified of structural changes of tree, e.g. to collect
+// metrics or to animate the tree. Keys passed are keys of the involved
+// nodes right after the change, keys of internal nodes exclude the
+// unused first entry.
+type Observer interface {
+	// OnLeafSplit is called after a full leaf is split into left and
+	// right. In B* mode a split of two full siblings into three is
+	// reported as a split of the left one and the new middle one.
+	OnLeafSplit(left, right []int64)
+	// OnInternalSplit is called after a full internal node is split
+	// into left and right, sep is the key moved up to parent
+	OnInternalSplit(left []int64, sep int64, right []int64)
+	// OnMerge is called after a node with too few pointers is merged
+	// with its sibling, merged holds keys of the resulting node
+	OnMerge(leaf bool, merged []int64)
+	// OnBorrow is called after a node with too few pointers borrowed
+	// an entry from its left or right sibling, or in B* mode after an
+	// overflowed node shifted entries into its sibling
+	OnBorrow(dir BorrowDir, leaf bool, left, right []int64)
+	// OnRootGrow is called after a root split and the tree grows by
+	// one level
+	OnRootGrow(root []int64)
+	// OnRootShrink is called after the root is left with a single child
+	// which becomes the new root
+	OnRootShrink(root []int64)
+}
+
+type BorrowDir int
+
+const (
+	BorrowFromLeft BorrowDir = iota
+	BorrowFromRight
+)
+
+func (d BorrowDir) String() string {
+	if d == BorrowFromLeft {
+		return "left"
+	}
+
+	return "right"
+}
+
+// NopObserver ignores all changes, embed it to observe only some of
+// them.
+type NopObserver struct{}
+
+func (NopObserver) OnLeafSplit(left, right []int64)                        {}
+func (NopObserver) OnInternalSplit(left []int64, sep int64, right []int64) {}
+func (NopObserver) OnMerge(leaf bool, merged []int64)                      {}
+func (NopObserver) OnBorrow(dir BorrowDir, leaf bool, left, right []int64) {}
+func (NopObserver) OnRootGrow(root []int64)                                {}
+func (NopObserver) OnRootShrink(root []int64)                              {}
+
+// SetObserver sets observer of structural changes of tr, nil to stop
+// observing.
+func (tr *BPlusTree) SetObserver(o Observer) {
+	tr.obs = o
+}
+
+// keyList returns a copy of keys of tn without the sibling pointer of
+// leaf or the unused first entry of internal node
+func (tn *tNode) keyList() []int64 {
+	keys := tn.keys
+	if tn.isLeaf {
+		keys = keys[:len(keys)-1]
+	} else {
+		keys = keys[1:]
+	}
+
+	return append(make([]int64, 0, len(keys)), keys...)
+}
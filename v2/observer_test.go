@@ -0,0 +1,135 @@
+package v2
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type eventLog struct {
+	events []string
+}
+
+func (l *eventLog) OnLeafSplit(left, right []int64) {
+	l.events = append(l.events, fmt.Sprintf("leaf split %v %v", left, right))
+}
+
+func (l *eventLog) OnInternalSplit(left []int64, sep int64, right []int64) {
+	l.events = append(l.events, fmt.Sprintf("internal split %v %d %v", left, sep, right))
+}
+
+func (l *eventLog) OnMerge(leaf bool, merged []int64) {
+	l.events = append(l.events, fmt.Sprintf("merge leaf=%t %v", leaf, merged))
+}
+
+func (l *eventLog) OnBorrow(dir BorrowDir, leaf bool, left, right []int64) {
+	l.events = append(l.events, fmt.Sprintf("borrow from %s leaf=%t %v %v", dir, leaf, left, right))
+}
+
+func (l *eventLog) OnRootGrow(root []int64) {
+	l.events = append(l.events, fmt.Sprintf("root grow %v", root))
+}
+
+func (l *eventLog) OnRootShrink(root []int64) {
+	l.events = append(l.events, fmt.Sprintf("root shrink %v", root))
+}
+
+func TestObserver(t *testing.T) {
+	cases := []struct {
+		maxSize int
+		inserts []int64
+		deletes []int64
+		events  []string
+	}{
+		{
+			maxSize: 3,
+			inserts: []int64{1, 2, 3, 4, 5, 6, 7},
+			deletes: []int64{1, 2, 7, 6, 5},
+			events: []string{
+				"leaf split [1 2] [3]",
+				"root grow [3]",
+				"leaf split [3 4] [5]",
+				"leaf split [5 6] [7]",
+				"internal split [3] 5 [7]",
+				"root grow [5]",
+				"merge leaf=true [3 4]",
+				"merge leaf=false [5 7]",
+				"root shrink [5 7]",
+				"merge leaf=true [5 6]",
+				"merge leaf=true [3 4]",
+				"root shrink [3 4]",
+			},
+		},
+		{
+			maxSize: 4,
+			inserts: []int64{1, 2, 3, 4, 5},
+			deletes: []int64{1},
+			events: []string{
+				"leaf split [1 2] [3 4]",
+				"root grow [3]",
+				"borrow from right leaf=true [2 3] [4 5]",
+			},
+		},
+		{
+			maxSize: 4,
+			inserts: []int64{3, 4, 5, 6, 2},
+			deletes: []int64{6},
+			events: []string{
+				"leaf split [3 4] [5 6]",
+				"root grow [5]",
+				"borrow from left leaf=true [2 3] [4 5]",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		tr := newTree(t, c.maxSize, 0, 0)
+		l := &eventLog{}
+		tr.SetObserver(l)
+		for _, key := range c.inserts {
+			tr.Insert(&Entry{key: key, pointer: key})
+		}
+
+		for _, key := range c.deletes {
+			if err := tr.Delete(key); err != nil {
+				t.Fatalf("error deleting key %d: %+v", key, err)
+			}
+		}
+
+		if !reflect.DeepEqual(l.events, c.events) {
+			t.Fatalf("expect events %q but got %q", c.events, l.events)
+		}
+	}
+}
+
+func TestNopObserver(t *testing.T) {
+	tr := newTree(t, 4, 0, 0)
+	obs := &leafSplits{}
+	tr.SetObserver(obs)
+	for i := int64(0); i < 100; i++ {
+		tr.Insert(&Entry{key: i, pointer: i})
+	}
+
+	if st := tr.Stats(); obs.n != st.LeafNodes-1 {
+		t.Fatalf("expect %d leaf splits but got %d", st.LeafNodes-1, obs.n)
+	}
+
+	n := obs.n
+	tr.SetObserver(nil)
+	for i := int64(100); i < 200; i++ {
+		tr.Insert(&Entry{key: i, pointer: i})
+	}
+
+	if obs.n != n {
+		t.Fatalf("expect no more leaf splits after observer reset but got %d", obs.n-n)
+	}
+}
+
+type leafSplits struct {
+	NopObserver
+	n int
+}
+
+func (s *leafSplits) OnLeafSplit(left, right []int64) {
+	s.n++
+}
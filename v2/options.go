@@ -0,0 +1,552 @@
+package v2
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// DefaultFanout is max number of pointers in a node unless set by
+	// WithFanout, WithLeafCapacity or WithInternalCapacity
+	DefaultFanout = 64
+	// DefaultMinFill is the least fraction of a node in use unless set
+	// by WithMinFill
+	DefaultMinFill = 0.5
+)
+
+// ErrCustomOrder is returned when saving a tree with a custom
+// comparator, persisted formats keep keys in ascending order.
+var ErrCustomOrder error = fmt.Errorf("tree with custom comparator cannot be persisted")
+
+// Comparator orders keys, it returns a negative number if a < b, zero
+// if a == b and a positive number if a > b.
+type Comparator func(a, b int64) int
+
+// DupPolicy decides what Insert does with a key already in tree
+type DupPolicy int
+
+const (
+	// DupError fails with ErrDupKey
+	DupError DupPolicy = iota
+	// DupReplace replaces value of the key
+	DupReplace
+	// DupIgnore keeps value of the key and reports no error
+	DupIgnore
+)
+
+// Search is the way nodes are searched for a key
+type Search int
+
+const (
+	// SearchBinary searches nodes by binary search
+	SearchBinary Search = iota
+	// SearchLinear scans keys of nodes from the start, it is faster
+	// than binary search for small nodes
+	SearchLinear
+	// SearchBranchless searches nodes by binary search without data
+	// dependent branches, which avoids branch mispredictions. It falls
+	// back to binary search under a custom comparator.
+	SearchBranchless
+)
+
+func (s Search) String() string {
+	switch s {
+	case SearchBinary:
+		return "binary"
+	case SearchLinear:
+		return "linear"
+	case SearchBranchless:
+		return "branchless"
+	}
+
+	return fmt.Sprintf("Search(%d)", int(s))
+}
+
+func (p DupPolicy) String() string {
+	switch p {
+	case DupError:
+		return "error"
+	case DupReplace:
+		return "replace"
+	case DupIgnore:
+		return "ignore"
+	}
+
+	return fmt.Sprintf("DupPolicy(%d)", int(p))
+}
+
+// Logger receives diagnostic messages of tree, it logs with glog by
+// default. Arguments of Debugf are formatted for every call unless the
+// logger implements DebugLogger.
+type Logger interface {
+	// Debugf logs detailed tracing of tree operations
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// DebugLogger is a Logger that tells whether debug messages are
+// logged, tree skips building them if not
+type DebugLogger interface {
+	Logger
+	DebugEnabled() bool
+}
+
+type glogLogger struct{}
+
+func (glogLogger) Debugf(format string, args ...interface{}) {
+	glog.V(2).Infof(format, args...)
+}
+
+func (glogLogger) Infof(format string, args ...interface{}) {
+	glog.Infof(format, args...)
+}
+
+func (glogLogger) Errorf(format string, args ...interface{}) {
+	glog.Errorf(format, args...)
+}
+
+type options struct {
+	leafCap  int
+	innerCap int
+	minFill  float64
+	cmp      Comparator
+	dup      DupPolicy
+	search   Search
+	log      Logger
+	obs      Observer
+	prealloc int
+	codec    ValueCodec
+	bstar    bool
+	appends  bool
+	monoid   *Monoid
+	clock    func() time.Time
+}
+
+// Option configures tree created by NewTree
+type Option func(o *options) error
+
+// WithFanout sets max number of pointers in a node, a leaf holds one
+// key less than its fanout as its last pointer links to sibling. It is
+// a shorthand for WithLeafCapacity(n-1) and WithInternalCapacity(n).
+func WithFanout(n int) Option {
+	return func(o *options) error {
+		if n < 3 {
+			return fmt.Errorf("fanout should be at least 3: %d", n)
+		}
+
+		o.leafCap, o.innerCap = n-1, n
+		return nil
+	}
+}
+
+// WithLeafCapacity sets max number of keys in a leaf, e.g. a small
+// capacity suits large values.
+func WithLeafCapacity(n int) Option {
+	return func(o *options) error {
+		if n < 2 {
+			return fmt.Errorf("leaf capacity should be at least 2: %d", n)
+		}
+
+		o.leafCap = n
+		return nil
+	}
+}
+
+// WithInternalCapacity sets max number of children of an internal node
+func WithInternalCapacity(n int) Option {
+	return func(o *options) error {
+		if n < 3 {
+			return fmt.Errorf("internal capacity should be at least 3: %d", n)
+		}
+
+		o.innerCap = n
+		return nil
+	}
+}
+
+// WithSearch sets the way nodes are searched for a key, SearchBinary
+// by default
+func WithSearch(s Search) Option {
+	return func(o *options) error {
+		if s < SearchBinary || s > SearchBranchless {
+			return fmt.Errorf("unknown search: %d", int(s))
+		}
+
+		o.search = s
+		return nil
+	}
+}
+
+// WithMinFill sets the least fraction of keys of a leaf or children of
+// an internal node to be kept in use, nodes with fewer are merged with
+// or borrow from their siblings on delete. It should be in (0, 0.5]
+// so that any two siblings either fit into one node or have enough
+// entries to share, lower values trade space for less rebalancing.
+func WithMinFill(f float64) Option {
+	return func(o *options) error {
+		if !(f > 0 && f <= 0.5) {
+			return fmt.Errorf("min fill should be in (0, 0.5]: %v", f)
+		}
+
+		o.minFill = f
+		return nil
+	}
+}
+
+// WithComparator orders keys by cmp instead of their natural order.
+// Trees with a custom comparator cannot be saved into page files,
+// snapshots or traces as those rely on ascending key order.
+func WithComparator(cmp Comparator) Option {
+	return func(o *options) error {
+		if cmp == nil {
+			return fmt.Errorf("comparator should not be nil")
+		}
+
+		o.cmp = cmp
+		return nil
+	}
+}
+
+// WithDupPolicy sets how Insert treats keys already in tree, DupError
+// by default.
+func WithDupPolicy(p DupPolicy) Option {
+	return func(o *options) error {
+		if p < DupError || p > DupIgnore {
+			return fmt.Errorf("unknown duplicate key policy: %d", int(p))
+		}
+
+		o.dup = p
+		return nil
+	}
+}
+
+// WithLogger sends diagnostic messages of tree to l instead of glog
+func WithLogger(l Logger) Option {
+	return func(o *options) error {
+		if l == nil {
+			return fmt.Errorf("logger should not be nil")
+		}
+
+		o.log = l
+		return nil
+	}
+}
+
+// WithObserver sets observer of structural changes, see SetObserver
+func WithObserver(obs Observer) Option {
+	return func(o *options) error {
+		o.obs = obs
+		return nil
+	}
+}
+
+// WithPrealloc allocates room for nodes holding about n keys up front,
+// nodes are carved out of it until it is used up. The room is only
+// released after all nodes carved out of it are gone.
+func WithPrealloc(n int) Option {
+	return func(o *options) error {
+		if n < 0 {
+			return fmt.Errorf("prealloc size should not be negative: %d", n)
+		}
+
+		o.prealloc = n
+		return nil
+	}
+}
+
+// WithBStar makes Insert keep nodes at least two thirds full like a
+// B*-tree: an overflowed node first shifts entries into a sibling that
+// is not full, and only when its siblings are full it is split together
+// with one of them into three nodes. The root is split as usual.
+func WithBStar() Option {
+	return func(o *options) error {
+		o.bstar = true
+		return nil
+	}
+}
+
+// WithAppendFastPath makes Insert put a key greater than all keys in
+// tree into the cached rightmost leaf without searching from root. Once
+// appends go on, a full rightmost node is split unevenly: the old node
+// is kept full and the new one starts almost empty, so that sequential
+// loads leave nodes nearly full.
+func WithAppendFastPath() Option {
+	return func(o *options) error {
+		o.appends = true
+		return nil
+	}
+}
+
+// WithValueCodec sets codec of values, see SetValueCodec
+func WithValueCodec(codec ValueCodec) Option {
+	return func(o *options) error {
+		o.codec = codec
+		return nil
+	}
+}
+
+// WithAggregate sets monoid of Aggregate
+func WithAggregate(m Monoid) Option {
+	return func(o *options) error {
+		if m.Combine == nil {
+			return fmt.Errorf("monoid should have a combine function")
+		}
+
+		o.monoid = &m
+		return nil
+	}
+}
+
+// WithClock sets clock by which entries inserted with a TTL expire,
+// time.Now by default
+func WithClock(now func() time.Time) Option {
+	return func(o *options) error {
+		if now == nil {
+			return fmt.Errorf("clock should not be nil")
+		}
+
+		o.clock = now
+		return nil
+	}
+}
+
+// config is shared by a tree and all of its nodes
+type config struct {
+	leafCap  int // max keys in a leaf
+	innerCap int // max children of an internal node
+	minFill  float64
+	cmp      Comparator // nil for natural order
+	dup      DupPolicy
+	search   Search
+	log      Logger
+	bstar    bool    // split as B*-tree on insert
+	appends  bool    // append fast path on insert
+	monoid   *Monoid // nil unless aggregating
+	// keySlab and ptrSlab are preallocated room for entries of new
+	// nodes
+	keySlab []int64
+	ptrSlab []interface{}
+	// free holds released nodes for reuse, indexed by isLeaf
+	free [2][]*tNode
+
+	// derived from capacities and minFill by init
+	minLeafKeys int
+	minChildren int
+}
+
+func newConfig(leafCap, innerCap int) *config {
+	c := &config{
+		leafCap:  leafCap,
+		innerCap: innerCap,
+		minFill:  DefaultMinFill,
+		log:      glogLogger{},
+	}
+	c.init()
+
+	return c
+}
+
+func (c *config) init() {
+	// round up so that the default fill of 1/2 keeps ceil((n-1)/2)
+	// keys in leaves and ceil(n/2) children in internal nodes, the
+	// epsilon absorbs floating point error of f*n
+	least := func(n int) int {
+		return int(math.Ceil(c.minFill*float64(n) - 1e-9))
+	}
+
+	c.minLeafKeys = least(c.leafCap)
+	if c.minLeafKeys < 1 {
+		c.minLeafKeys = 1
+	}
+
+	c.minChildren = least(c.innerCap)
+	if c.minChildren < 2 {
+		c.minChildren = 2
+	}
+}
+
+// withCapacity returns copy of c with the given capacities and no
+// preallocated room or released nodes
+func (c *config) withCapacity(leafCap, innerCap int) *config {
+	nc := *c
+	nc.leafCap, nc.innerCap = leafCap, innerCap
+	nc.keySlab, nc.ptrSlab = nil, nil
+	nc.free = [2][]*tNode{}
+	nc.init()
+
+	return &nc
+}
+
+// loadConfig returns config for content loaded into tr, options of tr
+// are kept but capacities
+func (tr *BPlusTree) loadConfig(leafCap, innerCap int) *config {
+	if tr.cfg == nil {
+		return newConfig(leafCap, innerCap)
+	}
+
+	return tr.cfg.withCapacity(leafCap, innerCap)
+}
+
+// prealloc reserves room for nodes of a tree holding n keys, assuming
+// nodes are half full
+func (c *config) prealloc(n int) {
+	leaves := 2*n/c.leafCap + 1
+	internals := 2*leaves/c.innerCap + 1
+	size := leaves*c.nodeSize(true) + internals*c.nodeSize(false)
+	c.keySlab = make([]int64, size)
+	c.ptrSlab = make([]interface{}, size)
+}
+
+// maxEntries returns max number of entries in a node, a leaf has one
+// more entry than its keys pointing to sibling
+func (c *config) maxEntries(isLeaf bool) int {
+	if isLeaf {
+		return c.leafCap + 1
+	}
+
+	return c.innerCap
+}
+
+// nodeSize returns capacity of entries of a node, which has room for
+// one extra entry before split
+func (c *config) nodeSize(isLeaf bool) int {
+	return c.maxEntries(isLeaf) + 1
+}
+
+func (c *config) newNode(isLeaf bool) *tNode {
+	if free := c.free[nodeKind(isLeaf)]; len(free) > 0 {
+		tn := free[len(free)-1]
+		c.free[nodeKind(isLeaf)] = free[:len(free)-1]
+		if isLeaf {
+			tn.resize(1)
+		}
+		return tn
+	}
+
+	sz := c.nodeSize(isLeaf)
+	tn := &tNode{
+		isLeaf: isLeaf,
+		cfg:    c,
+	}
+
+	if len(c.keySlab) >= sz {
+		tn.keys, tn.ptrs = c.keySlab[:0:sz], c.ptrSlab[:0:sz]
+		c.keySlab, c.ptrSlab = c.keySlab[sz:], c.ptrSlab[sz:]
+	} else {
+		tn.keys, tn.ptrs = make([]int64, 0, sz), make([]interface{}, 0, sz)
+	}
+
+	if isLeaf {
+		tn.resize(1)
+	}
+
+	return tn
+}
+
+func (c *config) compare(a, b int64) int {
+	if c.cmp != nil {
+		return c.cmp(a, b)
+	}
+
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+
+	return 0
+}
+
+// NewTree returns an empty tree configured by opts, e.g.:
+//
+//	tr, err := NewTree(WithFanout(32), WithDupPolicy(DupReplace))
+func NewTree(opts ...Option) (*BPlusTree, error) {
+	o := options{
+		leafCap:  DefaultFanout - 1,
+		innerCap: DefaultFanout,
+		minFill:  DefaultMinFill,
+		log:      glogLogger{},
+	}
+
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return nil, fmt.Errorf("illegal tree option: %w", err)
+		}
+	}
+
+	cfg := &config{
+		leafCap:  o.leafCap,
+		innerCap: o.innerCap,
+		minFill:  o.minFill,
+		cmp:      o.cmp,
+		dup:      o.dup,
+		search:   o.search,
+		log:      o.log,
+		bstar:    o.bstar,
+		appends:  o.appends,
+		monoid:   o.monoid,
+	}
+	cfg.init()
+	if o.prealloc > 0 {
+		cfg.prealloc(o.prealloc)
+	}
+
+	tr := &BPlusTree{
+		cfg:   cfg,
+		root:  cfg.newNode(true),
+		obs:   o.obs,
+		codec: o.codec,
+		clock: o.clock,
+	}
+
+	return tr, nil
+}
+
+// maxFreeNodes bounds released nodes of each kind kept for reuse, so
+// that a tree shrinking a lot does not hold on to its memory
+const maxFreeNodes = 256
+
+// release takes back tn which is no longer in tree for reuse by newNode
+func (c *config) release(tn *tNode) {
+	i := nodeKind(tn.isLeaf)
+	if len(c.free[i]) >= maxFreeNodes {
+		return
+	}
+
+	// drop references so that released nodes keep no values alive,
+	// keys of a new node are zero as well
+	tn.resize(cap(tn.keys))
+	for j := range tn.keys {
+		tn.keys[j], tn.ptrs[j] = 0, nil
+	}
+
+	tn.parent = nil
+	tn.appended = false
+	tn.resize(0)
+	c.free[i] = append(c.free[i], tn)
+}
+
+// debug tells whether debug messages are logged, so that callers can
+// skip building them
+func (c *config) debug() bool {
+	switch l := c.log.(type) {
+	case glogLogger:
+		return bool(glog.V(2))
+	case DebugLogger:
+		return l.DebugEnabled()
+	}
+
+	return true
+}
+
+func nodeKind(isLeaf bool) int {
+	if isLeaf {
+		return 1
+	}
+
+	return 0
+}
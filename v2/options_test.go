@@ -0,0 +1,343 @@
+package v2
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewTreeOptionErrors(t *testing.T) {
+	cases := []struct {
+		opt  Option
+		want string
+	}{
+		{WithFanout(2), "fanout should be at least 3: 2"},
+		{WithMinFill(0), "min fill should be in (0, 0.5]: 0"},
+		{WithMinFill(0.6), "min fill should be in (0, 0.5]: 0.6"},
+		{WithComparator(nil), "comparator should not be nil"},
+		{WithDupPolicy(DupPolicy(7)), "unknown duplicate key policy: 7"},
+		{WithLogger(nil), "logger should not be nil"},
+		{WithPrealloc(-1), "prealloc size should not be negative: -1"},
+		{WithSearch(Search(5)), "unknown search: 5"},
+		{WithClock(nil), "clock should not be nil"},
+	}
+
+	for _, c := range cases {
+		_, err := NewTree(c.opt)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Fatalf("expect error %q but got %+v", c.want, err)
+		}
+	}
+
+	tr, err := NewTree()
+	if err != nil {
+		t.Fatalf("expect no error but got %+v", err)
+	}
+
+	if tr.cfg.innerCap != DefaultFanout || tr.cfg.minFill != DefaultMinFill {
+		t.Fatalf("expect default fanout and min fill but got %d and %v", tr.cfg.innerCap, tr.cfg.minFill)
+	}
+}
+
+func TestMinFill(t *testing.T) {
+	cases := []struct {
+		maxSize     int
+		minFill     float64
+		minLeafKeys int
+		minChildren int
+	}{
+		{3, 0.5, 1, 2},
+		{4, 0.5, 2, 2},
+		{5, 0.5, 2, 3},
+		{10, 0.5, 5, 5},
+		{10, 0.3, 3, 3},
+		{10, 0.01, 1, 2},
+	}
+
+	for _, c := range cases {
+		tr, err := NewTree(WithFanout(c.maxSize), WithMinFill(c.minFill))
+		if err != nil {
+			t.Fatalf("error creating tree: %+v", err)
+		}
+
+		if tr.cfg.minLeafKeys != c.minLeafKeys || tr.cfg.minChildren != c.minChildren {
+			t.Fatalf("max size %d, min fill %v: expect min leaf keys %d and min children %d but got %d and %d",
+				c.maxSize, c.minFill, c.minLeafKeys, c.minChildren, tr.cfg.minLeafKeys, tr.cfg.minChildren)
+		}
+
+		for i := int64(0); i < 200; i++ {
+			tr.Insert(&Entry{key: i, pointer: i})
+		}
+
+		for i := int64(0); i < 200; i += 2 {
+			if err := tr.Delete(i); err != nil {
+				t.Fatalf("error deleting key %d: %+v", i, err)
+			}
+
+			if err := tr.Check(); err != nil {
+				t.Fatalf("max size %d, min fill %v: %+v", c.maxSize, c.minFill, err)
+			}
+		}
+	}
+}
+
+func TestComparator(t *testing.T) {
+	reverse := func(a, b int64) int {
+		if a > b {
+			return -1
+		} else if a < b {
+			return 1
+		}
+		return 0
+	}
+
+	tr, _ := NewTree(WithFanout(4), WithComparator(reverse))
+	for i := int64(0); i < 50; i++ {
+		if err := tr.Insert(&Entry{key: i, pointer: i}); err != nil {
+			t.Fatalf("error inserting key %d: %+v", i, err)
+		}
+	}
+
+	if err := tr.Check(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if v, err := tr.Find(7); err != nil || v != int64(7) {
+		t.Fatalf("expect value 7 but got %v, %+v", v, err)
+	}
+
+	var keys []int64
+	tr.Range(20, 15, func(key int64, value interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+
+	if want := []int64{20, 19, 18, 17, 16, 15}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("expect keys %+v but got %+v", want, keys)
+	}
+
+	for i := int64(0); i < 50; i += 3 {
+		if err := tr.Delete(i); err != nil {
+			t.Fatalf("error deleting key %d: %+v", i, err)
+		}
+
+		if err := tr.Check(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "tree.bpt")
+	if err := tr.SaveFile(path, nil); !errors.Is(err, ErrCustomOrder) {
+		t.Fatalf("expect ErrCustomOrder but got %+v", err)
+	}
+
+	if _, err := tr.WriteTo(bytes.NewBuffer(nil)); !errors.Is(err, ErrCustomOrder) {
+		t.Fatalf("expect ErrCustomOrder but got %+v", err)
+	}
+}
+
+func TestDupPolicy(t *testing.T) {
+	cases := []struct {
+		policy DupPolicy
+		err    error
+		want   interface{}
+	}{
+		{DupError, ErrDupKey, "old"},
+		{DupReplace, nil, "new"},
+		{DupIgnore, nil, "old"},
+	}
+
+	for _, c := range cases {
+		tr, _ := NewTree(WithFanout(3), WithDupPolicy(c.policy))
+		for i := int64(0); i < 10; i++ {
+			tr.Insert(&Entry{key: i, pointer: "old"})
+		}
+
+		if err := tr.Insert(&Entry{key: 5, pointer: "new"}); !errors.Is(err, c.err) {
+			t.Fatalf("policy %s: expect error %v but got %+v", c.policy, c.err, err)
+		}
+
+		if v, _ := tr.Find(5); v != c.want || tr.Len() != 10 {
+			t.Fatalf("policy %s: expect value %v and 10 keys but got %v and %d keys", c.policy, c.want, v, tr.Len())
+		}
+	}
+}
+
+type recordLogger struct {
+	lines []string
+}
+
+func (l *recordLogger) Debugf(format string, args ...interface{}) {
+	l.lines = append(l.lines, "D "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordLogger) Infof(format string, args ...interface{}) {
+	l.lines = append(l.lines, "I "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordLogger) Errorf(format string, args ...interface{}) {
+	l.lines = append(l.lines, "E "+fmt.Sprintf(format, args...))
+}
+
+func TestLoggerOption(t *testing.T) {
+	l := &recordLogger{}
+	tr, _ := NewTree(WithFanout(3), WithLogger(l))
+	for i := int64(0); i < 5; i++ {
+		tr.Insert(&Entry{key: i, pointer: i})
+	}
+
+	if len(l.lines) == 0 || !strings.HasPrefix(l.lines[0], "D ") {
+		t.Fatalf("expect debug logs but got %+v", l.lines)
+	}
+}
+
+type levelLogger struct {
+	recordLogger
+	debug bool
+}
+
+func (l *levelLogger) DebugEnabled() bool {
+	return l.debug
+}
+
+func TestDebugLogger(t *testing.T) {
+	l := &levelLogger{}
+	tr, _ := NewTree(WithFanout(3), WithLogger(l))
+	for i := int64(0); i < 5; i++ {
+		tr.Insert(&Entry{key: i, pointer: i})
+	}
+
+	// debug messages are not even built
+	if len(l.lines) != 0 {
+		t.Fatalf("expect no debug logs but got %+v", l.lines)
+	}
+
+	l.debug = true
+	for i := int64(5); i < 10; i++ {
+		tr.Insert(&Entry{key: i, pointer: i})
+	}
+
+	if len(l.lines) == 0 || !strings.HasPrefix(l.lines[0], "D ") {
+		t.Fatalf("expect debug logs but got %+v", l.lines)
+	}
+}
+
+func TestObserverOption(t *testing.T) {
+	obs := &eventLog{}
+	tr, _ := NewTree(WithFanout(3), WithObserver(obs))
+	for i := int64(0); i < 3; i++ {
+		tr.Insert(&Entry{key: i, pointer: i})
+	}
+
+	if len(obs.events) == 0 {
+		t.Fatalf("expect observer events but got none")
+	}
+}
+
+func TestPrealloc(t *testing.T) {
+	tr, _ := NewTree(WithFanout(4), WithPrealloc(100))
+	slab := len(tr.cfg.keySlab)
+	if slab == 0 {
+		t.Fatalf("expect preallocated room but got none")
+	}
+
+	for i := int64(0); i < 100; i++ {
+		tr.Insert(&Entry{key: i, pointer: i})
+	}
+
+	if err := tr.Check(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if len(tr.cfg.keySlab) >= slab {
+		t.Fatalf("expect nodes carved out of preallocated room")
+	}
+}
+
+func TestCapacities(t *testing.T) {
+	cases := []struct {
+		leafCap, innerCap int
+	}{
+		{2, 3},
+		{2, 16},
+		{3, 8},
+		{16, 3},
+		{7, 7},
+	}
+
+	for _, c := range cases {
+		tr, err := NewTree(WithLeafCapacity(c.leafCap), WithInternalCapacity(c.innerCap))
+		if err != nil {
+			t.Fatalf("error creating tree: %+v", err)
+		}
+
+		r := rand.New(rand.NewSource(int64(c.leafCap*100 + c.innerCap)))
+		for i := 0; i < 2000; i++ {
+			key := r.Int63n(300)
+			if r.Intn(3) == 0 {
+				tr.Delete(key)
+			} else {
+				tr.Insert(&Entry{key: key, pointer: key})
+			}
+
+			if err := tr.Check(); err != nil {
+				t.Fatalf("leaf capacity %d, internal capacity %d: %+v", c.leafCap, c.innerCap, err)
+			}
+		}
+
+		st := tr.Stats()
+		if st.LeafCapacity != c.leafCap || st.InternalCapacity != c.innerCap {
+			t.Fatalf("expect capacities %d and %d but got %d and %d", c.leafCap, c.innerCap, st.LeafCapacity, st.InternalCapacity)
+		}
+
+		path := saveTree(t, tr, nil)
+		ftr, err := OpenFile(path, nil)
+		if err != nil {
+			t.Fatalf("error opening file: %+v", err)
+		}
+
+		buf := bytes.NewBuffer(nil)
+		if _, err := tr.WriteTo(buf); err != nil {
+			t.Fatalf("error writing snapshot: %+v", err)
+		}
+
+		str := &BPlusTree{}
+		if _, err := str.ReadFrom(buf); err != nil {
+			t.Fatalf("error reading snapshot: %+v", err)
+		}
+
+		b, err := json.Marshal(tr)
+		if err != nil {
+			t.Fatalf("error encoding json: %+v", err)
+		}
+
+		jtr := &BPlusTree{}
+		if err := json.Unmarshal(b, jtr); err != nil {
+			t.Fatalf("error decoding json: %+v", err)
+		}
+
+		for _, ltr := range []*BPlusTree{ftr, str, jtr} {
+			if ltr.cfg.leafCap != c.leafCap || ltr.cfg.innerCap != c.innerCap {
+				t.Fatalf("expect capacities %d and %d but got %d and %d", c.leafCap, c.innerCap, ltr.cfg.leafCap, ltr.cfg.innerCap)
+			}
+
+			if err := ltr.Check(); err != nil {
+				t.Fatalf("%+v", err)
+			}
+		}
+	}
+
+	if _, err := NewTree(WithLeafCapacity(1)); err == nil || !strings.Contains(err.Error(), "leaf capacity should be at least 2: 1") {
+		t.Fatalf("expect leaf capacity error but got %+v", err)
+	}
+
+	if _, err := NewTree(WithInternalCapacity(2)); err == nil || !strings.Contains(err.Error(), "internal capacity should be at least 3: 2") {
+		t.Fatalf("expect internal capacity error but got %+v", err)
+	}
+}
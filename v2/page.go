@@ -0,0 +1,616 @@
+package v2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"math/bits"
+)
+
+// On disk layout of a persisted tree. Every node occupies one or more
+// contiguous fixed size pages, all integers are little endian.
+//
+// page header(24 bytes):
+//
+//	0  crc32c over header[4:] and payload
+//	4  page type
+//	5  format version
+//	6  reserved
+//	8  span, number of pages occupied
+//	12 payload length
+//	16 page id
+//
+// meta payload(page 0):
+//
+//	magic(8) | page size(4) | max size(4) | root(8) | count(8) | pages(8) | leaf capacity(4) | min fill(8) | codec name
+//
+// max size is the internal capacity, min fill is the float64 bits of
+// the fill factor. Format version 1 has no leaf capacity in meta,
+// leaves hold at most max size - 1 keys. Format version 3 or earlier
+// has no min fill, which defaults to DefaultMinFill.
+// leaf payload:
+//
+//	next leaf(8) | n(4) | key width(1) | flags(1) | reserved(2) | key prefix(8) | keys(n*width) | value offsets((n+1)*4) | values
+//
+// internal payload(keys[0] is unused):
+//
+//	n(4) | key width(1) | flags(1) | low bytes(1) | reserved(1) | key prefix(8) | keys(n*width) | children(n*8)
+//
+// Flag nodeAppended marks a node started almost empty by an append
+// split, flags are zero in format version 3 or earlier.
+// Keys of a node share their leading bytes, which are kept in key prefix
+// with the rest zeroed, and every key stores only its low width bytes.
+// Format version 2 or earlier has zero key width and no key prefix, keys
+// take 8 bytes each. Keys of an internal node may also share trailing
+// zero bytes, low bytes of them are dropped and the width bytes above
+// are stored. Low bytes is zero in format version 4 or earlier.
+const (
+	formatVersion = 5
+
+	pageHeaderSize  = 24
+	defaultPageSize = 4096
+	minPageSize     = 512
+
+	pageTypeMeta     = 1
+	pageTypeInternal = 2
+	pageTypeLeaf     = 3
+
+	metaFixedSize   = 52
+	metaFixedSizeV3 = 44
+	metaFixedSizeV1 = 40
+	leafFixedSize   = 16
+	nodeFixedSize   = 8
+
+	nodeAppended = 1
+)
+
+var fileMagic = []byte("BPTREEv2")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var ErrUnsupportedVersion error = fmt.Errorf("unsupported file format version")
+
+// ErrChecksumMismatch is returned when the checksum stored in a page
+// does not match its content, e.g. after a torn write.
+type ErrChecksumMismatch struct {
+	PageID uint64
+	Want   uint32
+	Got    uint32
+}
+
+func (e *ErrChecksumMismatch) Error() string {
+	return fmt.Sprintf("checksum mismatch on page %d: want %#08x but got %#08x", e.PageID, e.Want, e.Got)
+}
+
+// ErrCorruptPage is returned when a page passes checksum verification
+// but its content is not a legal tree node.
+type ErrCorruptPage struct {
+	PageID uint64
+	Reason string
+}
+
+func (e *ErrCorruptPage) Error() string {
+	return fmt.Sprintf("corrupt page %d: %s", e.PageID, e.Reason)
+}
+
+func corruptPage(id uint64, format string, args ...interface{}) error {
+	return &ErrCorruptPage{PageID: id, Reason: fmt.Sprintf(format, args...)}
+}
+
+// pageSpan returns number of pages needed to hold payload of size sz
+func pageSpan(sz int, pageSize int) int {
+	return (pageHeaderSize + sz + pageSize - 1) / pageSize
+}
+
+// framePage wraps payload with page header and pads it to page boundary
+func framePage(id uint64, typ byte, payload []byte, pageSize int) []byte {
+	span := pageSpan(len(payload), pageSize)
+	buf := make([]byte, span*pageSize)
+	buf[4] = typ
+	buf[5] = formatVersion
+	binary.LittleEndian.PutUint32(buf[8:], uint32(span))
+	binary.LittleEndian.PutUint32(buf[12:], uint32(len(payload)))
+	binary.LittleEndian.PutUint64(buf[16:], id)
+	copy(buf[pageHeaderSize:], payload)
+
+	crc := crc32.Checksum(buf[4:pageHeaderSize+len(payload)], crcTable)
+	binary.LittleEndian.PutUint32(buf, crc)
+	return buf
+}
+
+// pageSource gives access to raw bytes of a persisted tree
+type pageSource interface {
+	// slice returns n bytes at offset off, returned bytes must not
+	// be modified
+	slice(off int64, n int) ([]byte, error)
+}
+
+type readerAtSource struct {
+	r io.ReaderAt
+}
+
+func (s readerAtSource) slice(off int64, n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := s.r.ReadAt(buf, off); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+type bytesSource []byte
+
+func (s bytesSource) slice(off int64, n int) ([]byte, error) {
+	if off < 0 || off+int64(n) > int64(len(s)) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return s[off : off+int64(n)], nil
+}
+
+type page struct {
+	id      uint64
+	typ     byte
+	version byte
+	span    int
+	payload []byte
+}
+
+// readPage reads page id from src and verifies its checksum and header,
+// pages is the number of pages in src. Meta page is read with zero
+// pageSize and pages since both are unknown before it is decoded.
+func readPage(src pageSource, id uint64, pageSize int, pages uint64) (*page, error) {
+	off := int64(id) * int64(pageSize)
+	hdr, err := src.slice(off, pageHeaderSize)
+	if err != nil {
+		return nil, corruptPage(id, "error reading header: %v", err)
+	}
+
+	span := int(binary.LittleEndian.Uint32(hdr[8:]))
+	sz := int(binary.LittleEndian.Uint32(hdr[12:]))
+	if pageSize == 0 {
+		// meta page always fits in a page of minimal size
+		if pageHeaderSize+sz > minPageSize {
+			return nil, corruptPage(id, "illegal meta payload size %d", sz)
+		}
+	} else if span < 1 || id+uint64(span) > pages || pageHeaderSize+sz > span*pageSize {
+		return nil, corruptPage(id, "illegal span %d for payload of size %d", span, sz)
+	}
+
+	buf, err := src.slice(off, pageHeaderSize+sz)
+	if err != nil {
+		return nil, corruptPage(id, "error reading payload: %v", err)
+	}
+
+	want := binary.LittleEndian.Uint32(buf)
+	got := crc32.Checksum(buf[4:], crcTable)
+	if want != got {
+		return nil, &ErrChecksumMismatch{PageID: id, Want: want, Got: got}
+	}
+
+	if buf[5] < 1 || buf[5] > formatVersion {
+		return nil, fmt.Errorf("page %d: %w: %d", id, ErrUnsupportedVersion, buf[5])
+	}
+
+	if pid := binary.LittleEndian.Uint64(buf[16:]); pid != id {
+		return nil, corruptPage(id, "page claims to be page %d", pid)
+	}
+
+	return &page{
+		id:      id,
+		typ:     buf[4],
+		version: buf[5],
+		span:    span,
+		payload: buf[pageHeaderSize:],
+	}, nil
+}
+
+// check validates layout of node page
+func (p *page) check() error {
+	switch p.typ {
+	case pageTypeLeaf:
+		return leafPage(p.payload).check(p.id)
+	case pageTypeInternal:
+		return internalPage(p.payload).check(p.id)
+	}
+
+	return corruptPage(p.id, "unexpected page type %d", p.typ)
+}
+
+type fileMeta struct {
+	pageSize int
+	maxSize  int
+	leafCap  int
+	minFill  float64
+	root     uint64
+	count    int
+	pages    uint64
+	codec    string
+}
+
+func (m *fileMeta) encode() []byte {
+	buf := make([]byte, metaFixedSize+len(m.codec))
+	copy(buf, fileMagic)
+	binary.LittleEndian.PutUint32(buf[8:], uint32(m.pageSize))
+	binary.LittleEndian.PutUint32(buf[12:], uint32(m.maxSize))
+	binary.LittleEndian.PutUint64(buf[16:], m.root)
+	binary.LittleEndian.PutUint64(buf[24:], uint64(m.count))
+	binary.LittleEndian.PutUint64(buf[32:], m.pages)
+	binary.LittleEndian.PutUint32(buf[40:], uint32(m.leafCap))
+	binary.LittleEndian.PutUint64(buf[44:], math.Float64bits(m.minFill))
+	copy(buf[metaFixedSize:], m.codec)
+	return buf
+}
+
+// readMeta reads meta page, page size is unknown until meta is decoded
+func readMeta(src pageSource) (*fileMeta, error) {
+	p, err := readPage(src, 0, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.typ != pageTypeMeta {
+		return nil, corruptPage(0, "expect meta page but got page type %d", p.typ)
+	}
+
+	b := p.payload
+	fixed := metaFixedSize
+	switch {
+	case p.version == 1:
+		fixed = metaFixedSizeV1
+	case p.version <= 3:
+		fixed = metaFixedSizeV3
+	}
+
+	if len(b) < fixed || string(b[:8]) != string(fileMagic) {
+		return nil, corruptPage(0, "bad magic")
+	}
+
+	m := &fileMeta{
+		pageSize: int(binary.LittleEndian.Uint32(b[8:])),
+		maxSize:  int(binary.LittleEndian.Uint32(b[12:])),
+		root:     binary.LittleEndian.Uint64(b[16:]),
+		count:    int(binary.LittleEndian.Uint64(b[24:])),
+		pages:    binary.LittleEndian.Uint64(b[32:]),
+		codec:    string(b[fixed:]),
+	}
+
+	if p.version == 1 {
+		m.leafCap = m.maxSize - 1
+	} else {
+		m.leafCap = int(binary.LittleEndian.Uint32(b[40:]))
+	}
+
+	m.minFill = DefaultMinFill
+	if p.version > 3 {
+		m.minFill = math.Float64frombits(binary.LittleEndian.Uint64(b[44:]))
+	}
+
+	if m.pageSize < minPageSize || m.maxSize < 3 || m.leafCap < 2 || !(m.minFill > 0 && m.minFill <= DefaultMinFill) || m.root == 0 || m.root >= m.pages {
+		return nil, corruptPage(0, "illegal meta: %+v", *m)
+	}
+
+	return m, nil
+}
+
+// leafPage decodes leaf payload in place
+type leafPage []byte
+
+func (lp leafPage) next() uint64 {
+	return binary.LittleEndian.Uint64(lp)
+}
+
+func (lp leafPage) size() int {
+	return int(binary.LittleEndian.Uint32(lp[8:]))
+}
+
+func (lp leafPage) appended() bool {
+	return lp[13]&nodeAppended != 0
+}
+
+// keyLayout returns prefix, offset and width of keys
+func (lp leafPage) keyLayout() (uint64, int, int) {
+	if w := int(lp[12]); w != 0 {
+		return binary.LittleEndian.Uint64(lp[leafFixedSize:]), leafFixedSize + 8, w
+	}
+
+	return 0, leafFixedSize, 8
+}
+
+func (lp leafPage) key(i int) int64 {
+	prefix, off, w := lp.keyLayout()
+	return int64(prefix | getSuffix(lp[off+i*w:], w))
+}
+
+// offsets returns offset of value offsets
+func (lp leafPage) offsets() int {
+	_, off, w := lp.keyLayout()
+	return off + lp.size()*w
+}
+
+func (lp leafPage) value(i int) []byte {
+	n := lp.size()
+	offs := lp.offsets()
+	vals := offs + (n+1)*4
+	s := binary.LittleEndian.Uint32(lp[offs+i*4:])
+	e := binary.LittleEndian.Uint32(lp[offs+(i+1)*4:])
+	return lp[vals+int(s) : vals+int(e)]
+}
+
+// findInsertPos find smallest index such that key(index) >= key
+func (lp leafPage) findInsertPos(key int64) int {
+	s, e := 0, lp.size()
+	for s < e {
+		m := (s + e) / 2
+		if lp.key(m) >= key {
+			e = m
+		} else {
+			s = m + 1
+		}
+	}
+
+	return s
+}
+
+// check validates leaf layout so that accessors never go out of bounds
+func (lp leafPage) check(id uint64) error {
+	if len(lp) < leafFixedSize {
+		return corruptPage(id, "leaf payload too short: %d", len(lp))
+	}
+
+	if w := int(lp[12]); w > 8 || w != 0 && len(lp) < leafFixedSize+8 {
+		return corruptPage(id, "illegal key width %d of leaf payload of size %d", w, len(lp))
+	}
+
+	n := lp.size()
+	if n < 0 || n > len(lp) {
+		return corruptPage(id, "leaf of %d entries overflows payload of size %d", n, len(lp))
+	}
+
+	offs := lp.offsets()
+	vals := offs + (n+1)*4
+	if vals > len(lp) {
+		return corruptPage(id, "leaf of %d entries overflows payload of size %d", n, len(lp))
+	}
+
+	prev := uint32(0)
+	for i := 0; i <= n; i++ {
+		off := binary.LittleEndian.Uint32(lp[offs+i*4:])
+		if off < prev || vals+int(off) > len(lp) {
+			return corruptPage(id, "illegal value offset %d of entry %d", off, i)
+		}
+		prev = off
+	}
+
+	for i := 1; i < n; i++ {
+		if lp.key(i) <= lp.key(i-1) {
+			return corruptPage(id, "unordered keys %d and %d", lp.key(i-1), lp.key(i))
+		}
+	}
+
+	return nil
+}
+
+func encodeLeafPage(tn *tNode, codec ValueCodec) ([]byte, error) {
+	n := len(tn.keys) - 1
+	vals := make([][]byte, n)
+	sz := 0
+	for i := 0; i < n; i++ {
+		v, err := codec.Encode(tn.ptrs[i])
+		if err != nil {
+			return nil, fmt.Errorf("error encoding value of key %d: %w", tn.keys[i], err)
+		}
+		vals[i] = v
+		sz += len(v)
+	}
+
+	prefix, w := keyPrefix(tn.keys[:n])
+	offs := leafFixedSize + 8 + n*w
+	start := offs + (n+1)*4
+	buf := make([]byte, start+sz)
+	binary.LittleEndian.PutUint32(buf[8:], uint32(n))
+	buf[12] = byte(w)
+	if tn.appended {
+		buf[13] = nodeAppended
+	}
+	binary.LittleEndian.PutUint64(buf[leafFixedSize:], prefix)
+	off := 0
+	for i := 0; i < n; i++ {
+		putSuffix(buf[leafFixedSize+8+i*w:], tn.keys[i], w)
+		binary.LittleEndian.PutUint32(buf[offs+i*4:], uint32(off))
+		copy(buf[start+off:], vals[i])
+		off += len(vals[i])
+	}
+	binary.LittleEndian.PutUint32(buf[offs+n*4:], uint32(off))
+
+	return buf, nil
+}
+
+// internalPage decodes internal payload in place
+type internalPage []byte
+
+func (ip internalPage) size() int {
+	return int(binary.LittleEndian.Uint32(ip))
+}
+
+func (ip internalPage) appended() bool {
+	return ip[5]&nodeAppended != 0
+}
+
+// keyLayout returns prefix, offset and width of keys
+func (ip internalPage) keyLayout() (uint64, int, int) {
+	if w := int(ip[4]); w != 0 {
+		return binary.LittleEndian.Uint64(ip[nodeFixedSize:]), nodeFixedSize + 8, w
+	}
+
+	return 0, nodeFixedSize, 8
+}
+
+// low returns number of trailing zero bytes dropped from keys
+func (ip internalPage) low() int {
+	return int(ip[6])
+}
+
+func (ip internalPage) key(i int) int64 {
+	prefix, off, w := ip.keyLayout()
+	return int64(prefix | getSuffix(ip[off+i*w:], w)<<(8*ip.low()))
+}
+
+func (ip internalPage) child(i int) uint64 {
+	_, off, w := ip.keyLayout()
+	return binary.LittleEndian.Uint64(ip[off+ip.size()*w+i*8:])
+}
+
+// findChild returns index of child in which key resides
+func (ip internalPage) findChild(key int64) int {
+	s, e := 1, ip.size()
+	for s < e {
+		m := (s + e) / 2
+		if ip.key(m) > key {
+			e = m
+		} else {
+			s = m + 1
+		}
+	}
+
+	return s - 1
+}
+
+func (ip internalPage) check(id uint64) error {
+	if len(ip) < nodeFixedSize {
+		return corruptPage(id, "internal payload too short: %d", len(ip))
+	}
+
+	if w := int(ip[4]); w > 8 || w != 0 && len(ip) < nodeFixedSize+8 {
+		return corruptPage(id, "illegal key width %d of internal payload of size %d", w, len(ip))
+	}
+
+	if w, low := int(ip[4]), ip.low(); w == 0 && low != 0 || w+low > 8 {
+		return corruptPage(id, "illegal %d low bytes of keys of width %d", low, w)
+	}
+
+	n := ip.size()
+	_, off, w := ip.keyLayout()
+	if n < 1 || n > len(ip) || off+n*(w+8) != len(ip) {
+		return corruptPage(id, "internal node of %d children mismatches payload of size %d", n, len(ip))
+	}
+
+	for i := 2; i < n; i++ {
+		if ip.key(i) <= ip.key(i-1) {
+			return corruptPage(id, "unordered keys %d and %d", ip.key(i-1), ip.key(i))
+		}
+	}
+
+	return nil
+}
+
+func internalPageSize(keys []int64) int {
+	_, w, _ := internalKeyLayout(keys)
+	return nodeFixedSize + 8 + len(keys)*(w+8)
+}
+
+// encodeInternalPage encodes tn with keys in place of its own keys, which
+// are either the same or separators truncated by truncateSeparators
+func encodeInternalPage(buf []byte, tn *tNode, keys []int64, ids map[*tNode]uint64) {
+	n := len(keys)
+	prefix, w, low := internalKeyLayout(keys)
+	binary.LittleEndian.PutUint32(buf, uint32(n))
+	buf[4] = byte(w)
+	if tn.appended {
+		buf[5] = nodeAppended
+	}
+	buf[6] = byte(low)
+	binary.LittleEndian.PutUint64(buf[nodeFixedSize:], prefix)
+	off := nodeFixedSize + 8
+	for i, p := range tn.ptrs {
+		if i > 0 {
+			putSuffix(buf[off+i*w:], keys[i]>>(8*low), w)
+		}
+		binary.LittleEndian.PutUint64(buf[off+n*w+i*8:], ids[p.(*tNode)])
+	}
+}
+
+// internalKeyLayout returns key prefix, width and low bytes of keys of
+// an internal node, keys[0] is unused
+func internalKeyLayout(keys []int64) (uint64, int, int) {
+	prefix, w := keyPrefix(keys[1:])
+	// keep at least one byte per key
+	low := w - 1
+	for _, k := range keys[1:] {
+		if z := bits.TrailingZeros64(uint64(k)) / 8; z < low {
+			low = z
+		}
+	}
+
+	return prefix, w - low, low
+}
+
+// truncateSeparators returns keys of internal node tn with every
+// separator replaced by the key of most trailing zero bytes that still
+// parts the children around it, so that fewer bytes of it are stored
+func truncateSeparators(tn *tNode) []int64 {
+	keys := make([]int64, len(tn.keys))
+	for i := 1; i < len(keys); i++ {
+		left, right := tn.child(i-1), tn.child(i)
+		for !left.isLeaf {
+			left = left.child(len(left.keys) - 1)
+		}
+		for !right.isLeaf {
+			right = right.child(0)
+		}
+
+		keys[i] = shortestSeparator(left.keys[len(left.keys)-2], right.keys[0])
+	}
+
+	return keys
+}
+
+// shortestSeparator returns key in (lo, hi] of most trailing zero bytes
+func shortestSeparator(lo, hi int64) int64 {
+	for low := 7; low > 0; low-- {
+		if s := hi &^ (1<<(8*low) - 1); s > lo {
+			return s
+		}
+	}
+
+	return hi
+}
+
+// keyPrefix returns leading bytes shared by keys with the rest zeroed and
+// width of the rest in bytes, which is at least 1
+func keyPrefix(keys []int64) (uint64, int) {
+	if len(keys) == 0 {
+		return 0, 1
+	}
+
+	var diff uint64
+	for _, k := range keys[1:] {
+		diff |= uint64(k ^ keys[0])
+	}
+
+	w := (bits.Len64(diff) + 7) / 8
+	if w == 0 {
+		w = 1
+	}
+
+	return uint64(keys[0]) &^ (1<<(8*w) - 1), w
+}
+
+// putSuffix stores low w bytes of key to buf
+func putSuffix(buf []byte, key int64, w int) {
+	for i := 0; i < w; i++ {
+		buf[i] = byte(key >> (8 * i))
+	}
+}
+
+// getSuffix loads low w bytes of a key from buf
+func getSuffix(buf []byte, w int) uint64 {
+	var s uint64
+	for i := 0; i < w; i++ {
+		s |= uint64(buf[i]) << (8 * i)
+	}
+
+	return s
+}
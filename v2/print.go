@@ -7,7 +7,7 @@ import (
 )
 
 func (tr *BPlusTree) ToString() string {
-	if tr.root != nil && len(tr.root.entries) > 1 {
+	if tr.root != nil && len(tr.root.keys) > 1 {
 		return tr.root.ToString()
 	}
 
@@ -33,13 +33,13 @@ func (tn *tNode) ChildrenStr() string {
 		return "()"
 	}
 
-	keys := make([]string, 0, len(tn.entries)-1)
-	for i, k := range tn.entries {
-		if (tn.isLeaf && i == len(tn.entries)-1) || (!tn.isLeaf && i == 0) {
+	keys := make([]string, 0, len(tn.keys)-1)
+	for i, k := range tn.keys {
+		if (tn.isLeaf && i == len(tn.keys)-1) || (!tn.isLeaf && i == 0) {
 			continue
 		}
 
-		keys = append(keys, fmt.Sprintf("%d", k.key))
+		keys = append(keys, fmt.Sprintf("%d", k))
 	}
 
 	val := "(" + strings.Join(keys, ",") + ")"
@@ -51,8 +51,8 @@ func traverseTree(root *tNode) *pnode {
 	proot := &pnode{val: val}
 
 	if !root.isLeaf {
-		for _, c := range root.entries {
-			child := c.pointer.(*tNode)
+		for _, p := range root.ptrs {
+			child := p.(*tNode)
 			cp := traverseTree(child)
 			proot.size += cp.size
 			proot.children = append(proot.children, cp)
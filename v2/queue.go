@@ -0,0 +1,163 @@
+package v2
+
+import "fmt"
+
+// PeekMin returns the least key that has not expired
+func (tr *BPlusTree) PeekMin() (int64, interface{}, error) {
+	var now int64
+	if tr.ttl != nil {
+		now = tr.now()
+	}
+
+	for tn := tr.firstLeaf(); tn != nil; tn = tn.next() {
+		for i, k := range tn.keys[:len(tn.keys)-1] {
+			if !tr.expired(k, now) {
+				return k, tn.ptrs[i], nil
+			}
+		}
+	}
+
+	return 0, nil, ErrKeyNotFound
+}
+
+// PeekMax returns the greatest key that has not expired, it walks
+// backwards from the rightmost leaf like PeekMin walks forwards
+func (tr *BPlusTree) PeekMax() (int64, interface{}, error) {
+	var now int64
+	if tr.ttl != nil {
+		now = tr.now()
+	}
+
+	for tn := tr.rightmostLeaf(); tn != nil; tn = tn.prev() {
+		for i := len(tn.keys) - 2; i >= 0; i-- {
+			if !tr.expired(tn.keys[i], now) {
+				return tn.keys[i], tn.ptrs[i], nil
+			}
+		}
+	}
+
+	return 0, nil, ErrKeyNotFound
+}
+
+// PopMin removes and returns the least key that has not expired, it
+// goes straight to the leftmost leaf instead of searching for the key
+// from root twice like Find and Delete. Expired keys before it are
+// removed as well.
+func (tr *BPlusTree) PopMin() (int64, interface{}, error) {
+	return tr.pop(true)
+}
+
+// PopMax removes and returns the greatest key that has not expired like
+// PopMin does with the least one
+func (tr *BPlusTree) PopMax() (int64, interface{}, error) {
+	return tr.pop(false)
+}
+
+// pop removes the least key if min or the greatest one otherwise until
+// a key that has not expired is removed
+func (tr *BPlusTree) pop(min bool) (int64, interface{}, error) {
+	var now int64
+	if tr.ttl != nil {
+		now = tr.now()
+	}
+
+	// only the root leaf of an empty tree has no keys
+	for tr.size > 0 {
+		var leaf *tNode
+		var pos int
+		if min {
+			leaf, pos = tr.firstLeaf(), 0
+		} else {
+			leaf = tr.rightmostLeaf()
+			pos = len(leaf.keys) - 2
+		}
+
+		e := leaf.entry(pos)
+		if tr.rec != nil {
+			tr.rec.record(OpDelete, e.key, nil)
+		}
+
+		leaf.deleteEntryAt(pos)
+		tr.size--
+		tr.mergeUp(leaf)
+
+		expired := tr.expired(e.key, now)
+		tr.forgetTTL(e.key)
+		if !expired {
+			return e.key, e.pointer, nil
+		}
+	}
+
+	return 0, nil, ErrKeyNotFound
+}
+
+// Heap adapts a tree to heap.Interface, so that heap.Push and heap.Pop
+// of a slice based priority queue work on the tree instead. Items are
+// *Entry. The tree keeps its keys in order, so Less always reports
+// false and Swap does nothing to stop heap functions from moving items,
+// and index based heap.Fix and heap.Remove are not supported.
+type Heap struct {
+	tr  *BPlusTree
+	max bool
+}
+
+// NewMinHeap returns heap popping the least key of tr first
+func NewMinHeap(tr *BPlusTree) *Heap {
+	return &Heap{tr: tr}
+}
+
+// NewMaxHeap returns heap popping the greatest key of tr first
+func NewMaxHeap(tr *BPlusTree) *Heap {
+	return &Heap{tr: tr, max: true}
+}
+
+// Len returns number of entries in tree, which counts entries that may
+// have expired but are not removed yet. It has no side effects, Pop
+// removes expired entries it passes and returns nil if no entry is
+// left.
+func (h *Heap) Len() int {
+	return h.tr.Len()
+}
+
+func (h *Heap) Less(i, j int) bool {
+	return false
+}
+
+func (h *Heap) Swap(i, j int) {}
+
+// Push inserts x, which should be an *Entry, it panics if tree refuses
+// the key, e.g. a duplicate key under DupError
+func (h *Heap) Push(x interface{}) {
+	e := x.(*Entry)
+	if err := h.tr.Insert(e); err != nil {
+		panic(fmt.Sprintf("error pushing key %d: %v", e.key, err))
+	}
+}
+
+// Pop removes the least or greatest key that has not expired and
+// returns its *Entry, expired keys before it are removed as well. It
+// returns nil if no such key is left.
+func (h *Heap) Pop() interface{} {
+	k, v, err := h.tr.pop(!h.max)
+	if err != nil {
+		return nil
+	}
+
+	return NewEntry(k, v)
+}
+
+// Peek returns *Entry to be popped next without removing it, nil if
+// tree is empty
+func (h *Heap) Peek() *Entry {
+	peek := h.tr.PeekMin
+	if h.max {
+		peek = h.tr.PeekMax
+	}
+
+	k, v, err := peek()
+	if err != nil {
+		return nil
+	}
+
+	return NewEntry(k, v)
+}
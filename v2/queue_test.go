@@ -0,0 +1,213 @@
+package v2
+
+import (
+	"container/heap"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestPopPeek(t *testing.T) {
+	cases := [][]Option{
+		{WithFanout(3)},
+		{WithFanout(4), WithMinFill(0.25)},
+		{WithFanout(7), WithBStar()},
+		{WithFanout(32)},
+	}
+
+	for i, opts := range cases {
+		tr, _ := NewTree(opts...)
+		if _, _, err := tr.PopMin(); err != ErrKeyNotFound {
+			t.Fatalf("case %d: expect error %+v popping empty tree but got %+v", i, ErrKeyNotFound, err)
+		}
+
+		model := map[int64]bool{}
+		r := rand.New(rand.NewSource(int64(i)))
+		for step := 0; step < 4000; step++ {
+			sorted := make([]int64, 0, len(model))
+			for k := range model {
+				sorted = append(sorted, k)
+			}
+			sort.Slice(sorted, func(a, b int) bool { return sorted[a] < sorted[b] })
+
+			var want int64
+			if len(sorted) > 0 {
+				want = sorted[0]
+			}
+
+			var k int64
+			var v interface{}
+			var err error
+			switch op := r.Intn(5); {
+			case op < 2 || len(model) == 0:
+				key := r.Int63n(1000)
+				if tr.Insert(&Entry{key: key, pointer: key}) == nil {
+					model[key] = true
+				}
+				continue
+			case op == 2:
+				k, v, err = tr.PeekMin()
+				if pk, _, _ := tr.PeekMax(); pk != sorted[len(sorted)-1] {
+					t.Fatalf("case %d, step %d: expect max %d but got %d", i, step, sorted[len(sorted)-1], pk)
+				}
+			case op == 3:
+				k, v, err = tr.PopMin()
+				delete(model, want)
+			default:
+				want = sorted[len(sorted)-1]
+				k, v, err = tr.PopMax()
+				delete(model, want)
+			}
+
+			if err != nil || k != want || v != want {
+				t.Fatalf("case %d, step %d: expect key %d but got %d, %v, %+v", i, step, want, k, v, err)
+			}
+
+			if tr.Len() != len(model) {
+				t.Fatalf("case %d, step %d: expect %d keys but got %d", i, step, len(model), tr.Len())
+			}
+
+			if err := tr.Check(); err != nil {
+				t.Fatalf("case %d, step %d: %+v\n%s", i, step, err, tr.ToString())
+			}
+		}
+	}
+}
+
+func TestPopExpired(t *testing.T) {
+	tr, c := newTTLTree(t, WithFanout(3))
+	for k := int64(1); k <= 10; k++ {
+		tr.InsertWithTTL(k, int(k), time.Duration(k)*time.Second)
+	}
+
+	// keys 1 to 3 expire and are inserted again without deadline, keys
+	// 4 to 8 expire later on
+	c.advance(3 * time.Second)
+	for k := int64(1); k <= 3; k++ {
+		tr.Insert(&Entry{key: k, pointer: int(k)})
+	}
+	c.advance(5 * time.Second)
+	for k := int64(3); k >= 1; k-- {
+		tr.PopMin()
+	}
+
+	if k, _, err := tr.PeekMin(); err != nil || k != 9 {
+		t.Fatalf("expect min 9 but got %d, %+v", k, err)
+	}
+
+	// expired keys 4 to 8 are removed on the way
+	if k, _, err := tr.PopMin(); err != nil || k != 9 || tr.Len() != 1 {
+		t.Fatalf("expect min 9 popped leaving 1 key but got %d, %+v and %d keys", k, err, tr.Len())
+	}
+
+	tr.InsertWithTTL(11, 11, time.Second)
+	tr.InsertWithTTL(12, 12, time.Second)
+	c.advance(time.Second)
+	if k, _, err := tr.PeekMax(); err != nil || k != 10 {
+		t.Fatalf("expect max 10 but got %d, %+v", k, err)
+	}
+
+	if k, _, err := tr.PopMax(); err != nil || k != 10 || tr.Len() != 0 {
+		t.Fatalf("expect max 10 popped leaving no key but got %d, %+v and %d keys", k, err, tr.Len())
+	}
+
+	if _, _, err := tr.PeekMax(); err != ErrKeyNotFound {
+		t.Fatalf("expect error %+v peeking empty tree but got %+v", ErrKeyNotFound, err)
+	}
+}
+
+func TestHeap(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	keys := r.Perm(500)
+	for _, max := range []bool{false, true} {
+		tr, _ := NewTree(WithFanout(5))
+		h := NewMinHeap(tr)
+		if max {
+			h = NewMaxHeap(tr)
+		}
+
+		heap.Init(h)
+		for _, k := range keys {
+			heap.Push(h, NewEntry(int64(k), k))
+		}
+
+		for i := range keys {
+			want := int64(i)
+			if max {
+				want = int64(len(keys) - 1 - i)
+			}
+
+			if e := h.Peek(); e == nil || e.Key() != want {
+				t.Fatalf("expect key %d peeked but got %+v", want, e)
+			}
+
+			if e := heap.Pop(h).(*Entry); e.Key() != want || e.Value() != int(want) {
+				t.Fatalf("expect key %d popped but got %+v", want, e)
+			}
+		}
+
+		if h.Len() != 0 || h.Peek() != nil || h.Pop() != nil {
+			t.Fatalf("expect empty heap but got %d entries", h.Len())
+		}
+	}
+}
+
+func TestHeapExpired(t *testing.T) {
+	for _, max := range []bool{false, true} {
+		tr, c := newTTLTree(t, WithFanout(4))
+		h := NewMinHeap(tr)
+		if max {
+			h = NewMaxHeap(tr)
+		}
+
+		// keys 1 to 20 and 81 to 100 expire, a whole run of leaves at
+		// either end
+		for k := int64(1); k <= 100; k++ {
+			if k <= 20 || k > 80 {
+				tr.InsertWithTTL(k, int(k), time.Second)
+			} else {
+				tr.Insert(&Entry{key: k, pointer: int(k)})
+			}
+		}
+		c.advance(time.Second)
+
+		want := int64(21)
+		if max {
+			want = 80
+		}
+		if e := h.Peek(); e == nil || e.Key() != want {
+			t.Fatalf("expect key %d peeked but got %+v", want, e)
+		}
+
+		// Len leaves expired entries to Pop
+		if h.Len() != 100 || tr.Len() != 100 {
+			t.Fatalf("expect 100 entries but got %d in heap and %d in tree", h.Len(), tr.Len())
+		}
+
+		var popped []int64
+		for h.Len() > 0 {
+			if e, _ := heap.Pop(h).(*Entry); e != nil {
+				popped = append(popped, e.Key())
+			}
+		}
+
+		if len(popped) != 60 || popped[0] != want || tr.Len() != 0 {
+			t.Fatalf("expect 60 entries from key %d popped leaving none but got %v and %d", want, popped, tr.Len())
+		}
+	}
+}
+
+func BenchmarkPopMin(b *testing.B) {
+	tr, keys := benchTree(b, 32, 100000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k, v, err := tr.PopMin()
+		if err != nil {
+			b.Fatalf("error popping key: %+v", err)
+		}
+
+		tr.Insert(&Entry{key: k + int64(2*len(keys)), pointer: v})
+	}
+}
@@ -0,0 +1,316 @@
+package v2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+var ErrReadOnly error = fmt.Errorf("tree is read only")
+
+// ReadOnlyTree serves lookups from a file persisted by SaveFile. The
+// file is memory mapped and nodes are decoded in place, keys are never
+// copied out of the mapping. Values decoded by BytesCodec refer to the
+// mapping as well and must not be used after Close.
+type ReadOnlyTree struct {
+	pf   *pageFile
+	data []byte
+	// verified is a bitmap of pages that passed verification
+	verified    []uint32
+	verifyPages bool
+}
+
+// OpenReadOnly memory maps file at path for lookups, pages are verified
+// the first time they are read unless opts.VerifyOnOpen is set, in which
+// case all pages are verified up front.
+func OpenReadOnly(path string, opts *FileOptions) (*ReadOnlyTree, error) {
+	opts = opts.withDefaults()
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := mmapFile(f)
+	if err != nil {
+		return nil, err
+	}
+
+	pf, err := openPageFile(bytesSource(data), int64(len(data)), opts)
+	if err != nil {
+		munmapFile(data)
+		return nil, err
+	}
+
+	rt := &ReadOnlyTree{
+		pf:          pf,
+		data:        data,
+		verifyPages: !opts.VerifyOnOpen,
+	}
+
+	if rt.verifyPages {
+		rt.verified = make([]uint32, (pf.meta.pages+31)/32)
+	}
+
+	return rt, nil
+}
+
+// Close unmaps the underlying file
+func (rt *ReadOnlyTree) Close() error {
+	data := rt.data
+	rt.data = nil
+	if data == nil {
+		return nil
+	}
+
+	return munmapFile(data)
+}
+
+// Len returns number of keys in tree
+func (rt *ReadOnlyTree) Len() int {
+	return rt.pf.meta.count
+}
+
+func (rt *ReadOnlyTree) Insert(e *Entry) error {
+	return ErrReadOnly
+}
+
+func (rt *ReadOnlyTree) Delete(key int64) error {
+	return ErrReadOnly
+}
+
+// page returns payload of page id, verifying it on first access
+func (rt *ReadOnlyTree) page(id uint64) (byte, []byte, error) {
+	if rt.data == nil {
+		return 0, nil, fmt.Errorf("read only tree is closed")
+	}
+
+	if id == 0 || id >= rt.pf.meta.pages {
+		return 0, nil, corruptPage(id, "page id out of range [1, %d)", rt.pf.meta.pages)
+	}
+
+	src := bytesSource(rt.data)
+	pageSize := rt.pf.meta.pageSize
+	if !rt.verifyPages || rt.isVerified(id) {
+		off := int64(id) * int64(pageSize)
+		sz := int(binary.LittleEndian.Uint32(src[off+12:]))
+		return src[off+4], src[off+pageHeaderSize : off+pageHeaderSize+int64(sz)], nil
+	}
+
+	p, err := rt.pf.readPage(id)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if err := p.check(); err != nil {
+		return 0, nil, err
+	}
+
+	rt.setVerified(id)
+	return p.typ, p.payload, nil
+}
+
+func (rt *ReadOnlyTree) setVerified(id uint64) {
+	addr := &rt.verified[id/32]
+	for {
+		old := atomic.LoadUint32(addr)
+		if atomic.CompareAndSwapUint32(addr, old, old|1<<(id%32)) {
+			return
+		}
+	}
+}
+
+func (rt *ReadOnlyTree) isVerified(id uint64) bool {
+	return atomic.LoadUint32(&rt.verified[id/32])&(1<<(id%32)) != 0
+}
+
+func (rt *ReadOnlyTree) leaf(id uint64) (leafPage, error) {
+	typ, payload, err := rt.page(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if typ != pageTypeLeaf {
+		return nil, corruptPage(id, "expect leaf page but got page type %d", typ)
+	}
+
+	return leafPage(payload), nil
+}
+
+// findLeaf returns page id of leaf in which key resides, left is the
+// root of nearest subtree on the left of search path, zero if none
+func (rt *ReadOnlyTree) findLeaf(key int64) (id uint64, left uint64, err error) {
+	id = rt.pf.meta.root
+	for depth := 0; ; depth++ {
+		typ, payload, err := rt.page(id)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		if typ == pageTypeLeaf {
+			return id, left, nil
+		}
+
+		if typ != pageTypeInternal || depth > 64 {
+			return 0, 0, corruptPage(id, "unexpected page type %d at depth %d", typ, depth)
+		}
+
+		ip := internalPage(payload)
+		pos := ip.findChild(key)
+		if pos > 0 {
+			left = ip.child(pos - 1)
+		}
+		id = ip.child(pos)
+	}
+}
+
+func (rt *ReadOnlyTree) value(lp leafPage, pos int) (interface{}, error) {
+	return rt.pf.codec.Decode(lp.value(pos))
+}
+
+func (rt *ReadOnlyTree) Find(key int64) (interface{}, error) {
+	id, _, err := rt.findLeaf(key)
+	if err != nil {
+		return nil, err
+	}
+
+	lp, err := rt.leaf(id)
+	if err != nil {
+		return nil, err
+	}
+
+	pos := lp.findInsertPos(key)
+	if pos >= lp.size() || lp.key(pos) != key {
+		return nil, ErrKeyNotFound
+	}
+
+	return rt.value(lp, pos)
+}
+
+// Range calls fn for every key in [lo, hi] in ascending order, it
+// stops as soon as fn returns false.
+func (rt *ReadOnlyTree) Range(lo, hi int64, fn func(key int64, value interface{}) bool) error {
+	id, _, err := rt.findLeaf(lo)
+	if err != nil {
+		return err
+	}
+
+	lp, err := rt.leaf(id)
+	if err != nil {
+		return err
+	}
+
+	pos := lp.findInsertPos(lo)
+	for {
+		for ; pos < lp.size(); pos++ {
+			k := lp.key(pos)
+			if k > hi {
+				return nil
+			}
+
+			v, err := rt.value(lp, pos)
+			if err != nil {
+				return err
+			}
+
+			if !fn(k, v) {
+				return nil
+			}
+		}
+
+		if lp.next() == 0 {
+			return nil
+		}
+
+		if lp, err = rt.leaf(lp.next()); err != nil {
+			return err
+		}
+		pos = 0
+	}
+}
+
+// Floor returns the greatest key less than or equal to key
+func (rt *ReadOnlyTree) Floor(key int64) (int64, interface{}, error) {
+	id, left, err := rt.findLeaf(key)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	lp, err := rt.leaf(id)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	pos := lp.findInsertPos(key)
+	if pos < lp.size() && lp.key(pos) == key {
+		v, err := rt.value(lp, pos)
+		return key, v, err
+	}
+
+	if pos == 0 {
+		if left == 0 {
+			return 0, nil, ErrKeyNotFound
+		}
+
+		// descend to the rightmost leaf of left
+		id = left
+		for depth := 0; ; depth++ {
+			typ, payload, err := rt.page(id)
+			if err != nil {
+				return 0, nil, err
+			}
+
+			if typ == pageTypeLeaf {
+				break
+			}
+
+			if typ != pageTypeInternal || depth > 64 {
+				return 0, nil, corruptPage(id, "unexpected page type %d at depth %d", typ, depth)
+			}
+
+			ip := internalPage(payload)
+			id = ip.child(ip.size() - 1)
+		}
+
+		if lp, err = rt.leaf(id); err != nil {
+			return 0, nil, err
+		}
+		pos = lp.size()
+	}
+
+	if pos == 0 {
+		return 0, nil, corruptPage(id, "unexpected empty leaf")
+	}
+
+	v, err := rt.value(lp, pos-1)
+	return lp.key(pos - 1), v, err
+}
+
+// Ceiling returns the least key greater than or equal to key
+func (rt *ReadOnlyTree) Ceiling(key int64) (int64, interface{}, error) {
+	id, _, err := rt.findLeaf(key)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	lp, err := rt.leaf(id)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	pos := lp.findInsertPos(key)
+	for pos >= lp.size() {
+		if lp.next() == 0 {
+			return 0, nil, ErrKeyNotFound
+		}
+
+		if lp, err = rt.leaf(lp.next()); err != nil {
+			return 0, nil, err
+		}
+		pos = 0
+	}
+
+	v, err := rt.value(lp, pos)
+	return lp.key(pos), v, err
+}
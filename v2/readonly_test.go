@@ -0,0 +1,121 @@
+package v2
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestReadOnlyLookup(t *testing.T) {
+	for _, maxSize := range []int{3, 4, 16} {
+		tr, _ := NewTree(WithFanout(maxSize))
+		r := rand.New(rand.NewSource(int64(maxSize)))
+		for i := 0; i < 500; i++ {
+			key := r.Int63n(2000) - 1000
+			tr.Insert(&Entry{key: key, pointer: int(key)})
+		}
+
+		path := saveTree(t, tr, nil)
+		rt, err := OpenReadOnly(path, nil)
+		if err != nil {
+			t.Fatalf("error opening read only tree: %+v", err)
+		}
+		defer rt.Close()
+
+		if rt.Len() != tr.Len() {
+			t.Fatalf("expect %d keys but got %d", tr.Len(), rt.Len())
+		}
+
+		for key := int64(-1100); key <= 1100; key++ {
+			wv, werr := tr.Find(key)
+			v, err := rt.Find(key)
+			if err != werr || !reflect.DeepEqual(v, wv) {
+				t.Fatalf("find key %d, expect (%+v, %+v) but got (%+v, %+v)", key, wv, werr, v, err)
+			}
+
+			wk, wv, werr := tr.Floor(key)
+			k, v, err := rt.Floor(key)
+			if k != wk || err != werr || !reflect.DeepEqual(v, wv) {
+				t.Fatalf("floor of key %d, expect (%d, %+v, %+v) but got (%d, %+v, %+v)", key, wk, wv, werr, k, v, err)
+			}
+
+			wk, wv, werr = tr.Ceiling(key)
+			k, v, err = rt.Ceiling(key)
+			if k != wk || err != werr || !reflect.DeepEqual(v, wv) {
+				t.Fatalf("ceiling of key %d, expect (%d, %+v, %+v) but got (%d, %+v, %+v)", key, wk, wv, werr, k, v, err)
+			}
+		}
+
+		for i := 0; i < 100; i++ {
+			lo := r.Int63n(2200) - 1100
+			hi := lo + r.Int63n(300)
+			var wkeys, keys []int64
+			tr.Range(lo, hi, func(key int64, value interface{}) bool {
+				wkeys = append(wkeys, key)
+				return true
+			})
+			if err := rt.Range(lo, hi, func(key int64, value interface{}) bool {
+				if value.(int) != int(key) {
+					t.Fatalf("expect value %d but got %+v", key, value)
+				}
+				keys = append(keys, key)
+				return true
+			}); err != nil {
+				t.Fatalf("error scanning range [%d, %d]: %+v", lo, hi, err)
+			}
+
+			if !reflect.DeepEqual(keys, wkeys) {
+				t.Fatalf("range [%d, %d], expect keys %+v but got %+v", lo, hi, wkeys, keys)
+			}
+		}
+	}
+}
+
+func TestReadOnlyWrite(t *testing.T) {
+	tr := newTree(t, 4, 10, 1)
+	rt, err := OpenReadOnly(saveTree(t, tr, nil), &FileOptions{VerifyOnOpen: true})
+	if err != nil {
+		t.Fatalf("error opening read only tree: %+v", err)
+	}
+	defer rt.Close()
+
+	if err := rt.Insert(&Entry{key: 11, pointer: 11}); err != ErrReadOnly {
+		t.Fatalf("expect error %+v but got %+v", ErrReadOnly, err)
+	}
+
+	if err := rt.Delete(1); err != ErrReadOnly {
+		t.Fatalf("expect error %+v but got %+v", ErrReadOnly, err)
+	}
+}
+
+func TestReadOnlyNoAlloc(t *testing.T) {
+	tr := newTree(t, 8, 0, 0)
+	for i := 0; i < 1000; i++ {
+		tr.Insert(&Entry{key: int64(i * 2), pointer: []byte("value")})
+	}
+
+	rt, err := OpenReadOnly(saveTree(t, tr, &FileOptions{Codec: BytesCodec{}}), &FileOptions{Codec: BytesCodec{}})
+	if err != nil {
+		t.Fatalf("error opening read only tree: %+v", err)
+	}
+	defer rt.Close()
+
+	// warm up page verification
+	for i := 0; i < 2000; i++ {
+		rt.Find(int64(i))
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := rt.Find(501); err != ErrKeyNotFound {
+			t.Fatalf("expect error %+v but got %+v", ErrKeyNotFound, err)
+		}
+		rt.Range(100, 200, func(key int64, value interface{}) bool {
+			return true
+		})
+	})
+
+	// values are boxed into interface{}, nothing else is allocated
+	if allocs > 51 {
+		t.Fatalf("expect at most 51 allocations but got %f", allocs)
+	}
+}
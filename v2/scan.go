@@ -0,0 +1,129 @@
+package v2
+
+import "math"
+
+const (
+	minKey int64 = math.MinInt64
+	maxKey int64 = math.MaxInt64
+)
+
+// Range calls fn for every key in [lo, hi] in ascending order, it
+// stops as soon as fn returns false. Expired keys are skipped.
+func (tr *BPlusTree) Range(lo, hi int64, fn func(key int64, value interface{}) bool) {
+	var now int64
+	if tr.ttl != nil {
+		now = tr.now()
+	}
+
+	tn := tr.findLeaf(lo)
+	pos := tn.findLeafInsertPos(lo)
+	for tn != nil {
+		last := len(tn.keys) - 1
+		for ; pos < last; pos++ {
+			if tr.cfg.compare(tn.keys[pos], hi) > 0 {
+				return
+			}
+
+			if tr.expired(tn.keys[pos], now) {
+				continue
+			}
+
+			if !fn(tn.keys[pos], tn.ptrs[pos]) {
+				return
+			}
+		}
+
+		tn = tn.next()
+		pos = 0
+	}
+}
+
+// Floor returns the greatest key less than or equal to key that has not
+// expired
+func (tr *BPlusTree) Floor(key int64) (int64, interface{}, error) {
+	var now int64
+	if tr.ttl != nil {
+		now = tr.now()
+	}
+
+	tn := tr.findLeaf(key)
+	pos := tn.findLeafInsertPos(key)
+	if pos < len(tn.keys)-1 && tr.cfg.compare(tn.keys[pos], key) == 0 {
+		pos++
+	}
+
+	// keys before pos are less than or equal to key, expired ones are
+	// passed walking leaves backwards
+	for tn != nil {
+		for pos--; pos >= 0; pos-- {
+			if !tr.expired(tn.keys[pos], now) {
+				return tn.keys[pos], tn.ptrs[pos], nil
+			}
+		}
+
+		tn = tn.prev()
+		if tn != nil {
+			pos = len(tn.keys) - 1
+		}
+	}
+
+	return 0, nil, ErrKeyNotFound
+}
+
+// Ceiling returns the least key greater than or equal to key that has
+// not expired
+func (tr *BPlusTree) Ceiling(key int64) (int64, interface{}, error) {
+	var now int64
+	if tr.ttl != nil {
+		now = tr.now()
+	}
+
+	tn := tr.findLeaf(key)
+	pos := tn.findLeafInsertPos(key)
+	for tn != nil {
+		for ; pos < len(tn.keys)-1; pos++ {
+			if !tr.expired(tn.keys[pos], now) {
+				return tn.keys[pos], tn.ptrs[pos], nil
+			}
+		}
+
+		tn = tn.next()
+		pos = 0
+	}
+
+	return 0, nil, ErrKeyNotFound
+}
+
+// ascend calls fn for every entry not expired in key order, it stops as
+// soon as fn returns false.
+func (tr *BPlusTree) ascend(fn func(key int64, value interface{}) bool) {
+	var now int64
+	if tr.ttl != nil {
+		now = tr.now()
+	}
+
+	for tn := tr.firstLeaf(); tn != nil; {
+		last := len(tn.keys) - 1
+		for i, k := range tn.keys[:last] {
+			if tr.expired(k, now) {
+				continue
+			}
+
+			if !fn(k, tn.ptrs[i]) {
+				return
+			}
+		}
+
+		tn = tn.next()
+	}
+}
+
+// firstLeaf returns the leftmost leaf
+func (tr *BPlusTree) firstLeaf() *tNode {
+	tn := tr.root
+	for !tn.isLeaf {
+		tn = tn.child(0)
+	}
+
+	return tn
+}
@@ -0,0 +1,308 @@
+package v2
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// Snapshot stream layout, integers in header and trailer are little
+// endian:
+//
+//	header:  magic(8) | version(2) | max size(4) | count(8) | codec name len(1) | codec name | leaf capacity(4) | crc32c(4)
+//	entry:   key delta(uvarint) | value len(uvarint) | value
+//	trailer: crc32c of all entries(4)
+//
+// key of the first entry is stored as zigzag varint, keys of following
+// entries are stored as delta to the previous key. max size is the
+// internal capacity, version 1 has no leaf capacity in header and its
+// leaves hold at most max size - 1 keys.
+const (
+	snapshotVersion        = 2
+	snapshotHeaderFixedLen = 23
+
+	// maxSnapshotValueSize bounds encoded size of a value
+	maxSnapshotValueSize = 1 << 30
+	// snapshotReadChunk is the most a value buffer grows by before the
+	// bytes are read, so that a corrupt size does not allocate memory
+	// the stream does not hold
+	snapshotReadChunk = 64 << 10
+)
+
+var snapshotMagic = []byte("BPTSNAP\x00")
+
+// SetValueCodec sets codec used by WriteTo and ReadFrom to encode values,
+// GobCodec is used if none is set.
+func (tr *BPlusTree) SetValueCodec(codec ValueCodec) {
+	tr.codec = codec
+}
+
+func (tr *BPlusTree) valueCodec() ValueCodec {
+	if tr.codec == nil {
+		return GobCodec{}
+	}
+
+	return tr.codec
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// WriteTo streams all entries of tr not expired into w in ascending key
+// order, it implements io.WriterTo.
+func (tr *BPlusTree) WriteTo(w io.Writer) (int64, error) {
+	if tr.cfg.cmp != nil {
+		return 0, ErrCustomOrder
+	}
+
+	codec := tr.valueCodec()
+	name := codec.Name()
+	if len(name) > 255 {
+		return 0, fmt.Errorf("codec name too long: %q", name)
+	}
+
+	var now int64
+	if tr.ttl != nil {
+		now = tr.now()
+	}
+	size := tr.size - tr.expiredCount(now)
+
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+
+	hdr := make([]byte, snapshotHeaderFixedLen, snapshotHeaderFixedLen+len(name)+8)
+	copy(hdr, snapshotMagic)
+	binary.LittleEndian.PutUint16(hdr[8:], snapshotVersion)
+	binary.LittleEndian.PutUint32(hdr[10:], uint32(tr.cfg.innerCap))
+	binary.LittleEndian.PutUint64(hdr[14:], uint64(size))
+	hdr[22] = byte(len(name))
+	hdr = append(hdr, name...)
+	hdr = hdr[:len(hdr)+4]
+	binary.LittleEndian.PutUint32(hdr[len(hdr)-4:], uint32(tr.cfg.leafCap))
+	hdr = hdr[:len(hdr)+4]
+	binary.LittleEndian.PutUint32(hdr[len(hdr)-4:], crc32.Checksum(hdr[:len(hdr)-4], crcTable))
+	if _, err := bw.Write(hdr); err != nil {
+		return cw.n, err
+	}
+
+	h := crc32.New(crcTable)
+	ew := io.MultiWriter(bw, h)
+	buf := make([]byte, binary.MaxVarintLen64)
+	prev, count := int64(0), 0
+	for tn := tr.firstLeaf(); tn != nil; tn = tn.next() {
+		for i, k := range tn.keys[:len(tn.keys)-1] {
+			if tr.expired(k, now) {
+				continue
+			}
+
+			var n int
+			if count == 0 {
+				n = binary.PutVarint(buf, k)
+			} else {
+				n = binary.PutUvarint(buf, uint64(k-prev))
+			}
+			if _, err := ew.Write(buf[:n]); err != nil {
+				return cw.n, err
+			}
+
+			v, err := codec.Encode(tn.ptrs[i])
+			if err != nil {
+				return cw.n, fmt.Errorf("error encoding value of key %d: %w", k, err)
+			}
+
+			if len(v) > maxSnapshotValueSize {
+				return cw.n, fmt.Errorf("value of key %d too large: %d bytes", k, len(v))
+			}
+
+			n = binary.PutUvarint(buf, uint64(len(v)))
+			if _, err := ew.Write(buf[:n]); err != nil {
+				return cw.n, err
+			}
+
+			if _, err := ew.Write(v); err != nil {
+				return cw.n, err
+			}
+
+			prev = k
+			count++
+		}
+	}
+
+	if count != size {
+		return cw.n, fmt.Errorf("expect %d keys in tree but found %d", size, count)
+	}
+
+	binary.LittleEndian.PutUint32(buf, h.Sum32())
+	if _, err := bw.Write(buf[:4]); err != nil {
+		return cw.n, err
+	}
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+type crcReader struct {
+	r *bufio.Reader
+	h hash.Hash32
+	n int64
+}
+
+func (cr *crcReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.h.Write(p[:n])
+	cr.n += int64(n)
+	return n, err
+}
+
+func (cr *crcReader) ReadByte() (byte, error) {
+	b, err := cr.r.ReadByte()
+	if err == nil {
+		cr.h.Write([]byte{b})
+		cr.n++
+	}
+
+	return b, err
+}
+
+// ReadFrom replaces content of tr with snapshot read from r, it
+// implements io.ReaderFrom. The tree is built bottom up with full
+// nodes, capacities of tr are restored from snapshot as well.
+func (tr *BPlusTree) ReadFrom(r io.Reader) (int64, error) {
+	codec := tr.valueCodec()
+	cr := &crcReader{r: bufio.NewReader(r), h: crc32.New(crcTable)}
+	hdr := make([]byte, snapshotHeaderFixedLen)
+	if _, err := io.ReadFull(cr, hdr); err != nil {
+		return cr.n, fmt.Errorf("error reading snapshot header: %w", err)
+	}
+
+	if string(hdr[:8]) != string(snapshotMagic) {
+		return cr.n, fmt.Errorf("bad snapshot magic: %q", hdr[:8])
+	}
+
+	version := binary.LittleEndian.Uint16(hdr[8:])
+	if version < 1 || version > snapshotVersion {
+		return cr.n, fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+
+	maxSize := int(binary.LittleEndian.Uint32(hdr[10:]))
+	count := binary.LittleEndian.Uint64(hdr[14:])
+	nameLen := int(hdr[22])
+	rest := make([]byte, nameLen+4)
+	if version > 1 {
+		rest = make([]byte, nameLen+8)
+	}
+
+	if _, err := io.ReadFull(cr, rest); err != nil {
+		return cr.n, fmt.Errorf("error reading snapshot header: %w", err)
+	}
+
+	hdr = append(hdr, rest[:len(rest)-4]...)
+	if want, got := binary.LittleEndian.Uint32(rest[len(rest)-4:]), crc32.Checksum(hdr, crcTable); want != got {
+		return cr.n, fmt.Errorf("snapshot header checksum mismatch: want %#08x but got %#08x", want, got)
+	}
+
+	if name := rest[:nameLen]; string(name) != codec.Name() {
+		return cr.n, fmt.Errorf("snapshot encoded with codec %q but read with codec %q", name, codec.Name())
+	}
+
+	leafCap := maxSize - 1
+	if version > 1 {
+		leafCap = int(binary.LittleEndian.Uint32(rest[nameLen:]))
+	}
+
+	if maxSize < 3 || leafCap < 2 {
+		return cr.n, fmt.Errorf("illegal capacities in snapshot: leaf %d, internal %d", leafCap, maxSize)
+	}
+
+	cr.h.Reset()
+	bl := newBulkLoader(tr.loadConfig(leafCap, maxSize))
+	var buf []byte
+	key := int64(0)
+	for i := uint64(0); i < count; i++ {
+		if i == 0 {
+			k, err := binary.ReadVarint(cr)
+			if err != nil {
+				return cr.n, fmt.Errorf("error reading key of entry %d: %w", i, err)
+			}
+			key = k
+		} else {
+			delta, err := binary.ReadUvarint(cr)
+			if err != nil {
+				return cr.n, fmt.Errorf("error reading key of entry %d: %w", i, err)
+			}
+
+			if delta == 0 || key+int64(delta) < key {
+				return cr.n, fmt.Errorf("illegal key delta %d after key %d", delta, key)
+			}
+			key += int64(delta)
+		}
+
+		sz, err := binary.ReadUvarint(cr)
+		if err != nil {
+			return cr.n, fmt.Errorf("error reading value of key %d: %w", key, err)
+		}
+
+		// size is not covered by a verified checksum yet
+		if sz > maxSnapshotValueSize {
+			return cr.n, fmt.Errorf("value of key %d too large: %d bytes", key, sz)
+		}
+
+		if buf, err = readChunked(cr, buf[:0], int(sz)); err != nil {
+			return cr.n, fmt.Errorf("error reading value of key %d: %w", key, err)
+		}
+
+		// codec may keep reference to the bytes decoded
+		v, err := codec.Decode(append([]byte(nil), buf...))
+		if err != nil {
+			return cr.n, fmt.Errorf("error decoding value of key %d: %w", key, err)
+		}
+
+		if err := bl.add(key, v); err != nil {
+			return cr.n, err
+		}
+	}
+
+	sum := cr.h.Sum32()
+	trailer := make([]byte, 4)
+	if _, err := io.ReadFull(cr, trailer); err != nil {
+		return cr.n, fmt.Errorf("error reading snapshot trailer: %w", err)
+	}
+
+	if want := binary.LittleEndian.Uint32(trailer); want != sum {
+		return cr.n, fmt.Errorf("snapshot checksum mismatch: want %#08x but got %#08x", want, sum)
+	}
+
+	ntr := bl.build()
+	tr.cfg, tr.root, tr.size, tr.ttl = ntr.cfg, ntr.root, ntr.size, nil
+	return cr.n, nil
+}
+
+// readChunked reads n bytes from r into buf, buf grows by at most
+// snapshotReadChunk bytes ahead of the bytes read
+func readChunked(r io.Reader, buf []byte, n int) ([]byte, error) {
+	for len(buf) < n {
+		m := n - len(buf)
+		if m > snapshotReadChunk && cap(buf) < n {
+			m = snapshotReadChunk
+		}
+
+		start := len(buf)
+		buf = append(buf, make([]byte, m)...)
+		if _, err := io.ReadFull(r, buf[start:]); err != nil {
+			return buf, err
+		}
+	}
+
+	return buf, nil
+}
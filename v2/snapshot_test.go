@@ -0,0 +1,211 @@
+package v2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	for _, maxSize := range []int{3, 4, 5, 16} {
+		for _, numKeys := range []int{0, 1, 2, 3, 7, 100, 1000} {
+			tr := newTree(t, maxSize, numKeys, 3)
+			// negative keys
+			tr.Insert(&Entry{key: -100, pointer: -100})
+
+			buf := bytes.NewBuffer(nil)
+			n, err := tr.WriteTo(buf)
+			if err != nil {
+				t.Fatalf("error writing snapshot: %+v", err)
+			}
+
+			if n != int64(buf.Len()) {
+				t.Fatalf("expect %d bytes written but got %d", buf.Len(), n)
+			}
+
+			ntr := &BPlusTree{}
+			if n, err = ntr.ReadFrom(buf); err != nil {
+				t.Fatalf("max size %d, %d keys: error reading snapshot: %+v", maxSize, numKeys, err)
+			}
+
+			if ntr.Len() != tr.Len() || ntr.cfg.innerCap != maxSize {
+				t.Fatalf("expect %d keys with max size %d but got %d keys with max size %d", tr.Len(), maxSize, ntr.Len(), ntr.cfg.innerCap)
+			}
+
+			if err := checkBPlusTreeInvariant(ntr); err != nil {
+				t.Fatalf("max size %d, %d keys: b tree invariant check failed: %+v\n%s", maxSize, numKeys, err, ntr.ToString())
+			}
+
+			for k := 1; k <= numKeys*3; k += 3 {
+				if v, err := ntr.Find(int64(k)); err != nil || !reflect.DeepEqual(v, k) {
+					t.Fatalf("expect value %d of key %d but got (%+v, %+v)", k, k, v, err)
+				}
+			}
+
+			// rebuilt tree is still writable
+			for k := 2; k <= numKeys*3; k += 3 {
+				if err := ntr.Insert(&Entry{key: int64(k), pointer: k}); err != nil {
+					t.Fatalf("error inserting key %d: %+v", k, err)
+				}
+			}
+
+			for k := 1; k <= numKeys*3; k += 3 {
+				if err := ntr.Delete(int64(k)); err != nil {
+					t.Fatalf("error deleting key %d: %+v", k, err)
+				}
+			}
+
+			if err := checkBPlusTreeInvariant(ntr); err != nil {
+				t.Fatalf("b tree invariant check failed: %+v", err)
+			}
+		}
+	}
+}
+
+func TestSnapshotCodecs(t *testing.T) {
+	cases := []struct {
+		codec ValueCodec
+		value interface{}
+		want  interface{}
+	}{
+		{codec: GobCodec{}, value: "foo", want: "foo"},
+		{codec: GobCodec{}, value: nil, want: nil},
+		{codec: JSONCodec{}, value: 3, want: float64(3)},
+		{codec: JSONCodec{}, value: map[string]interface{}{"a": "b"}, want: map[string]interface{}{"a": "b"}},
+		{codec: BytesCodec{}, value: "foo", want: []byte("foo")},
+	}
+
+	for i, tc := range cases {
+		tr := newTree(t, 4, 0, 0)
+		tr.SetValueCodec(tc.codec)
+		tr.Insert(&Entry{key: 1, pointer: tc.value})
+		buf := bytes.NewBuffer(nil)
+		if _, err := tr.WriteTo(buf); err != nil {
+			t.Fatalf("case %d: error writing snapshot: %+v", i, err)
+		}
+
+		data := buf.Bytes()
+		if _, err := (&BPlusTree{}).ReadFrom(bytes.NewReader(data)); tc.codec.Name() != "gob" && err == nil {
+			t.Fatalf("case %d: expect codec mismatch but got none", i)
+		}
+
+		ntr := &BPlusTree{}
+		ntr.SetValueCodec(tc.codec)
+		if _, err := ntr.ReadFrom(bytes.NewReader(data)); err != nil {
+			t.Fatalf("case %d: error reading snapshot: %+v", i, err)
+		}
+
+		if v, _ := ntr.Find(1); !reflect.DeepEqual(v, tc.want) {
+			t.Fatalf("case %d: expect value %+v but got %+v", i, tc.want, v)
+		}
+	}
+}
+
+func TestSnapshotCorrupt(t *testing.T) {
+	tr := newTree(t, 4, 20, 1)
+	buf := bytes.NewBuffer(nil)
+	if _, err := tr.WriteTo(buf); err != nil {
+		t.Fatalf("error writing snapshot: %+v", err)
+	}
+	data := buf.Bytes()
+
+	cases := []struct {
+		corrupt func(b []byte) []byte
+		err     string
+	}{
+		// header
+		{corrupt: func(b []byte) []byte { b[15] ^= 0xff; return b }, err: "header checksum mismatch"},
+		// trailer
+		{corrupt: func(b []byte) []byte { b[len(b)-1] ^= 0xff; return b }, err: "snapshot checksum mismatch"},
+		// entries, value may fail to decode before checksum is verified
+		{corrupt: func(b []byte) []byte { b[len(b)-8] ^= 0xff; return b }, err: ""},
+		// torn write
+		{corrupt: func(b []byte) []byte { return b[:len(b)-10] }, err: "EOF"},
+	}
+
+	for i, tc := range cases {
+		b := tc.corrupt(append([]byte(nil), data...))
+		_, err := (&BPlusTree{}).ReadFrom(bytes.NewReader(b))
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Fatalf("case %d: expect error %q but got %+v", i, tc.err, err)
+		}
+	}
+}
+
+func TestSnapshotValueSize(t *testing.T) {
+	tr, _ := NewTree(WithValueCodec(BytesCodec{}))
+	tr.Insert(&Entry{key: 1, pointer: []byte("foo")})
+	buf := bytes.NewBuffer(nil)
+	if _, err := tr.WriteTo(buf); err != nil {
+		t.Fatalf("error writing snapshot: %+v", err)
+	}
+
+	// value length follows the single byte key of the only entry
+	data := buf.Bytes()
+	off := snapshotHeaderFixedLen + len(BytesCodec{}.Name()) + 8 + 1
+	cases := []struct {
+		size uint64
+		err  string
+	}{
+		{size: 1 << 40, err: "too large"},
+		// a corrupt size is not allocated ahead of the stream
+		{size: 1 << 29, err: "EOF"},
+	}
+
+	for _, tc := range cases {
+		n := make([]byte, binary.MaxVarintLen64)
+		b := append([]byte(nil), data[:off]...)
+		b = append(b, n[:binary.PutUvarint(n, tc.size)]...)
+		b = append(b, data[off+1:]...)
+		_, err := (&BPlusTree{codec: BytesCodec{}}).ReadFrom(bytes.NewReader(b))
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Fatalf("size %d: expect error %q but got %+v", tc.size, tc.err, err)
+		}
+	}
+
+	// values larger than a chunk are read in pieces
+	large := bytes.Repeat([]byte("x"), 3*snapshotReadChunk+1)
+	tr.Insert(&Entry{key: 2, pointer: large})
+	buf.Reset()
+	if _, err := tr.WriteTo(buf); err != nil {
+		t.Fatalf("error writing snapshot: %+v", err)
+	}
+
+	ntr := &BPlusTree{codec: BytesCodec{}}
+	if _, err := ntr.ReadFrom(buf); err != nil {
+		t.Fatalf("error reading snapshot: %+v", err)
+	}
+
+	if v, _ := ntr.Find(2); !reflect.DeepEqual(v, large) {
+		t.Fatalf("expect value of %d bytes but got %d", len(large), len(v.([]byte)))
+	}
+}
+
+func TestSnapshotV1(t *testing.T) {
+	tr := newTree(t, 5, 50, 1)
+	buf := bytes.NewBuffer(nil)
+	if _, err := tr.WriteTo(buf); err != nil {
+		t.Fatalf("error writing snapshot: %+v", err)
+	}
+
+	// strip leaf capacity from header to get a version 1 snapshot
+	data := buf.Bytes()
+	end := snapshotHeaderFixedLen + int(data[22])
+	hdr := append([]byte(nil), data[:end]...)
+	binary.LittleEndian.PutUint16(hdr[8:], 1)
+	hdr = hdr[:end+4]
+	binary.LittleEndian.PutUint32(hdr[end:], crc32.Checksum(hdr[:end], crcTable))
+	v1 := append(hdr, data[end+8:]...)
+
+	ntr := &BPlusTree{}
+	if _, err := ntr.ReadFrom(bytes.NewReader(v1)); err != nil {
+		t.Fatalf("error reading version 1 snapshot: %+v", err)
+	}
+
+	if ntr.cfg.leafCap != 4 || ntr.cfg.innerCap != 5 || !reflect.DeepEqual(entries(ntr), entries(tr)) {
+		t.Fatalf("expect capacities 4 and 5 and entries %+v but got %d, %d and %+v", entries(tr), ntr.cfg.leafCap, ntr.cfg.innerCap, entries(ntr))
+	}
+}
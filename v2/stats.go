@@ -0,0 +1,52 @@
+package v2
+
+// Stats describes shape of a tree.
+type Stats struct {
+	Len              int
+	Height           int
+	LeafCapacity     int
+	InternalCapacity int
+	LeafNodes        int
+	InternalNodes    int
+	// FillFactor is the ratio of used slots over all slots of nodes,
+	// a leaf has LeafCapacity slots for keys and an internal node has
+	// InternalCapacity slots for children.
+	FillFactor float64
+}
+
+func (tr *BPlusTree) Stats() Stats {
+	st := Stats{
+		Len:              tr.size,
+		LeafCapacity:     tr.cfg.leafCap,
+		InternalCapacity: tr.cfg.innerCap,
+	}
+
+	used, slots := 0, 0
+	level := []*tNode{tr.root}
+	for len(level) > 0 {
+		st.Height++
+		next := []*tNode{}
+		for _, tn := range level {
+			if tn.isLeaf {
+				st.LeafNodes++
+				used += len(tn.keys) - 1
+				slots += tr.cfg.leafCap
+				continue
+			}
+
+			st.InternalNodes++
+			used += len(tn.keys)
+			slots += tr.cfg.innerCap
+			for _, p := range tn.ptrs {
+				next = append(next, p.(*tNode))
+			}
+		}
+		level = next
+	}
+
+	if slots > 0 {
+		st.FillFactor = float64(used) / float64(slots)
+	}
+
+	return st
+}
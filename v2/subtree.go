@@ -0,0 +1,67 @@
+package v2
+
+import "fmt"
+
+var ErrSubtreeTooLarge error = fmt.Errorf("subtree too large")
+
+// Subtree returns a copy of the subtree rooted at the node at depth on
+// the search path of key, depth 0 being the root. Values are shared
+// with tr. Nodes below the deepest level are not copied, it fails with
+// ErrSubtreeTooLarge if the copy would have more than maxNodes nodes.
+func (tr *BPlusTree) Subtree(key int64, depth int, maxNodes int) (*BPlusTree, error) {
+	tn := tr.root
+	for i := 0; i < depth; i++ {
+		if tn.isLeaf {
+			return nil, fmt.Errorf("depth %d exceeds tree height %d", depth, i+1)
+		}
+		tn = tn.child(tn.findChildPos(key))
+	}
+
+	c := &subtreeCopier{cfg: tr.cfg.withCapacity(tr.cfg.leafCap, tr.cfg.innerCap), maxNodes: maxNodes}
+	root, err := c.copy(tn, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BPlusTree{cfg: c.cfg, root: root, size: c.size, codec: tr.codec}, nil
+}
+
+type subtreeCopier struct {
+	cfg      *config
+	maxNodes int
+	nodes    int
+	size     int
+	last     *tNode
+}
+
+func (c *subtreeCopier) copy(tn *tNode, parent *tNode) (*tNode, error) {
+	c.nodes++
+	if c.nodes > c.maxNodes {
+		return nil, ErrSubtreeTooLarge
+	}
+
+	cp := c.cfg.newNode(tn.isLeaf)
+	cp.parent = parent
+	cp.resize(tn.size())
+	copyEntries(cp, 0, tn, 0, tn.size())
+	if tn.isLeaf {
+		// link to sibling inside the subtree only
+		cp.ptrs[len(cp.keys)-1] = nil
+		if c.last != nil {
+			c.last.ptrs[len(c.last.keys)-1] = cp
+		}
+		c.last = cp
+		c.size += len(cp.keys) - 1
+		return cp, nil
+	}
+
+	for i := range cp.ptrs {
+		child, err := c.copy(cp.child(i), cp)
+		if err != nil {
+			return nil, err
+		}
+		cp.ptrs[i] = child
+	}
+
+	return cp, nil
+}
@@ -0,0 +1,47 @@
+package v2
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSubtree(t *testing.T) {
+	tr := newTree(t, 3, 30, 1)
+	for depth := 0; depth < tr.Stats().Height; depth++ {
+		st, err := tr.Subtree(17, depth, 1000)
+		if err != nil {
+			t.Fatalf("error copying subtree at depth %d: %+v", depth, err)
+		}
+
+		if err := st.Check(); err != nil {
+			t.Fatalf("subtree at depth %d:\n%s\nbroken: %+v", depth, st.ToString(), err)
+		}
+
+		var keys, wkeys []int64
+		st.Range(minKey, maxKey, func(key int64, value interface{}) bool {
+			keys = append(keys, key)
+			return true
+		})
+		tr.Range(keys[0], keys[len(keys)-1], func(key int64, value interface{}) bool {
+			wkeys = append(wkeys, key)
+			return true
+		})
+		if !reflect.DeepEqual(keys, wkeys) || keys[0] > 17 || keys[len(keys)-1] < 17 {
+			t.Fatalf("subtree at depth %d, expect keys %+v but got %+v", depth, wkeys, keys)
+		}
+
+		// the copy is independent of tr
+		st.Delete(17)
+		if _, err := tr.Find(17); err != nil {
+			t.Fatalf("error finding key 17: %+v", err)
+		}
+	}
+
+	if _, err := tr.Subtree(17, 0, 3); err != ErrSubtreeTooLarge {
+		t.Fatalf("expect error %+v but got %+v", ErrSubtreeTooLarge, err)
+	}
+
+	if _, err := tr.Subtree(17, 10, 1000); err == nil {
+		t.Fatalf("expect error but got none")
+	}
+}
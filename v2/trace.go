@@ -0,0 +1,462 @@
+package v2
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// Trace file layout:
+//
+//	header: magic(8) | version(2) | initial state len(uvarint) | initial state
+//	insert: 'i' | key(varint) | value len(uvarint) | value
+//	delete: 'd' | key(varint)
+//
+// initial state is the tree in page format, so replay starts with the
+// exact same node layout as the recorded tree.
+const (
+	traceVersion  = 1
+	tracePageSize = minPageSize
+
+	OpInsert OpKind = 'i'
+	OpDelete OpKind = 'd'
+)
+
+var traceMagic = []byte("BPTTRACE")
+
+// replayCheck checks tree after every replayed op, replaced in tests
+var replayCheck = (*BPlusTree).Check
+
+type OpKind byte
+
+func (k OpKind) String() string {
+	switch k {
+	case OpInsert:
+		return "insert"
+	case OpDelete:
+		return "delete"
+	}
+
+	return fmt.Sprintf("op(%d)", byte(k))
+}
+
+// Op is a mutating operation recorded in a trace
+type Op struct {
+	Kind  OpKind
+	Key   int64
+	Value interface{}
+}
+
+func (op Op) String() string {
+	if op.Kind == OpInsert {
+		return fmt.Sprintf("%s %d %v", op.Kind, op.Key, op.Value)
+	}
+
+	return fmt.Sprintf("%s %d", op.Kind, op.Key)
+}
+
+// Trace is a sequence of operations applied to a tree of known
+// initial state.
+type Trace struct {
+	initial []byte
+	codec   ValueCodec
+	Ops     []Op
+}
+
+type recorder struct {
+	w     io.Writer
+	codec ValueCodec
+	buf   []byte
+	err   error
+}
+
+// Record starts recording every Insert and Delete of tr into w, the
+// current content of tr is written first as initial state. Values are
+// encoded by the value codec of tr. Recording stops at the first
+// write error, which is returned by StopRecording. Only trees of
+// default key order, duplicate key policy and split mode can be
+// recorded, the min fill of tr is kept in initial state.
+func (tr *BPlusTree) Record(w io.Writer) error {
+	// replay rebuilds tree with default options
+	if tr.cfg.dup != DupError {
+		return fmt.Errorf("unable to record tree with duplicate key policy %s", tr.cfg.dup)
+	}
+
+	if tr.cfg.bstar {
+		return fmt.Errorf("unable to record tree in B* mode")
+	}
+
+	if tr.cfg.appends {
+		return fmt.Errorf("unable to record tree with append fast path")
+	}
+
+	codec := tr.valueCodec()
+	img := bytes.NewBuffer(nil)
+	if err := tr.writePages(img, &FileOptions{PageSize: tracePageSize, Codec: codec}); err != nil {
+		return err
+	}
+
+	hdr := make([]byte, 10+binary.MaxVarintLen64)
+	copy(hdr, traceMagic)
+	binary.LittleEndian.PutUint16(hdr[8:], traceVersion)
+	n := 10 + binary.PutUvarint(hdr[10:], uint64(img.Len()))
+	if _, err := w.Write(hdr[:n]); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(img.Bytes()); err != nil {
+		return err
+	}
+
+	tr.rec = &recorder{w: w, codec: codec}
+	return nil
+}
+
+// StopRecording stops recording operations of tr
+func (tr *BPlusTree) StopRecording() error {
+	rec := tr.rec
+	tr.rec = nil
+	if rec == nil {
+		return nil
+	}
+
+	return rec.err
+}
+
+func (rec *recorder) record(kind OpKind, key int64, value interface{}) {
+	if rec.err != nil {
+		return
+	}
+
+	rec.buf, rec.err = appendOp(rec.buf[:0], rec.codec, Op{Kind: kind, Key: key, Value: value})
+	if rec.err != nil {
+		return
+	}
+
+	_, rec.err = rec.w.Write(rec.buf)
+}
+
+func appendOp(buf []byte, codec ValueCodec, op Op) ([]byte, error) {
+	var tmp [binary.MaxVarintLen64]byte
+	buf = append(buf, byte(op.Kind))
+	buf = append(buf, tmp[:binary.PutVarint(tmp[:], op.Key)]...)
+	if op.Kind != OpInsert {
+		return buf, nil
+	}
+
+	v, err := codec.Encode(op.Value)
+	if err != nil {
+		return buf, fmt.Errorf("error encoding value of key %d: %w", op.Key, err)
+	}
+
+	buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(len(v)))]...)
+	return append(buf, v...), nil
+}
+
+// ReadTrace reads trace written by Record, values are decoded by
+// codec, GobCodec if nil.
+func ReadTrace(r io.Reader, codec ValueCodec) (*Trace, error) {
+	if codec == nil {
+		codec = GobCodec{}
+	}
+
+	br := bufio.NewReader(r)
+	hdr := make([]byte, 10)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return nil, fmt.Errorf("error reading trace header: %w", err)
+	}
+
+	if string(hdr[:8]) != string(traceMagic) {
+		return nil, fmt.Errorf("bad trace magic: %q", hdr[:8])
+	}
+
+	if v := binary.LittleEndian.Uint16(hdr[8:]); v != traceVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, v)
+	}
+
+	sz, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, fmt.Errorf("error reading trace header: %w", err)
+	}
+
+	if sz > math.MaxInt32 {
+		return nil, fmt.Errorf("initial state too large: %d bytes", sz)
+	}
+
+	// sizes are not covered by a checksum, buffers grow only as bytes
+	// arrive
+	tc := &Trace{codec: codec}
+	if tc.initial, err = readChunked(br, nil, int(sz)); err != nil {
+		return nil, fmt.Errorf("error reading initial state: %w", err)
+	}
+
+	// fail early if initial state is not readable
+	if _, err := tc.initialTree(); err != nil {
+		return nil, err
+	}
+
+	for {
+		kind, err := br.ReadByte()
+		if err == io.EOF {
+			return tc, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		op := Op{Kind: OpKind(kind)}
+		if op.Kind != OpInsert && op.Kind != OpDelete {
+			return nil, fmt.Errorf("unknown op %d after %d ops", kind, len(tc.Ops))
+		}
+
+		// a torn tail is expected if the recording process crashed
+		if op.Key, err = binary.ReadVarint(br); err != nil {
+			return tc, nil
+		}
+
+		if op.Kind == OpInsert {
+			sz, err := binary.ReadUvarint(br)
+			if err != nil {
+				return tc, nil
+			}
+
+			if sz > maxSnapshotValueSize {
+				return nil, fmt.Errorf("value of op %d too large: %d bytes", len(tc.Ops), sz)
+			}
+
+			v, err := readChunked(br, nil, int(sz))
+			if err != nil {
+				return tc, nil
+			}
+
+			if op.Value, err = codec.Decode(v); err != nil {
+				return nil, fmt.Errorf("error decoding value of op %d: %w", len(tc.Ops), err)
+			}
+		}
+
+		tc.Ops = append(tc.Ops, op)
+	}
+}
+
+// WriteTo writes tc in the format of Record
+func (tc *Trace) WriteTo(w io.Writer) (int64, error) {
+	buf := make([]byte, 10+binary.MaxVarintLen64)
+	copy(buf, traceMagic)
+	binary.LittleEndian.PutUint16(buf[8:], traceVersion)
+	buf = buf[:10+binary.PutUvarint(buf[10:], uint64(len(tc.initial)))]
+	buf = append(buf, tc.initial...)
+
+	var err error
+	for _, op := range tc.Ops {
+		if buf, err = appendOp(buf, tc.codec, op); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.Write(buf)
+	return int64(n), err
+}
+
+func (tc *Trace) String() string {
+	buf := strings.Builder{}
+	tr, err := tc.initialTree()
+	if err != nil {
+		fmt.Fprintf(&buf, "initial state: %v\n", err)
+	} else {
+		fmt.Fprintf(&buf, "leaf capacity: %d, internal capacity: %d, initial keys: %d\n", tr.cfg.leafCap, tr.cfg.innerCap, tr.size)
+	}
+
+	for i, op := range tc.Ops {
+		fmt.Fprintf(&buf, "%d: %s\n", i, op)
+	}
+
+	return buf.String()
+}
+
+func (tc *Trace) initialTree() (*BPlusTree, error) {
+	pf, err := openPageFile(bytesSource(tc.initial), int64(len(tc.initial)), &FileOptions{Codec: tc.codec})
+	if err != nil {
+		return nil, fmt.Errorf("error reading initial state: %w", err)
+	}
+
+	tr, err := pf.load(nil)
+	if err != nil {
+		return nil, fmt.Errorf("error reading initial state: %w", err)
+	}
+
+	tr.codec = tc.codec
+	return tr, nil
+}
+
+// ReplayError reports the first step at which replay failed
+type ReplayError struct {
+	// Step is index of the failed op, -1 if initial state is broken
+	Step int
+	Op   Op
+	Err  error
+}
+
+func (e *ReplayError) Error() string {
+	if e.Step < 0 {
+		return e.Err.Error()
+	}
+
+	return fmt.Sprintf("step %d(%s): %v", e.Step, e.Op, e.Err)
+}
+
+func (e *ReplayError) Unwrap() error {
+	return e.Err
+}
+
+// Replay re-executes trace from its initial state. After every step
+// the tree invariants are checked, and the outcome of the op as well as
+// lookup of its key is compared with a map model of the tree.
+func Replay(tc *Trace) (*BPlusTree, error) {
+	tr, err := tc.initialTree()
+	if err != nil {
+		return nil, &ReplayError{Step: -1, Err: err}
+	}
+
+	if err := tr.Check(); err != nil {
+		return nil, &ReplayError{Step: -1, Err: fmt.Errorf("initial state: %w", err)}
+	}
+
+	model := map[int64]interface{}{}
+	tr.ascend(func(key int64, value interface{}) bool {
+		model[key] = value
+		return true
+	})
+
+	for i, op := range tc.Ops {
+		if err := replayOp(tr, model, op); err != nil {
+			return tr, &ReplayError{Step: i, Op: op, Err: err}
+		}
+	}
+
+	return tr, nil
+}
+
+func replayOp(tr *BPlusTree, model map[int64]interface{}, op Op) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	_, exist := model[op.Key]
+	switch op.Kind {
+	case OpInsert:
+		err = tr.Insert(&Entry{key: op.Key, pointer: op.Value})
+		if exist && err == nil {
+			return fmt.Errorf("expect duplicate key error but got none")
+		} else if !exist && err != nil {
+			return fmt.Errorf("unexpected error: %w", err)
+		}
+
+		if !exist {
+			model[op.Key] = op.Value
+		}
+	case OpDelete:
+		err = tr.Delete(op.Key)
+		if !exist && err == nil {
+			return fmt.Errorf("expect key not found error but got none")
+		} else if exist && err != nil {
+			return fmt.Errorf("unexpected error: %w", err)
+		}
+
+		delete(model, op.Key)
+	default:
+		return fmt.Errorf("unknown op %s", op.Kind)
+	}
+
+	if err := replayCheck(tr); err != nil {
+		return err
+	}
+
+	if tr.Len() != len(model) {
+		return fmt.Errorf("expect %d keys but got %d", len(model), tr.Len())
+	}
+
+	v, err := tr.Find(op.Key)
+	if want, ok := model[op.Key]; !ok && err != ErrKeyNotFound {
+		return fmt.Errorf("expect key not found but got (%v, %v)", v, err)
+	} else if ok && (err != nil || !valueEqual(v, want)) {
+		return fmt.Errorf("expect value %v but got (%v, %v)", want, v, err)
+	}
+
+	return nil
+}
+
+func valueEqual(a, b interface{}) (eq bool) {
+	// values of uncomparable types are only checked for presence
+	defer func() {
+		if recover() != nil {
+			eq = true
+		}
+	}()
+
+	return a == b
+}
+
+// Shrink returns a minimal trace that still fails to replay, by
+// removing as many ops as possible while keeping the initial state.
+// tc itself is returned if it replays fine.
+func (tc *Trace) Shrink() *Trace {
+	fails := func(ops []Op) bool {
+		_, err := Replay(&Trace{initial: tc.initial, codec: tc.codec, Ops: ops})
+		return err != nil
+	}
+
+	ops := tc.Ops
+	if !fails(ops) {
+		return tc
+	}
+
+	// ops after the failed step are irrelevant
+	if _, err := Replay(tc); err != nil {
+		if rerr, ok := err.(*ReplayError); ok && rerr.Step >= 0 {
+			ops = ops[:rerr.Step+1]
+		}
+	}
+
+	// delta debugging: remove chunks of ops, halving chunk size when no
+	// chunk can be removed
+	for n := 2; len(ops) > 0; {
+		chunk := (len(ops) + n - 1) / n
+		reduced := false
+		for i := 0; i < len(ops); i += chunk {
+			e := i + chunk
+			if e > len(ops) {
+				e = len(ops)
+			}
+
+			cand := make([]Op, 0, len(ops)-(e-i))
+			cand = append(cand, ops[:i]...)
+			cand = append(cand, ops[e:]...)
+			if fails(cand) {
+				ops = cand
+				reduced = true
+				if n > 2 {
+					n--
+				}
+				break
+			}
+		}
+
+		if !reduced {
+			if chunk == 1 {
+				break
+			}
+
+			n *= 2
+			if n > len(ops) {
+				n = len(ops)
+			}
+		}
+	}
+
+	return &Trace{initial: tc.initial, codec: tc.codec, Ops: ops}
+}
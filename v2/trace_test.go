@@ -0,0 +1,168 @@
+package v2
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func recordRandomOps(t *testing.T, tr *BPlusTree, seed int64, n int) *bytes.Buffer {
+	buf := bytes.NewBuffer(nil)
+	if err := tr.Record(buf); err != nil {
+		t.Fatalf("error recording tree: %+v", err)
+	}
+
+	r := rand.New(rand.NewSource(seed))
+	for i := 0; i < n; i++ {
+		key := r.Int63n(100)
+		if r.Intn(3) == 0 {
+			tr.Delete(key)
+		} else {
+			tr.Insert(&Entry{key: key, pointer: int(key)})
+		}
+	}
+
+	if err := tr.StopRecording(); err != nil {
+		t.Fatalf("error recording tree: %+v", err)
+	}
+
+	return buf
+}
+
+func TestTraceReplay(t *testing.T) {
+	for _, maxSize := range []int{3, 4, 7} {
+		tr := newTree(t, maxSize, 30, 3)
+		buf := recordRandomOps(t, tr, int64(maxSize), 500)
+
+		tc, err := ReadTrace(buf, nil)
+		if err != nil {
+			t.Fatalf("error reading trace: %+v", err)
+		}
+
+		if len(tc.Ops) != 500 {
+			t.Fatalf("expect 500 ops but got %d", len(tc.Ops))
+		}
+
+		rt, err := Replay(tc)
+		if err != nil {
+			t.Fatalf("error replaying trace: %+v", err)
+		}
+
+		if rt.cfg.innerCap != maxSize {
+			t.Fatalf("expect max size %d but got %d", maxSize, rt.cfg.innerCap)
+		}
+
+		if rt.ToString() != tr.ToString() {
+			t.Fatalf("expect tree:\n%s\nbut got:\n%s", tr.ToString(), rt.ToString())
+		}
+	}
+}
+
+func TestTraceMinFill(t *testing.T) {
+	tr, _ := NewTree(WithFanout(8), WithMinFill(0.25))
+	for k := int64(0); k < 100; k++ {
+		tr.Insert(&Entry{key: k, pointer: int(k)})
+	}
+	for k := int64(0); k < 100; k++ {
+		if k%4 != 0 {
+			tr.Delete(k)
+		}
+	}
+	buf := recordRandomOps(t, tr, 1, 500)
+
+	tc, err := ReadTrace(buf, nil)
+	if err != nil {
+		t.Fatalf("error reading trace: %+v", err)
+	}
+
+	rt, err := Replay(tc)
+	if err != nil {
+		t.Fatalf("error replaying trace: %+v", err)
+	}
+
+	if rt.cfg.minFill != 0.25 || rt.ToString() != tr.ToString() {
+		t.Fatalf("expect min fill 0.25 and tree:\n%s\nbut got %v and:\n%s", tr.ToString(), rt.cfg.minFill, rt.ToString())
+	}
+}
+
+func TestTraceWriteTo(t *testing.T) {
+	tr := newTree(t, 4, 10, 1)
+	buf := recordRandomOps(t, tr, 1, 50)
+	want := append([]byte{}, buf.Bytes()...)
+
+	tc, err := ReadTrace(buf, nil)
+	if err != nil {
+		t.Fatalf("error reading trace: %+v", err)
+	}
+
+	out := bytes.NewBuffer(nil)
+	if _, err := tc.WriteTo(out); err != nil {
+		t.Fatalf("error writing trace: %+v", err)
+	}
+
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Fatalf("trace changed after read and write")
+	}
+}
+
+func TestTraceTornTail(t *testing.T) {
+	tr := newTree(t, 4, 0, 0)
+	buf := recordRandomOps(t, tr, 1, 10)
+	b := buf.Bytes()
+
+	tc, err := ReadTrace(bytes.NewReader(b[:len(b)-1]), nil)
+	if err != nil {
+		t.Fatalf("error reading trace: %+v", err)
+	}
+
+	if len(tc.Ops) != 9 {
+		t.Fatalf("expect 9 ops but got %d", len(tc.Ops))
+	}
+
+	// length of the torn value is not allocated ahead of the stream
+	torn := append(append([]byte(nil), b...), byte(OpInsert), 2, 0x80, 0x80, 0x80, 0x80, 0x01)
+	if tc, err = ReadTrace(bytes.NewReader(torn), nil); err != nil || len(tc.Ops) != 10 {
+		t.Fatalf("expect 10 ops but got %+v", err)
+	}
+
+	if _, err := ReadTrace(bytes.NewReader(b[:20]), nil); err == nil {
+		t.Fatalf("expect error reading truncated header but got none")
+	}
+}
+
+func TestTraceShrink(t *testing.T) {
+	errBadKey := errors.New("bad key")
+	replayCheck = func(tr *BPlusTree) error {
+		if _, err := tr.Find(42); err == nil {
+			return errBadKey
+		}
+		return tr.Check()
+	}
+	defer func() { replayCheck = (*BPlusTree).Check }()
+
+	tr := newTree(t, 4, 0, 0)
+	buf := recordRandomOps(t, tr, 1, 300)
+	tc, err := ReadTrace(buf, nil)
+	if err != nil {
+		t.Fatalf("error reading trace: %+v", err)
+	}
+
+	tc.Ops = append(tc.Ops, Op{Kind: OpInsert, Key: 42, Value: 42})
+	_, err = Replay(tc)
+	rerr := &ReplayError{}
+	if !errors.As(err, &rerr) || !errors.Is(err, errBadKey) {
+		t.Fatalf("expect replay error but got %+v", err)
+	}
+
+	st := tc.Shrink()
+	want := []Op{{Kind: OpInsert, Key: 42, Value: 42}}
+	if !reflect.DeepEqual(st.Ops, want) {
+		t.Fatalf("expect shrunk ops %+v but got %+v", want, st.Ops)
+	}
+
+	if _, err := Replay(st); !errors.Is(err, errBadKey) {
+		t.Fatalf("expect shrunk trace to fail but got %+v", err)
+	}
+}
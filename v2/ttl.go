@@ -0,0 +1,202 @@
+package v2
+
+import (
+	"container/heap"
+	"fmt"
+	"time"
+)
+
+// expireBatchSize bounds keys removed by a single batch of ExpireNow
+const expireBatchSize = 256
+
+// expiry indexes deadlines of entries inserted with a TTL
+type expiry struct {
+	// deadlines holds deadline in unix nanoseconds by key
+	deadlines map[int64]int64
+	// queue orders deadlines, items no longer in deadlines are stale
+	// and dropped once they come up
+	queue expiryQueue
+}
+
+type expiryItem struct {
+	deadline int64
+	key      int64
+}
+
+// expiryQueue is a min heap of deadlines
+type expiryQueue []expiryItem
+
+func (q expiryQueue) Len() int            { return len(q) }
+func (q expiryQueue) Less(i, j int) bool  { return q[i].deadline < q[j].deadline }
+func (q expiryQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *expiryQueue) Push(x interface{}) { *q = append(*q, x.(expiryItem)) }
+func (q *expiryQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+func newExpiry() *expiry {
+	return &expiry{deadlines: map[int64]int64{}}
+}
+
+func (x *expiry) set(key, deadline int64) {
+	x.deadlines[key] = deadline
+	heap.Push(&x.queue, expiryItem{deadline: deadline, key: key})
+
+	// rebuild queue once stale items outnumber live ones
+	if len(x.queue) > 2*len(x.deadlines)+expireBatchSize {
+		x.queue = x.queue[:0]
+		for k, d := range x.deadlines {
+			x.queue = append(x.queue, expiryItem{deadline: d, key: k})
+		}
+		heap.Init(&x.queue)
+	}
+}
+
+func (x *expiry) forget(key int64) {
+	delete(x.deadlines, key)
+}
+
+// expired tells whether key has a deadline no later than now
+func (x *expiry) expired(key, now int64) bool {
+	d, ok := x.deadlines[key]
+	return ok && d <= now
+}
+
+// popExpired appends to keys at most n keys expired by now and forgets
+// their deadlines
+func (x *expiry) popExpired(now int64, keys []int64, n int) []int64 {
+	for len(keys) < n && len(x.queue) > 0 && x.queue[0].deadline <= now {
+		item := heap.Pop(&x.queue).(expiryItem)
+		if d, ok := x.deadlines[item.key]; ok && d == item.deadline {
+			delete(x.deadlines, item.key)
+			keys = append(keys, item.key)
+		}
+	}
+
+	return keys
+}
+
+// now returns current time of clock of tr in unix nanoseconds
+func (tr *BPlusTree) now() int64 {
+	if tr.clock == nil {
+		return time.Now().UnixNano()
+	}
+
+	return tr.clock().UnixNano()
+}
+
+// expired tells whether key has expired at now, which is taken once by
+// callers checking many keys
+func (tr *BPlusTree) expired(key, now int64) bool {
+	return tr.ttl != nil && tr.ttl.expired(key, now)
+}
+
+// dropExpired removes key if it has expired, so that it is inserted
+// anew as if it were gone
+func (tr *BPlusTree) dropExpired(key int64) {
+	if tr.ttl != nil && tr.ttl.expired(key, tr.now()) {
+		tr.ttl.forget(key)
+		tr.remove(key)
+	}
+}
+
+// expiredCount returns number of keys expired at now, all of which are
+// still in tree
+func (tr *BPlusTree) expiredCount(now int64) int {
+	if tr.ttl == nil {
+		return 0
+	}
+
+	n := 0
+	for _, d := range tr.ttl.deadlines {
+		if d <= now {
+			n++
+		}
+	}
+
+	return n
+}
+
+// withoutExpired returns tr if no key has expired at now, otherwise a
+// copy of tr without expired keys built up from full nodes, values are
+// shared with tr
+func (tr *BPlusTree) withoutExpired(now int64) *BPlusTree {
+	if tr.expiredCount(now) == 0 {
+		return tr
+	}
+
+	bl := newBulkLoader(tr.cfg.withCapacity(tr.cfg.leafCap, tr.cfg.innerCap))
+	for tn := tr.firstLeaf(); tn != nil; tn = tn.next() {
+		for i, k := range tn.keys[:len(tn.keys)-1] {
+			if !tr.expired(k, now) {
+				// keys of tr are in ascending order already
+				bl.add(k, tn.ptrs[i])
+			}
+		}
+	}
+
+	return bl.build()
+}
+
+// forgetTTL drops deadline of key whose value is replaced or removed
+func (tr *BPlusTree) forgetTTL(key int64) {
+	if tr.ttl != nil {
+		tr.ttl.forget(key)
+	}
+}
+
+// InsertWithTTL inserts key with value which expires after ttl by clock
+// of tree. Expired entries are hidden from lookups, iteration, deletes
+// and saved trees, and replaced by inserts as if they were gone, but
+// they are kept in tree and counted by Len and Aggregate until
+// ExpireNow removes them. Deadlines of entries not expired yet are not
+// saved. A value replaced by Insert no longer expires, a key kept by
+// DupIgnore keeps its deadline.
+func (tr *BPlusTree) InsertWithTTL(key int64, value interface{}, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("ttl should be positive: %v", ttl)
+	}
+
+	exists, err := tr.insert(NewEntry(key, value))
+	if err != nil {
+		return err
+	}
+
+	if exists && tr.cfg.dup == DupIgnore {
+		return nil
+	}
+
+	if tr.ttl == nil {
+		tr.ttl = newExpiry()
+	}
+	tr.ttl.set(key, tr.now()+int64(ttl))
+	return nil
+}
+
+// ExpireNow removes entries expired by now from tree in batches of
+// DeleteBatch and returns the number of entries removed. Like other
+// writes it must not run concurrently with other operations, e.g. run
+// it periodically under the lock guarding tree.
+func (tr *BPlusTree) ExpireNow() int {
+	if tr.ttl == nil {
+		return 0
+	}
+
+	now := tr.now()
+	removed := 0
+	keys := make([]int64, 0, expireBatchSize)
+	for {
+		keys = tr.ttl.popExpired(now, keys[:0], expireBatchSize)
+		if len(keys) == 0 {
+			return removed
+		}
+
+		if err := tr.deleteBatch(keys, nil, false); err != nil {
+			tr.cfg.log.Errorf("error removing expired keys: %v", err)
+		}
+		removed += len(keys)
+	}
+}
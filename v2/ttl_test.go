@@ -0,0 +1,381 @@
+package v2
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeClock is advanced by tests only
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func newTTLTree(t *testing.T, opts ...Option) (*BPlusTree, *fakeClock) {
+	c := &fakeClock{t: time.Unix(1000, 0)}
+	tr, err := NewTree(append(opts, WithClock(c.now))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tr, c
+}
+
+func TestTTLHidden(t *testing.T) {
+	tr, c := newTTLTree(t, WithFanout(3))
+	for k := int64(1); k <= 10; k++ {
+		var err error
+		if k%2 == 0 {
+			err = tr.InsertWithTTL(k, int(k), time.Duration(k)*time.Second)
+		} else {
+			err = tr.Insert(&Entry{key: k, pointer: int(k)})
+		}
+		if err != nil {
+			t.Fatalf("error inserting key %d: %+v", k, err)
+		}
+	}
+
+	// keys 2, 4 and 6 expire
+	c.advance(6 * time.Second)
+	for _, k := range []int64{2, 4, 6} {
+		if _, err := tr.Find(k); err != ErrKeyNotFound {
+			t.Fatalf("expect key %d expired but got %+v", k, err)
+		}
+	}
+
+	if v, err := tr.Find(8); err != nil || v != 8 {
+		t.Fatalf("expect value 8 of key 8 but got %v, %+v", v, err)
+	}
+
+	var keys []int64
+	tr.Range(minKey, maxKey, func(key int64, value interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if want := []int64{1, 3, 5, 7, 8, 9, 10}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("expect keys %v but got %v", want, keys)
+	}
+
+	if k, _, err := tr.Floor(6); err != nil || k != 5 {
+		t.Fatalf("expect floor 5 of key 6 but got %d, %+v", k, err)
+	}
+
+	if k, _, err := tr.Ceiling(4); err != nil || k != 5 {
+		t.Fatalf("expect ceiling 5 of key 4 but got %d, %+v", k, err)
+	}
+
+	if _, _, err := tr.Floor(0); err != ErrKeyNotFound {
+		t.Fatalf("expect no floor of key 0 but got %+v", err)
+	}
+
+	// expired keys stay in tree until they are removed
+	if tr.Len() != 10 {
+		t.Fatalf("expect 10 keys but got %d", tr.Len())
+	}
+
+	if n := tr.ExpireNow(); n != 3 || tr.Len() != 7 {
+		t.Fatalf("expect 3 keys removed and 7 kept but got %d and %d", n, tr.Len())
+	}
+
+	if n := tr.ExpireNow(); n != 0 {
+		t.Fatalf("expect no key removed but got %d", n)
+	}
+
+	if err := tr.Check(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestTTLFloorExpiredRun looks for floors above a run of expired keys
+// spanning many leaves, which Floor passes walking leaves backwards
+func TestTTLFloorExpiredRun(t *testing.T) {
+	tr, c := newTTLTree(t, WithFanout(4))
+	for k := int64(0); k <= 2000; k++ {
+		var err error
+		if k%1000 == 0 {
+			err = tr.Insert(&Entry{key: k, pointer: int(k)})
+		} else {
+			err = tr.InsertWithTTL(k, int(k), time.Second)
+		}
+		if err != nil {
+			t.Fatalf("error inserting key %d: %+v", k, err)
+		}
+	}
+
+	c.advance(2 * time.Second)
+	for _, tc := range []struct {
+		key, floor int64
+	}{
+		{2000, 2000},
+		{1999, 1000},
+		{1000, 1000},
+		{999, 0},
+		{1, 0},
+		{0, 0},
+	} {
+		if k, v, err := tr.Floor(tc.key); err != nil || k != tc.floor || v != int(tc.floor) {
+			t.Fatalf("expect floor %d of key %d but got %d, %v, %+v", tc.floor, tc.key, k, v, err)
+		}
+	}
+
+	if _, _, err := tr.Floor(-1); err != ErrKeyNotFound {
+		t.Fatalf("expect no floor of key -1 but got %+v", err)
+	}
+}
+
+func TestTTLReplace(t *testing.T) {
+	tr, c := newTTLTree(t, WithFanout(4))
+	if err := tr.InsertWithTTL(1, 1, 0); err == nil {
+		t.Fatalf("expect error of zero ttl but got none")
+	}
+
+	tr.InsertWithTTL(1, 1, time.Second)
+	if err := tr.InsertWithTTL(1, 2, time.Second); err != ErrDupKey {
+		t.Fatalf("expect error %+v but got %+v", ErrDupKey, err)
+	}
+
+	// an expired key is inserted anew
+	c.advance(time.Second)
+	if err := tr.InsertWithTTL(1, 3, time.Second); err != nil {
+		t.Fatalf("error inserting expired key: %+v", err)
+	}
+
+	if v, _ := tr.Find(1); v != 3 || tr.Len() != 1 {
+		t.Fatalf("expect value 3 of the only key but got %v of %d keys", v, tr.Len())
+	}
+
+	// deleting an expired key removes it and reports it missing
+	c.advance(time.Second)
+	if err := tr.Delete(1); !errors.Is(err, ErrKeyNotFound) || tr.Len() != 0 {
+		t.Fatalf("expect error %+v and no key but got %+v and %d keys", ErrKeyNotFound, err, tr.Len())
+	}
+
+	// a replaced value no longer expires
+	tr, c = newTTLTree(t, WithFanout(4), WithDupPolicy(DupReplace))
+	tr.InsertWithTTL(1, 1, time.Second)
+	tr.Insert(&Entry{key: 1, pointer: 2})
+	c.advance(time.Hour)
+	if v, err := tr.Find(1); v != 2 || err != nil {
+		t.Fatalf("expect value 2 but got %v, %+v", v, err)
+	}
+
+	// a kept value keeps its deadline
+	tr, c = newTTLTree(t, WithFanout(4), WithDupPolicy(DupIgnore))
+	tr.InsertWithTTL(1, 1, time.Second)
+	tr.InsertWithTTL(1, 2, time.Hour)
+	c.advance(time.Second)
+	if _, err := tr.Find(1); err != ErrKeyNotFound {
+		t.Fatalf("expect key 1 expired but got %+v", err)
+	}
+}
+
+func TestTTLRandom(t *testing.T) {
+	for _, fanout := range []int{3, 4, 9, 32} {
+		tr, c := newTTLTree(t, WithFanout(fanout), WithDupPolicy(DupReplace))
+		// deadlines of model keys in seconds, zero for no deadline
+		model := map[int64]int64{}
+		sec := int64(0)
+		live := func(k int64) bool {
+			d, ok := model[k]
+			return ok && (d == 0 || d > sec)
+		}
+
+		r := rand.New(rand.NewSource(int64(fanout)))
+		for step := 0; step < 5000; step++ {
+			key := r.Int63n(400)
+			switch r.Intn(10) {
+			case 0:
+				tr.Delete(key)
+				delete(model, key)
+			case 1:
+				tr.Insert(&Entry{key: key, pointer: int(key)})
+				model[key] = 0
+			case 2:
+				c.advance(time.Second)
+				sec++
+			case 3:
+				tr.ExpireNow()
+				for k := range model {
+					if !live(k) {
+						delete(model, k)
+					}
+				}
+				if tr.Len() != len(model) {
+					t.Fatalf("fanout %d, step %d: expect %d keys but got %d", fanout, step, len(model), tr.Len())
+				}
+			default:
+				ttl := 1 + r.Int63n(10)
+				if err := tr.InsertWithTTL(key, int(key), time.Duration(ttl)*time.Second); err != nil {
+					t.Fatalf("fanout %d, step %d: error inserting key %d: %+v", fanout, step, key, err)
+				}
+				model[key] = sec + ttl
+			}
+
+			if _, err := tr.Find(key); (err == nil) != live(key) {
+				t.Fatalf("fanout %d, step %d: expect key %d live %t but got %+v", fanout, step, key, live(key), err)
+			}
+
+			n := 0
+			tr.Range(minKey, maxKey, func(k int64, v interface{}) bool {
+				if !live(k) {
+					t.Fatalf("fanout %d, step %d: expired key %d in range", fanout, step, k)
+				}
+				n++
+				return true
+			})
+
+			want := 0
+			for k := range model {
+				if live(k) {
+					want++
+				}
+			}
+
+			if n != want {
+				t.Fatalf("fanout %d, step %d: expect %d live keys but got %d", fanout, step, want, n)
+			}
+
+			if err := tr.Check(); err != nil {
+				t.Fatalf("fanout %d, step %d: %+v", fanout, step, err)
+			}
+		}
+
+		c.advance(time.Minute)
+		tr.ExpireNow()
+		for k, d := range model {
+			if d != 0 {
+				delete(model, k)
+			}
+		}
+
+		if tr.Len() != len(model) || len(tr.ttl.deadlines) != 0 {
+			t.Fatalf("fanout %d: expect %d keys and no deadline but got %d and %d", fanout, len(model), tr.Len(), len(tr.ttl.deadlines))
+		}
+	}
+}
+
+func TestTTLExpireBatches(t *testing.T) {
+	tr, c := newTTLTree(t, WithFanout(8))
+	for k := int64(0); k < 3*expireBatchSize; k++ {
+		tr.InsertWithTTL(k, k, time.Duration(1+k%5)*time.Second)
+	}
+
+	c.advance(5 * time.Second)
+	if n := tr.ExpireNow(); n != 3*expireBatchSize || tr.Len() != 0 {
+		t.Fatalf("expect %d keys removed and none kept but got %d and %d", 3*expireBatchSize, n, tr.Len())
+	}
+
+	// stale deadlines do not pile up
+	for i := 0; i < 10; i++ {
+		for k := int64(0); k < expireBatchSize; k++ {
+			tr.Delete(k)
+			tr.InsertWithTTL(k, k, time.Second)
+		}
+	}
+
+	if max := 3*expireBatchSize + 1; len(tr.ttl.queue) > max {
+		t.Fatalf("expect at most %d deadlines queued but got %d", max, len(tr.ttl.queue))
+	}
+}
+
+func TestTTLSaved(t *testing.T) {
+	tr, c := newTTLTree(t, WithFanout(4))
+	for k := int64(1); k <= 50; k++ {
+		// string values survive json as they are
+		v := strconv.FormatInt(k, 10)
+		if k%3 == 0 {
+			tr.InsertWithTTL(k, v, time.Second)
+		} else {
+			tr.Insert(&Entry{key: k, pointer: v})
+		}
+	}
+	c.advance(time.Second)
+
+	want := entries(tr)
+	if len(want) != 34 {
+		t.Fatalf("expect 34 live keys but got %d", len(want))
+	}
+
+	loads := map[string]func() (*BPlusTree, error){
+		"snapshot": func() (*BPlusTree, error) {
+			data, err := tr.MarshalBinary()
+			if err != nil {
+				return nil, err
+			}
+			ntr, _ := NewTree()
+			return ntr, ntr.UnmarshalBinary(data)
+		},
+		"json": func() (*BPlusTree, error) {
+			data, err := tr.MarshalJSON()
+			if err != nil {
+				return nil, err
+			}
+			ntr, _ := NewTree()
+			return ntr, ntr.UnmarshalJSON(data)
+		},
+		"file": func() (*BPlusTree, error) {
+			return OpenFile(saveTree(t, tr, nil), &FileOptions{VerifyOnOpen: true})
+		},
+	}
+
+	for name, load := range loads {
+		ntr, err := load()
+		if err != nil {
+			t.Fatalf("%s: error loading tree: %+v", name, err)
+		}
+
+		if got := entries(ntr); ntr.Len() != len(want) || !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: expect %d entries %v but got %d entries %v", name, len(want), want, ntr.Len(), got)
+		}
+
+		if err := ntr.Check(); err != nil {
+			t.Fatalf("%s: %+v", name, err)
+		}
+	}
+
+	// expired keys are kept in tree until removed
+	if tr.Len() != 50 {
+		t.Fatalf("expect 50 keys but got %d", tr.Len())
+	}
+}
+
+func TestTTLDeleteBatch(t *testing.T) {
+	tr, c := newTTLTree(t, WithFanout(4))
+	for k := int64(1); k <= 10; k++ {
+		tr.InsertWithTTL(k, int(k), time.Duration(k)*time.Second)
+	}
+
+	// keys 1 to 3 expire
+	c.advance(3 * time.Second)
+	err := tr.DeleteBatch([]int64{2, 5, 3}, &BatchOptions{AllOrNothing: true})
+	var berr *BatchError
+	if !errors.As(err, &berr) || berr.Applied || len(berr.Errs) != 2 || tr.Len() != 10 {
+		t.Fatalf("expect keys 2 and 3 missing and tree untouched but got %+v and %d keys", err, tr.Len())
+	}
+
+	err = tr.DeleteBatch([]int64{2, 5, 3}, nil)
+	if !errors.As(err, &berr) || !berr.Applied || len(berr.Errs) != 2 || tr.Len() != 7 {
+		t.Fatalf("expect keys 2 and 3 missing and 3 keys removed but got %+v and %d keys", err, tr.Len())
+	}
+
+	for _, ke := range berr.Errs {
+		if ke.Err != ErrKeyNotFound || ke.Key != 2 && ke.Key != 3 {
+			t.Fatalf("expect keys 2 and 3 not found but got %+v", berr.Errs)
+		}
+	}
+
+	if n := tr.ExpireNow(); n != 1 || tr.Len() != 6 {
+		t.Fatalf("expect key 1 expired and 6 keys kept but got %d and %d", n, tr.Len())
+	}
+}